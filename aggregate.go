@@ -0,0 +1,122 @@
+package main
+
+import "fmt"
+
+// Aggregate turns a rule into an aggregate rule. The object of its
+// head is the aggregated variable v, the head is derived once per group
+// of body mappings that agree on the other head variables, with v bound
+// to the aggregate of its values. The body mappings are distinct, so
+// count counts mappings rather than distinct values of v.
+//
+//	?x :outDegree count(?y) :- ?x :link ?y.
+type Aggregate struct {
+	op string
+	v  Variable
+}
+
+func newAggregate(op string, v Variable) (Aggregate, error) {
+	switch op {
+	case "count", "sum", "min", "max":
+		return Aggregate{op, v}, nil
+	}
+	return Aggregate{}, fmt.Errorf("unknown aggregate %s", op)
+}
+
+func (agg *Aggregate) String() string {
+	return fmt.Sprintf("%s(%s)", agg.op, agg.v)
+}
+
+// fold aggregates values, ok is false if they can not be aggregated,
+// e.g. sum over non numeric values
+func (agg *Aggregate) fold(values []Term) (Term, bool) {
+
+	if agg.op == "count" {
+		return Long(len(values)), true
+	}
+
+	var result Term
+	if agg.op == "sum" {
+		result = Long(0)
+	}
+
+	for _, v := range values {
+		if result == nil {
+			result = v
+			continue
+		}
+
+		switch agg.op {
+		case "sum":
+			r, ok := arith("+", result, v)
+			if !ok {
+				return nil, false
+			}
+			result = r
+		case "min", "max":
+			c, ok := compareTerms(v, result)
+			if !ok {
+				return nil, false
+			}
+			if (agg.op == "min" && c < 0) || (agg.op == "max" && c > 0) {
+				result = v
+			}
+		}
+	}
+
+	if agg.op == "sum" {
+		// sum over a single value does not check it is a number
+		if _, ok := toFloat(result); !ok {
+			return nil, false
+		}
+	}
+
+	return result, true
+}
+
+// groupVariables returns the head variables omega is grouped by
+func (agg *Aggregate) groupVariables(head *Atom) []Variable {
+	vars := make([]Variable, 0, 2)
+	for _, v := range atomVariables(head) {
+		if v != agg.v {
+			vars = append(vars, v)
+		}
+	}
+	return vars
+}
+
+// apply groups omega and returns a mapping per group, binding the
+// group variables and v to the aggregate
+func (agg *Aggregate) apply(head *Atom, omega Omega) Omega {
+
+	vars := agg.groupVariables(head)
+
+	groups := make(map[string]int)
+	omega_ := make(Omega, 0)
+	values := make([][]Term, 0)
+
+	for _, mu := range omega {
+		k := muKey(&mu, vars)
+		i, ok := groups[k]
+		if !ok {
+			i = len(omega_)
+			groups[k] = i
+			mu_ := make(Mu, len(vars)+1)
+			for _, v := range vars {
+				mu_[v] = mu[v]
+			}
+			omega_ = append(omega_, mu_)
+			values = append(values, make([]Term, 0, 1))
+		}
+		values[i] = append(values[i], mu[agg.v])
+	}
+
+	result := make(Omega, 0, len(omega_))
+	for i, mu := range omega_ {
+		if r, ok := agg.fold(values[i]); ok {
+			mu[agg.v] = r
+			result = append(result, mu)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,138 @@
+package main
+
+import "testing"
+
+func TestAggregates(t *testing.T) {
+
+	_, db := mkDatabase()
+
+	prog, err := parseProgramString(`
+		:a :weight 3.
+		:b :weight 1.
+		:c :weight 2.5.
+
+		?x :reachable ?y :- ?x :link ?y.
+		?x :reachable ?y :- ?x :link ?z, ?z :reachable ?y.
+
+		?x :outDegree count(?y) :- ?x :link ?y.
+		?x :reach count(?y) :- ?x :reachable ?y.
+		?x :minWeight min(?w) :- ?x :reachable ?y, ?y :weight ?w.
+		?x :weightSum sum(?w) :- ?x :link ?y, ?y :weight ?w.
+		?x :maxDegree max(?n) :- ?x :reachable ?y, ?y :outDegree ?n.
+	`, &db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strata, _ := prog.stratify()
+	if len(strata) != 2 || strata[1][len(strata[1])-1].head.p != Constant(":maxDegree") {
+		t.Error(":maxDegree should be stratified above :outDegree", strata)
+	}
+
+	prog.evalSeminaive(&db)
+
+	expected := []Atom{
+		Atom{Constant(":a"), Constant(":outDegree"), Long(1), false},
+		Atom{Constant(":b"), Constant(":outDegree"), Long(2), false},
+		Atom{Constant(":a"), Constant(":reach"), Long(3), false},
+		Atom{Constant(":a"), Constant(":minWeight"), Long(1), false},
+		Atom{Constant(":b"), Constant(":minWeight"), Double(2.5), false},
+		Atom{Constant(":b"), Constant(":weightSum"), Double(2.5), false},
+		Atom{Constant(":a"), Constant(":maxDegree"), Long(2), false},
+	}
+
+	for _, a := range expected {
+		if !db.knows(a) {
+			t.Error("not derived", a)
+		}
+	}
+
+	if len(db.rel(":outDegree")) != 3 || len(db.rel(":minWeight")) != 3 {
+		t.Error("wrong number of groups", decodeRel(db.rel(":outDegree")), decodeRel(db.rel(":minWeight")))
+	}
+
+	ins := db.shallowCopy()
+	ins.addAtom(newAtom(":a", ":link", ":d"))
+	prog.evalSeminaiveAppend(&db, &ins)
+
+	if !db.knows(Atom{Constant(":a"), Constant(":outDegree"), Long(2), false}) ||
+		db.knows(Atom{Constant(":a"), Constant(":outDegree"), Long(1), false}) {
+		t.Error("insert was not maintained", decodeRel(db.rel(":outDegree")))
+	}
+
+	del := db.shallowCopy()
+	del.addAtom(newAtom(":b", ":link", ":c"))
+	del.addAtom(newAtom(":b", ":link", ":d"))
+	dRed(&db, &del, &prog)
+
+	if db.knows(Atom{Constant(":b"), Constant(":outDegree"), Long(2), false}) || len(db.rel(":outDegree")) != 2 {
+		t.Error("delete was not maintained", decodeRel(db.rel(":outDegree")))
+	}
+
+	expectedDb := db.deepCopy()
+	expectedDb.clearIdb()
+	prog.evalSeminaive(&expectedDb)
+
+	if !db.equalTo(&expectedDb) || !expectedDb.equalTo(&db) {
+		t.Error("maintained database differs from reevaluation")
+	}
+}
+
+func TestAggregateDistinctMappings(t *testing.T) {
+
+	db := newDatabase()
+	prog, err := parseProgramString(`
+		:a :link :b.
+		:a :link :c.
+		:b :weight 2.
+		:c :weight 3.
+
+		?x :degree count(?y) :- ?x :link ?y, ?x :link ?y.
+		?x :weightSum sum(?w) :- ?x :link ?y, ?y :weight ?w, ?x :link ?y.
+	`, &db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog.register(&db)
+	// addAtom does not check for duplicates
+	db.addAtom(newAtom(":a", ":link", ":b"))
+	prog.evalSeminaive(&db)
+
+	// the duplicate fact and the repeated atom yield repeated mappings,
+	// which are counted once
+	for _, a := range []Atom{
+		{Constant(":a"), Constant(":degree"), Long(2), false},
+		{Constant(":a"), Constant(":weightSum"), Long(5), false},
+	} {
+		if !db.knows(a) {
+			t.Error("not derived", a, decodeRel(db.rel(a.p.(Constant))))
+		}
+	}
+}
+
+func TestAggregateErrors(t *testing.T) {
+
+	cases := []struct {
+		src       string
+		line, col int
+	}{
+		{"?x :n avg(?y) :- ?x :link ?y.", 1, 7},
+		{"?x :n count(?x) :- ?x :link ?y.", 1, 1},
+		{"?x :n count(?y) :- ?x :link ?y, ?x :n ?z.", 1, 33},
+		{":a :n count(?y).", 1, 1},
+	}
+
+	for _, c := range cases {
+		db := newDatabase()
+		_, err := parseProgramString(c.src, &db)
+		if err == nil {
+			t.Error("expected error for", c.src)
+			continue
+		}
+		pe, ok := err.(*parseError)
+		if !ok || pe.line != c.line || pe.col != c.col {
+			t.Error("wrong error", c.src, err, c.line, c.col)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import "fmt"
+
+// checkChanges tests if the facts of d can be inserted into or deleted
+// from a database
+func (d *Database) checkChanges() error {
+	for _, rels := range []map[Constant][]triple{d.edb, d.idb} {
+		for _, rel := range rels {
+			for _, t := range rel {
+				if a := t.toAtom(); !a.isStorable() {
+					return fmt.Errorf("can not store %v", a)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Apply deletes the facts of deletes from db and inserts the facts of
+// inserts in one transaction and maintains the facts derived by prog
+// in one pass, see maintain. Facts that are both deleted and inserted
+// are kept, unless db did not know them, then they are inserted. If
+// prog can not be evaluated or the changes are malformed, db is left
+// untouched and an error is returned.
+func (prog *Program) Apply(db, inserts, deletes *Database) error {
+
+	if err := prog.check(); err != nil {
+		return err
+	}
+	if err := inserts.checkChanges(); err != nil {
+		return err
+	}
+	if err := deletes.checkChanges(); err != nil {
+		return err
+	}
+
+	ins := inserts.deepCopy()
+	del := deletes.deepCopy()
+	del.removeKnown(inserts)
+
+	maintain(db, &ins, &del, prog, (*Program).dRedDelete)
+	return nil
+}
+
+// maintain deletes del from db, inserts ins and maintains the facts
+// derived by prog in one pass over its components, see counting.go.
+// Each component first loses the derivations of deleted facts over the
+// state before the insertions, recursive components by recursive,
+// then gains the derivations of new facts. Facts deleted and derived
+// again are unchanged for the components above. Strata with
+// aggregation or negation over derived or changed relations are
+// reevaluated, see splitMonotonic. With sameAs enabled, classes can
+// not be split incrementally, if sameAs facts are deleted db is
+// recomputed.
+func maintain(db, ins, del *Database, prog *Program, recursive deletion) {
+
+	if !prog.isMonotonic(ins, del) {
+		low, high := prog.splitMonotonic(ins, del)
+		maintain(db, ins, del, &low, recursive)
+		high.reevaluate(db)
+		return
+	}
+
+	if db.sameAs != nil && len(del.rel(sameAsRel)) > 0 {
+		db.remove(del)
+		db.append(ins, true)
+		db.clearIdb()
+		prog.evalSeminaive(db)
+		return
+	}
+
+	if db.sameAs != nil {
+		// deleted facts mentioning aliases are hidden, their rewritten
+		// copies are deleted instead
+		del.sameAs = db.sameAs
+		for _, a := range del.aliasCopies() {
+			if a.isStorable() && !del.knowsDerived(a) {
+				del.addDerived(a)
+			}
+		}
+	}
+
+	comps := prog.components(db)
+	for _, comp := range comps {
+		if comp.counting {
+			comp.prog.initCounts(db)
+		}
+	}
+
+	// gone holds the facts actually deleted so far. Deleted base
+	// facts that are derived as well are kept.
+	gone := db.shallowCopy()
+	gone.append(del, true)
+	gone.filter(db.knows)
+	db.remove(del)
+	if db.sameAs != nil {
+		// rewritten copies of remaining base facts may have been
+		// deleted with the ones of deleted base facts
+		db.canonicalize(db)
+		ins.canonicalize(db)
+	}
+	gone.removeKnown(db)
+
+	// delta holds the facts new so far
+	delta := db.newFacts(ins)
+	db.append(ins, true)
+
+	for _, comp := range comps {
+		if comp.prog.touches(&gone) {
+			db.withoutFacts(&delta, func() {
+				if comp.counting {
+					comp.prog.countDelete(db, &gone)
+				} else {
+					recursive(&comp.prog, db, &gone)
+				}
+			})
+		}
+		if comp.prog.touches(&delta) {
+			if comp.counting {
+				comp.prog.countInsert(db, &delta)
+			} else {
+				comp.prog.seminaiveInsert(db, &delta)
+			}
+		}
+		if !gone.empty() && !delta.empty() {
+			again := gone.deepCopy()
+			again.filter(db.knows)
+			gone.remove(&again)
+			delta.remove(&again)
+		}
+	}
+
+	// derived sameAs facts may have been deleted
+	if db.sameAs != nil && len(gone.rel(sameAsRel)) > 0 {
+		db.clearIdb()
+		prog.evalSeminaive(db)
+		return
+	}
+
+	if prog.sameAsMerged(db) {
+		prog.evalSeminaive(db)
+	}
+}
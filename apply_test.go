@@ -0,0 +1,201 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		:a :link :b .
+		:b :link :c .
+		:c :link :d .
+	`)
+	prog := mkProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+
+	// :b :link :c is both deleted and inserted, :d :link :e is new in
+	// both
+	ins := loadTestTurtle(t, ":b :link :c .\n:d :link :e .\n:x :link :a .")
+	del := loadTestTurtle(t, ":a :link :b .\n:b :link :c .\n:d :link :e .")
+	if err := prog.Apply(&db, &ins, &del); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := loadTestTurtle(t, `
+		:b :link :c .
+		:c :link :d .
+		:d :link :e .
+		:x :link :a .
+	`)
+	prog.register(&expected)
+	prog.evalSeminaive(&expected)
+
+	if facts, expectedFacts := strings.Join(allFacts(&db), "\n"), strings.Join(allFacts(&expected), "\n"); facts != expectedFacts {
+		t.Errorf("wrong facts:\n%s\nexpected:\n%s", facts, expectedFacts)
+	}
+}
+
+func TestApplyRederived(t *testing.T) {
+
+	db := loadTestTurtle(t, ":a :link :b .\n:a :a :Hub .\n:b :a :Hub .")
+	prog := parseTestProgram(t, countingRules, &db)
+	prog.evalSeminaive(&db)
+
+	// :a :reachable :b is lost with :a :link :b and derived again over
+	// :c in the same pass, the counted :hub facts above are unchanged
+	hub := newAtom(":a", ":hub", ":b")
+	ins := loadTestTurtle(t, ":a :link :c .\n:c :link :b .")
+	del := loadTestTurtle(t, ":a :link :b .")
+	if err := prog.Apply(&db, &ins, &del); err != nil {
+		t.Fatal(err)
+	}
+	if !db.knows(hub) || db.derivationCount(hub) != 1 {
+		t.Errorf("%d derivations of %v, expected 1", db.derivationCount(hub), hub)
+	}
+	checkCounting(t, prog, &db)
+}
+
+func TestApplyRandom(t *testing.T) {
+
+	rng := rand.New(rand.NewSource(1))
+	node := func() string { return ":n" + strconv.Itoa(rng.Intn(20)) }
+	randomFacts := func(n int) Database {
+		d := newDatabase()
+		for i := 0; i < n; i++ {
+			switch rng.Intn(4) {
+			case 0:
+				d.addAtom(newAtom(node(), ":a", ":Hub"))
+			case 1:
+				d.addAtom(newAtom(node(), ":has", ":Wings"))
+			default:
+				d.addAtom(newAtom(node(), ":link", node()))
+			}
+		}
+		return d
+	}
+
+	db := randomFacts(40)
+	prog := parseTestProgram(t, countingRules, &db)
+	prog.evalSeminaive(&db)
+
+	for i := 0; i < 10; i++ {
+		ins := randomFacts(4)
+		del := randomFacts(2)
+		for _, rel := range db.edb {
+			for _, tr := range rel {
+				if rng.Intn(10) == 0 {
+					del.addAtom(tr.toAtom())
+				}
+			}
+		}
+		for _, tr := range ins.edb[Constant(":link")] {
+			if rng.Intn(3) == 0 {
+				del.addAtom(tr.toAtom())
+			}
+		}
+
+		if err := prog.Apply(&db, &ins, &del); err != nil {
+			t.Fatal(err)
+		}
+		checkCounting(t, prog, &db)
+		for _, rel := range ins.edb {
+			for _, tr := range rel {
+				if !db.knows(tr.toAtom()) {
+					t.Fatalf("inserted fact %v is missing", tr.toAtom())
+				}
+			}
+		}
+	}
+}
+
+func TestApplySemiPositive(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		:a :link :b .
+		:b :link :c .
+		:c :a :Hub .
+	`)
+	prog := parseTestProgram(t, countingRules+"?x :spoke ?y :- ?x :reachable ?y, not ?y :a :Hub.\n", &db)
+	prog.evalSeminaive(&db)
+
+	// :a is not derived, the program is maintained incrementally as long
+	// as no :a facts change
+	ins := loadTestTurtle(t, ":c :link :d .")
+	del := loadTestTurtle(t, ":a :link :b .")
+	if !prog.isMonotonic(&ins, &del) {
+		t.Error("negation over an unchanged edb relation is not monotonic")
+	}
+	if err := prog.Apply(&db, &ins, &del); err != nil {
+		t.Fatal(err)
+	}
+	checkCounting(t, prog, &db)
+
+	ins = loadTestTurtle(t, ":d :a :Hub .")
+	if prog.isMonotonic(&ins) {
+		t.Error("negation over a changed relation is monotonic")
+	}
+	if low, high := prog.splitMonotonic(&ins); len(high) != 1 || len(low) != len(prog)-1 {
+		t.Errorf("wrong split: %d %d", len(low), len(high))
+	}
+	if err := prog.Apply(&db, &ins, &del); err != nil {
+		t.Fatal(err)
+	}
+	// reevaluating :spoke dropped its counts, the next incremental
+	// changes initialize them again
+	ins = loadTestTurtle(t, ":e :link :d .")
+	if err := prog.Apply(&db, &ins, &del); err != nil {
+		t.Fatal(err)
+	}
+	checkCounting(t, prog, &db)
+	if db.knows(newAtom(":b", ":spoke", ":d")) || !db.knows(newAtom(":d", ":spoke", ":b")) {
+		t.Error("wrong :spoke facts")
+	}
+}
+
+func TestApplyFailure(t *testing.T) {
+
+	db := loadTestTurtle(t, ":a :link :b .\n:b :link :c .")
+	prog := mkProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+	before := db.deepCopy()
+
+	ins := newDatabase()
+	ins.addAtom(Atom{Long(1), Constant(":link"), Constant(":a"), false})
+	if err := prog.Apply(&db, &ins, &ins); err == nil {
+		t.Error("malformed changes were applied")
+	}
+	if !db.equalTo(&before) || !before.equalTo(&db) {
+		t.Error("db changed by malformed changes")
+	}
+
+	// the head variable ?w is unbound, the rule is rejected before db
+	// is changed
+	bad := append(mkProgram(), Rule{
+		head: newAtom("?x", ":bad", "?w"),
+		body: []Atom{newAtom("?x", ":link", "?y")}})
+	ins = loadTestTurtle(t, ":c :link :d .")
+	del := loadTestTurtle(t, ":a :link :b .")
+	if err := bad.Apply(&db, &ins, &del); err == nil {
+		t.Fatal("unsafe rule not reported")
+	}
+	if !db.equalTo(&before) || !before.equalTo(&db) {
+		t.Fatal("db changed by unsafe rule")
+	}
+	if err := bad.register(&db); err == nil {
+		t.Error("unsafe rule registered")
+	}
+
+	// db is still usable
+	if err := prog.Apply(&db, &ins, &del); err != nil {
+		t.Fatal(err)
+	}
+	if !db.knows(newAtom(":b", ":reachable", ":d")) || db.knows(newAtom(":a", ":reachable", ":c")) {
+		t.Error("wrong facts after failure")
+	}
+}
@@ -78,6 +78,14 @@ func runDRed(prog Program, db, append1, append2 Database) (Database, Database) {
 	return db_after, db
 }
 
+func runBF(prog Program, db, append1, append2 Database) (Database, Database) {
+	prog.evalSeminaiveAppend(&db, &append1)
+	backwardForward(&db, &append1, &prog)
+	db_after := db.deepCopy()
+	prog.evalSeminaiveAppend(&db, &append2)
+	return db_after, db
+}
+
 func runCommitRevert(prog Program, db, append1, append2 Database) (Database, Database) {
 	db.commit()
 	prog.evalSeminaiveAppend(&db, &append1)
@@ -172,6 +180,9 @@ func benchmark() {
 		startCR := time.Now()
 		intermedDbCR, dbAfterCR := runCommitRevert(prog, db.deepCopy(), dbExt1.deepCopy(), dbExt2.deepCopy())
 		elapsedCR := time.Since(startCR)
+		startBF := time.Now()
+		intermedDbBF, dbAfterBF := runBF(prog, db.deepCopy(), dbExt1.deepCopy(), dbExt2.deepCopy())
+		elapsedBF := time.Since(startBF)
 
 		if !intermedDbNoInc.equalTo(&intermedDbDRed) {
 			panic("iterm. NoInc != DRed")
@@ -197,6 +208,14 @@ func benchmark() {
 			panic("final DRed != CR")
 		}
 
+		if !intermedDbDRed.equalTo(&intermedDbBF) {
+			panic("iterm. DRed != BF")
+		}
+
+		if !dbAfterDRed.equalTo(&dbAfterBF) {
+			panic("final DRed != BF")
+		}
+
 		// if !dbAfterNoInc.equalTo(&dbAfterDRed) || !dbAfterDRed.equalTo(&dbAfterCR) {
 		// 	panic("dabase instances were not equal, aborting.")
 		// }
@@ -204,7 +223,8 @@ func benchmark() {
 		fmt.Println(nNodes, nEdges, nEdgesExt,
 			uint64(elapsedNoInc/time.Millisecond),
 			uint64(elapsedDRed/time.Millisecond),
-			uint64(elapsedCR/time.Millisecond))
+			uint64(elapsedCR/time.Millisecond),
+			uint64(elapsedBF/time.Millisecond))
 	}
 
 }
@@ -0,0 +1,195 @@
+package main
+
+// The Backward/Forward algorithm (Motik, Nenov, Piro, Horrocks 2015)
+// maintains recursive components after deletions as an alternative to
+// dRed. Where dRed deletes every fact with a derivation using a deleted
+// fact and rederives the ones that are still derivable, B/F checks
+// each such fact for an alternative proof by backward chaining first
+// and only propagates the facts without one forward. Deletions with
+// few real consequences thus stay cheap.
+
+// bfCheck holds the state of a B/F run over a component
+type bfCheck struct {
+	db   *Database
+	prog *Program
+	// forward has a delta rule per body atom, backward a delta rule
+	// matching the head, see toAltDeriveDeltaProgram
+	forward  []DeltaRule
+	backward []DeltaRule
+	checked  map[triple]bool
+	proved   map[triple]bool
+	deleted  map[triple]bool
+}
+
+func newBfCheck(db *Database, prog *Program) *bfCheck {
+	s := &bfCheck{
+		db:       db,
+		prog:     prog,
+		forward:  make([]DeltaRule, 0),
+		backward: prog.toAltDeriveDeltaProgram().drules,
+		checked:  make(map[triple]bool),
+		proved:   make(map[triple]bool),
+		deleted:  make(map[triple]bool),
+	}
+	for _, r := range *prog {
+		s.forward = append(s.forward, r.toDeltaRules(db, false)...)
+	}
+	return s
+}
+
+func (s *bfCheck) key(a Atom) triple {
+	if s.db.sameAs != nil {
+		a = s.db.canonicalAtom(&a)
+	}
+	return encodeAtom(&a)
+}
+
+// single returns a delta database holding only a
+func (s *bfCheck) single(a Atom) Database {
+	d := s.db.shallowCopy()
+	d.addDerived(a)
+	return d
+}
+
+// derives tests if the component may derive facts of the relation of a
+func (s *bfCheck) derives(a *Atom) bool {
+	for _, r := range *s.prog {
+		if defines(relationOf(&r.head), relationOf(a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// holds tests if the body fact a still holds. Facts of the component
+// must be proved, if check is set they are checked first.
+func (s *bfCheck) holds(a Atom, check bool) bool {
+	t := s.key(a)
+	if s.deleted[t] {
+		return false
+	}
+	if !s.derives(&a) || s.db.knowsBase(a) {
+		return s.db.knows(a)
+	}
+	if check && !s.checked[t] {
+		s.check(a)
+	}
+	return s.proved[t]
+}
+
+// instanceHolds tests if the body facts of the rule instance of dr
+// given by mu hold. The delta atom is the fact checked or proved.
+func (s *bfCheck) instanceHolds(dr *DeltaRule, mu *Mu, check bool) bool {
+	for _, a := range dr.body {
+		if !s.holds(a.bind(mu), check) {
+			return false
+		}
+	}
+	return true
+}
+
+// check searches a proof of a by backward chaining over the rule
+// instances deriving a
+func (s *bfCheck) check(a Atom) {
+	t := s.key(a)
+	if s.checked[t] {
+		return
+	}
+	s.checked[t] = true
+
+	if s.db.knowsBase(a) {
+		s.prove(a)
+		return
+	}
+
+	single := s.single(a)
+	for i := range s.backward {
+		dr := &s.backward[i]
+		for _, mu := range dr.eval(s.db, &single) {
+			if s.proved[t] {
+				return
+			}
+			if s.instanceHolds(dr, &mu, true) {
+				s.prove(a)
+				return
+			}
+		}
+	}
+}
+
+// prove marks a as proved and proves the checked facts it completes a
+// proof of. Facts checked while a was still being checked would be
+// left unproved otherwise.
+func (s *bfCheck) prove(a Atom) {
+	t := s.key(a)
+	if s.proved[t] {
+		return
+	}
+	s.proved[t] = true
+
+	single := s.single(a)
+	for i := range s.forward {
+		dr := &s.forward[i]
+		for _, mu := range dr.eval(s.db, &single) {
+			h := dr.head.bind(&mu)
+			if !h.isStorable() {
+				continue
+			}
+			th := s.key(h)
+			if s.checked[th] && !s.proved[th] && s.instanceHolds(dr, &mu, false) {
+				s.prove(h)
+			}
+		}
+	}
+}
+
+// consequences returns the facts with a derivation using a fact of
+// delta
+func (s *bfCheck) consequences(delta *Database) []Atom {
+	as := make([]Atom, 0)
+	for i := range s.forward {
+		dr := &s.forward[i]
+		for _, mu := range dr.eval(s.db, delta) {
+			if h := dr.head.bind(&mu); h.isStorable() {
+				as = append(as, h)
+			}
+		}
+	}
+	return as
+}
+
+// bfDelete deletes the facts of prog that are no longer derivable
+// without the deleted facts gone, which db no longer contains, and adds
+// them to gone
+func (prog *Program) bfDelete(db, gone *Database) {
+	s := newBfCheck(db, prog)
+
+	var todo []Atom
+	db.withFacts(gone, func() {
+		todo = s.consequences(gone)
+	})
+
+	deleted := db.shallowCopy()
+	for len(todo) > 0 {
+		a := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+
+		t := s.key(a)
+		if s.deleted[t] || !db.knowsDerived(a) {
+			continue
+		}
+
+		s.check(a)
+		if s.proved[t] {
+			continue
+		}
+
+		s.deleted[t] = true
+		deleted.addDerived(a)
+		single := s.single(a)
+		todo = append(todo, s.consequences(&single)...)
+	}
+
+	db.remove(&deleted)
+	gone.append(&deleted, false)
+}
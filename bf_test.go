@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestBackwardForward(t *testing.T) {
+
+	// :d stays reachable from :a over :c, the cycle :e, :f loses its
+	// only entry
+	db := loadTestTurtle(t, `
+		:a :link :b , :c .
+		:b :link :d .
+		:c :link :d .
+		:d :link :e .
+		:e :link :f .
+		:f :link :e .
+	`)
+	prog := mkProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+
+	del := loadTestTurtle(t, ":b :link :d .\n:d :link :e .")
+	backwardForward(&db, &del, &prog)
+
+	expected := loadTestTurtle(t, `
+		:a :link :b , :c .
+		:c :link :d .
+		:e :link :f .
+		:f :link :e .
+	`)
+	prog.register(&expected)
+	prog.evalSeminaive(&expected)
+
+	if facts, expectedFacts := strings.Join(allFacts(&db), "\n"), strings.Join(allFacts(&expected), "\n"); facts != expectedFacts {
+		t.Errorf("wrong facts:\n%s\nexpected:\n%s", facts, expectedFacts)
+	}
+}
+
+func TestBackwardForwardRandom(t *testing.T) {
+
+	rand.Seed(1)
+	prog := mkProgram()
+
+	for nEdgesExt := 1; nEdgesExt < 30; nEdgesExt += 9 {
+		db, dbExt1, dbExt2 := genRngGraph(300, 250, nEdgesExt)
+		prog.register(&db)
+		prog.register(&dbExt1)
+		prog.register(&dbExt2)
+		prog.evalSeminaive(&db)
+
+		intermedDRed, afterDRed := runDRed(prog, db.deepCopy(), dbExt1.deepCopy(), dbExt2.deepCopy())
+		intermedBF, afterBF := runBF(prog, db.deepCopy(), dbExt1.deepCopy(), dbExt2.deepCopy())
+
+		if !intermedDRed.equalTo(&intermedBF) || !afterDRed.equalTo(&afterBF) {
+			t.Fatal("B/F and dRed differ for", nEdgesExt, "new edges")
+		}
+	}
+
+	// recursive components above and below counted ones
+	rng := rand.New(rand.NewSource(2))
+	db := newDatabase()
+	for i := 0; i < 60; i++ {
+		db.addAtom(newAtom(":n"+string(rune('a'+rng.Intn(20))), ":link", ":n"+string(rune('a'+rng.Intn(20)))))
+	}
+	prog = parseTestProgram(t, countingRules, &db)
+	prog.evalSeminaive(&db)
+
+	for i := 0; i < 5; i++ {
+		del := newDatabase()
+		for _, tr := range db.edb[Constant(":link")] {
+			if rng.Intn(10) == 0 {
+				del.addAtom(tr.toAtom())
+			}
+		}
+		db_ := db.deepCopy()
+		dRed(&db_, &del, &prog)
+		backwardForward(&db, &del, &prog)
+		if !db.equalTo(&db_) || !db_.equalTo(&db) {
+			t.Fatal("B/F and dRed differ")
+		}
+		checkCounting(t, prog, &db)
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Builtin is a body atom evaluated over the mappings of a rule instead
+// of being looked up in the database. Comparisons and the string
+// tests prefix and regex are filters, arithmetic (+, -, *, /),
+// concat and = bind out to their result; if out is already bound they
+// filter on equality instead.
+//
+//	?x >= 18           Builtin{op: ">=", args: [?x, 18]}
+//	?z = ?x + ?y       Builtin{op: "+", out: ?z, args: [?x, ?y]}
+//	?z = ?x            Builtin{op: "=", out: ?z, args: [?x]}
+//	?z = concat(?x, ?y)
+//	prefix(?x, "ab")
+//	regex(?x, "^a.*b$")
+type Builtin struct {
+	op   string
+	out  Variable
+	args []Term
+	re   *regexp.Regexp
+}
+
+var builtinArity = map[string]int{
+	"<": 2, "<=": 2, ">": 2, ">=": 2, "!=": 2,
+	"+": 2, "-": 2, "*": 2, "/": 2,
+	"=": -1, "concat": -1, "prefix": 2, "regex": 2,
+}
+
+func isBinder(op string) bool {
+	switch op {
+	case "+", "-", "*", "/", "concat", "=":
+		return true
+	}
+	return false
+}
+
+// newBuiltin creates a builtin, out must be given for binders and
+// empty for filters. A "=" without out compares its two args.
+func newBuiltin(op string, out Variable, args ...Term) (Builtin, error) {
+	b := Builtin{op: op, out: out, args: args}
+
+	arity, ok := builtinArity[op]
+	if !ok {
+		return b, fmt.Errorf("unknown builtin %s", op)
+	}
+
+	switch {
+	case op == "=" && out == "" && len(args) != 2:
+		return b, fmt.Errorf("= compares exactly two terms")
+	case op == "=" && out != "" && len(args) != 1:
+		return b, fmt.Errorf("= binds exactly one term")
+	case arity > 0 && len(args) != arity:
+		return b, fmt.Errorf("%s expects %d arguments", op, arity)
+	case op != "=" && isBinder(op) && out == "":
+		return b, fmt.Errorf("result of %s must be bound to a variable", op)
+	case !isBinder(op) && out != "":
+		return b, fmt.Errorf("%s can not be bound to a variable", op)
+	}
+
+	if op == "regex" {
+		if pat, ok := args[1].(String); ok {
+			re, err := regexp.Compile(string(pat))
+			if err != nil {
+				return b, err
+			}
+			b.re = re
+		}
+	}
+
+	return b, nil
+}
+
+func mustBuiltin(op string, out Variable, args ...Term) Builtin {
+	b, err := newBuiltin(op, out, args...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return b
+}
+
+// inputs returns the variables that need to be bound before b can be
+// evaluated
+func (b *Builtin) inputs() []Variable {
+	vars := make([]Variable, 0, len(b.args))
+	for _, t := range b.args {
+		if isVariable(t) {
+			vars = append(vars, t.(Variable))
+		}
+	}
+	return vars
+}
+
+// ready tests if b can be evaluated once the variables in bound are
+// known. A "=" binding a variable to another is turned around if only
+// out is bound.
+func (b *Builtin) ready(bound map[Variable]bool) bool {
+	inputsBound := true
+	for _, v := range b.inputs() {
+		if !bound[v] {
+			inputsBound = false
+		}
+	}
+	if inputsBound {
+		return true
+	}
+	if b.op == "=" && b.out != "" && bound[b.out] && isVariable(b.args[0]) {
+		b.out, b.args = b.args[0].(Variable), []Term{b.out}
+		return true
+	}
+	return false
+}
+
+func (b *Builtin) String() string {
+	if b.op == "=" && b.out != "" {
+		return fmt.Sprintf("%s = %v", b.out, b.args[0])
+	}
+	switch b.op {
+	case "<", "<=", ">", ">=", "!=", "=":
+		return fmt.Sprintf("%v %s %v", b.args[0], b.op, b.args[1])
+	case "+", "-", "*", "/":
+		return fmt.Sprintf("%s = %v %s %v", b.out, b.args[0], b.op, b.args[1])
+	}
+	args := make([]string, 0, len(b.args))
+	for _, a := range b.args {
+		args = append(args, fmt.Sprint(a))
+	}
+	call := b.op + "(" + strings.Join(args, ", ") + ")"
+	if b.out != "" {
+		return string(b.out) + " = " + call
+	}
+	return call
+}
+
+func toFloat(t Term) (float64, bool) {
+	switch n := t.(type) {
+	case Long:
+		return float64(n), true
+	case Double:
+		return float64(n), true
+	case Decimal:
+		f, err := strconv.ParseFloat(string(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// compareTerms orders numbers numerically, strings and constants
+// lexically. ok is false for terms of incomparable types. Decimals
+// are compared exactly with longs and decimals.
+func compareTerms(t1, t2 Term) (int, bool) {
+	if isDecimal(t1) || isDecimal(t2) {
+		if r1, ok := toRat(t1); ok {
+			if r2, ok := toRat(t2); ok {
+				return r1.Cmp(r2), true
+			}
+		}
+	}
+	if l1, ok := t1.(Long); ok {
+		if l2, ok := t2.(Long); ok {
+			switch {
+			case l1 < l2:
+				return -1, true
+			case l1 > l2:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	if f1, ok := toFloat(t1); ok {
+		f2, ok := toFloat(t2)
+		if !ok || math.IsNaN(f1) || math.IsNaN(f2) {
+			return 0, false
+		}
+		switch {
+		case f1 < f2:
+			return -1, true
+		case f1 > f2:
+			return 1, true
+		}
+		return 0, true
+	}
+	s1, ok1 := t1.(String)
+	s2, ok2 := t2.(String)
+	if ok1 && ok2 {
+		return strings.Compare(string(s1), string(s2)), true
+	}
+	c1, ok1 := t1.(Constant)
+	c2, ok2 := t2.(Constant)
+	if ok1 && ok2 {
+		return strings.Compare(string(c1), string(c2)), true
+	}
+	return 0, false
+}
+
+// valueEqual compares terms by value, so that 1 = 1.0
+func valueEqual(t1, t2 Term) bool {
+	if c, ok := compareTerms(t1, t2); ok {
+		return c == 0
+	}
+	return termEqual(t1, t2)
+}
+
+func isDecimal(t Term) bool {
+	_, ok := t.(Decimal)
+	return ok
+}
+
+// arith applies the arithmetic operator op. Longs stay longs unless
+// divided with a remainder or overflowing, then they become decimals,
+// decimals and longs are computed exactly as long as the result has a
+// finite decimal expansion, anything else is computed as double.
+func arith(op string, t1, t2 Term) (Term, bool) {
+	l1, ok1 := t1.(Long)
+	l2, ok2 := t2.(Long)
+	overflow := false
+	if ok1 && ok2 {
+		if op == "/" && l2 == 0 {
+			return nil, false
+		}
+		l, ok := longArith(op, l1, l2)
+		if ok {
+			return l, true
+		}
+		overflow = op != "/" || l1%l2 == 0
+	}
+
+	if overflow || isDecimal(t1) || isDecimal(t2) {
+		r1, ok1 := toRat(t1)
+		r2, ok2 := toRat(t2)
+		if ok1 && ok2 {
+			r := new(big.Rat)
+			switch op {
+			case "+":
+				r.Add(r1, r2)
+			case "-":
+				r.Sub(r1, r2)
+			case "*":
+				r.Mul(r1, r2)
+			case "/":
+				if r2.Sign() == 0 {
+					return nil, false
+				}
+				r.Quo(r1, r2)
+			}
+			if d, ok := ratToDecimal(r); ok {
+				return d, true
+			}
+		}
+	}
+
+	f1, ok1 := toFloat(t1)
+	f2, ok2 := toFloat(t2)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	switch op {
+	case "+":
+		return Double(f1 + f2), true
+	case "-":
+		return Double(f1 - f2), true
+	case "*":
+		return Double(f1 * f2), true
+	case "/":
+		if f2 == 0 {
+			return nil, false
+		}
+		return Double(f1 / f2), true
+	}
+	return nil, false
+}
+
+// longArith applies op to l1 and l2, ok is false if the result
+// overflows or is no integer
+func longArith(op string, l1, l2 Long) (Long, bool) {
+	switch op {
+	case "+":
+		l := l1 + l2
+		return l, (l > l1) == (l2 > 0)
+	case "-":
+		l := l1 - l2
+		return l, (l < l1) == (l2 > 0)
+	case "*":
+		if l1 == 0 || l2 == 0 {
+			return 0, true
+		}
+		l := l1 * l2
+		return l, l/l2 == l1 && !(l1 == -1 && l2 == math.MinInt64) && !(l2 == -1 && l1 == math.MinInt64)
+	case "/":
+		if l1%l2 != 0 || l1 == math.MinInt64 && l2 == -1 {
+			return 0, false
+		}
+		return l1 / l2, true
+	}
+	return 0, false
+}
+
+// stringValue returns the text of strings and numbers
+func stringValue(t Term) (string, bool) {
+	switch l := t.(type) {
+	case String:
+		return string(l), true
+	case Long, Double, Decimal:
+		lex, _ := literalLexical(l.(Literal))
+		return lex, true
+	}
+	return "", false
+}
+
+// apply evaluates b on mu. ok is false if mu is filtered out,
+// otherwise the result is mu, extended by out for binders.
+func (b *Builtin) apply(mu Mu) (Mu, bool) {
+
+	args := make([]Term, len(b.args))
+	for i, t := range b.args {
+		if isVariable(t) {
+			v, ok := mu[t.(Variable)]
+			if !ok {
+				panic(fmt.Sprintf("unbound variable %s in builtin %s", t, b))
+			}
+			args[i] = v
+		} else {
+			args[i] = t
+		}
+	}
+
+	var result Term
+
+	switch b.op {
+	case "<", "<=", ">", ">=":
+		c, ok := compareTerms(args[0], args[1])
+		if !ok {
+			return mu, false
+		}
+		switch b.op {
+		case "<":
+			return mu, c < 0
+		case "<=":
+			return mu, c <= 0
+		case ">":
+			return mu, c > 0
+		}
+		return mu, c >= 0
+	case "!=":
+		return mu, !valueEqual(args[0], args[1])
+	case "=":
+		if b.out == "" {
+			return mu, valueEqual(args[0], args[1])
+		}
+		result = args[0]
+	case "+", "-", "*", "/":
+		r, ok := arith(b.op, args[0], args[1])
+		if !ok {
+			return mu, false
+		}
+		result = r
+	case "concat":
+		var sb strings.Builder
+		for _, a := range args {
+			s, ok := stringValue(a)
+			if !ok {
+				return mu, false
+			}
+			sb.WriteString(s)
+		}
+		result = String(sb.String())
+	case "prefix":
+		s, ok1 := stringValue(args[0])
+		p, ok2 := stringValue(args[1])
+		return mu, ok1 && ok2 && strings.HasPrefix(s, p)
+	case "regex":
+		s, ok1 := stringValue(args[0])
+		p, ok2 := args[1].(String)
+		if !ok1 || !ok2 {
+			return mu, false
+		}
+		re := b.re
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile(string(p)); err != nil {
+				return mu, false
+			}
+		}
+		return mu, re.MatchString(s)
+	default:
+		panic("unknown builtin " + b.op)
+	}
+
+	if v, ok := mu[b.out]; ok {
+		return mu, valueEqual(v, result)
+	}
+
+	mu_ := make(Mu, len(mu)+1)
+	for k, v := range mu {
+		mu_[k] = v
+	}
+	mu_[b.out] = result
+	return mu_, true
+}
+
+// filter applies b to all mappings of omega
+func (b *Builtin) filter(omega Omega) Omega {
+	omega_ := make(Omega, 0, len(omega))
+	for _, mu := range omega {
+		if mu_, ok := b.apply(mu); ok {
+			omega_ = append(omega_, mu_)
+		}
+	}
+	return omega_
+}
+
+// boundVariables returns the variables bound by the positive atoms of
+// body and the binders among builtins. It fails if a builtin has an
+// input that is never bound.
+func boundVariables(body []Atom, builtins []Builtin) (map[Variable]bool, error) {
+	bound := make(map[Variable]bool)
+	for _, a := range body {
+		if !a.neg {
+			for _, v := range atomVariables(&a) {
+				bound[v] = true
+			}
+		}
+	}
+
+	todo := make([]Builtin, len(builtins))
+	copy(todo, builtins)
+
+	for len(todo) > 0 {
+		progress := false
+		for i := 0; i < len(todo); i++ {
+			if todo[i].ready(bound) {
+				if todo[i].out != "" {
+					bound[todo[i].out] = true
+				}
+				todo = append(todo[:i], todo[i+1:]...)
+				i--
+				progress = true
+			}
+		}
+		if !progress {
+			for _, v := range todo[0].inputs() {
+				if !bound[v] {
+					return bound, fmt.Errorf("variable %s of builtin %s is not bound by a positive atom", v, &todo[0])
+				}
+			}
+		}
+	}
+
+	return bound, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBuiltinFilter(t *testing.T) {
+
+	db := newDatabase()
+
+	prog, err := parseProgramString(`
+		:ann :age 17.
+		:bob :age 18.
+		:cid :age 40.
+
+		?x :adult :yes :- ?x :age ?a, ?a >= 18.
+		?x :teen :yes :- ?x :age ?a, ?a<18, ?a > 12.
+	`, &db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prog.evalSeminaive(&db)
+
+	if db.knows(newAtom(":ann", ":adult", ":yes")) || !db.knows(newAtom(":bob", ":adult", ":yes")) ||
+		!db.knows(newAtom(":cid", ":adult", ":yes")) {
+		t.Error("wrong adults", decodeRel(db.rel(":adult")))
+	}
+
+	if len(db.rel(":teen")) != 1 || !db.knows(newAtom(":ann", ":teen", ":yes")) {
+		t.Error("wrong teens", decodeRel(db.rel(":teen")))
+	}
+}
+
+func TestBuiltinBinders(t *testing.T) {
+
+	db := newDatabase()
+
+	prog, err := parseProgramString(`
+		:a :x 2.
+		:a :y 3.5.
+		:a :name "Alice".
+		:b :name "Bob".
+
+		?s :sum ?z :- ?s :x ?a, ?s :y ?b, ?z = ?a + ?b.
+		?s :twice ?z :- ?z = ?a * 2, ?s :x ?a.
+		?s :label ?l :- ?s :name ?n, ?l = concat("the ", ?n, " ", 1).
+		?s :isA :yes :- ?s :name ?n, prefix(?n, "A").
+		?s :isB :yes :- ?s :name ?n, regex(?n, "^B.b$").
+	`, &db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prog.evalSeminaive(&db)
+
+	expected := []Atom{
+		Atom{Constant(":a"), Constant(":sum"), Double(5.5), false},
+		Atom{Constant(":a"), Constant(":twice"), Long(4), false},
+		Atom{Constant(":a"), Constant(":label"), String("the Alice 1"), false},
+		Atom{Constant(":a"), Constant(":isA"), Constant(":yes"), false},
+		Atom{Constant(":b"), Constant(":isB"), Constant(":yes"), false},
+	}
+
+	for _, a := range expected {
+		if !db.knows(a) {
+			t.Error("not derived", a)
+		}
+	}
+
+	if len(db.rel(":isA")) != 1 || len(db.rel(":isB")) != 1 {
+		t.Error("string tests matched too much")
+	}
+}
+
+func TestBuiltinErrors(t *testing.T) {
+
+	cases := []struct {
+		src       string
+		line, col int
+	}{
+		{"?x :r :yes :- ?x :age ?a, ?b > 18.", 1, 27},
+		{"?x :r ?z :- ?x :age ?a, ?z = ?a + ?b.", 1, 25},
+		{"?x :r :yes :- ?x :age ?a, 18 = ?a + 1.", 1, 27},
+		{"?x :r :yes :- ?x :age ?a, regex(?a, \"(\").", 1, 27},
+	}
+
+	for _, c := range cases {
+		db := newDatabase()
+		_, err := parseProgramString(c.src, &db)
+		if err == nil {
+			t.Error("expected error for", c.src)
+			continue
+		}
+		pe, ok := err.(*parseError)
+		if !ok {
+			t.Error("expected parseError, got", err)
+			continue
+		}
+		if pe.line != c.line || pe.col != c.col {
+			t.Error("wrong position", c.src, pe, c.line, c.col)
+		}
+	}
+}
+
+func TestLongOverflow(t *testing.T) {
+
+	for _, c := range []struct {
+		op       string
+		l1, l2   Long
+		expected Term
+	}{
+		{"+", math.MaxInt64, 1, Decimal("9223372036854775808.0")},
+		{"-", math.MinInt64, 1, Decimal("-9223372036854775809.0")},
+		{"*", math.MaxInt64, 2, Decimal("18446744073709551614.0")},
+		{"*", math.MinInt64, -1, Decimal("9223372036854775808.0")},
+		{"/", math.MinInt64, -1, Decimal("9223372036854775808.0")},
+		{"+", math.MaxInt64 - 1, 1, Long(math.MaxInt64)},
+		{"*", 3, -4, Long(-12)},
+	} {
+		if r, ok := arith(c.op, c.l1, c.l2); !ok || r != c.expected {
+			t.Errorf("%d %s %d: got %v, expected %v", c.l1, c.op, c.l2, r, c.expected)
+		}
+	}
+}
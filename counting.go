@@ -0,0 +1,372 @@
+package main
+
+// Incremental maintenance per component. maintain, which Apply,
+// evalSeminaiveAppend and dRed share, splits the monotonic part of a
+// program into components, the rules of relations that depend on each
+// other, and maintains them in the order of their dependencies.
+// Recursive components are maintained by seminaive evaluation and
+// dRed. Non-recursive components are maintained by counting (Gupta,
+// Mumick, Subrahmanian 1993): the number of derivations of each
+// derived fact is kept, inserts add the new derivations, deletes
+// subtract the lost ones, and a fact is deleted when its count drops
+// to zero. Nothing is overestimated or rederived.
+
+// component is a set of rules maintained together
+type component struct {
+	prog     Program
+	counting bool
+}
+
+// components partitions prog into the strongly connected components of
+// the dependencies between head relations, ordered so that every
+// component follows the components it depends on. Components are
+// counted if they are non-recursive and monotonic, and their head is a
+// constant that is no edb relation of db, so that all its facts are
+// derived.
+func (prog *Program) components(db *Database) []component {
+
+	// group the rules by head relation, in order of appearance
+	heads := make([]Constant, 0)
+	groups := make(map[Constant]Program)
+	for _, r := range *prog {
+		h := relationOf(&r.head)
+		if _, ok := groups[h]; !ok {
+			heads = append(heads, h)
+		}
+		groups[h] = append(groups[h], r)
+	}
+
+	dependsOn := func(g, h Constant) bool {
+		for _, r := range groups[g] {
+			for _, a := range r.body {
+				if defines(h, relationOf(&a)) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	// tarjan's algorithm emits components after all components they
+	// depend on
+	index := make(map[Constant]int)
+	low := make(map[Constant]int)
+	onStack := make(map[Constant]bool)
+	stack := make([]Constant, 0)
+	comps := make([]component, 0)
+
+	var visit func(g Constant)
+	visit = func(g Constant) {
+		index[g], low[g] = len(index), len(index)
+		stack = append(stack, g)
+		onStack[g] = true
+
+		for _, h := range heads {
+			if !dependsOn(g, h) {
+				continue
+			}
+			if _, ok := index[h]; !ok {
+				visit(h)
+				low[g] = min(low[g], low[h])
+			} else if onStack[h] {
+				low[g] = min(low[g], index[h])
+			}
+		}
+
+		if low[g] != index[g] {
+			return
+		}
+
+		members := make([]Constant, 0, 1)
+		for {
+			h := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[h] = false
+			members = append(members, h)
+			if h == g {
+				break
+			}
+		}
+
+		comp := component{prog: make(Program, 0)}
+		for _, h := range members {
+			comp.prog = append(comp.prog, groups[h]...)
+		}
+		comp.counting = len(members) == 1 && !dependsOn(g, g) &&
+			g != anyRelation && !db.isEdbRelation(g) && comp.prog.isMonotonic()
+		comps = append(comps, comp)
+	}
+
+	for _, h := range heads {
+		if _, ok := index[h]; !ok {
+			visit(h)
+		}
+	}
+
+	return comps
+}
+
+// touches tests if the facts of delta may change the facts derived by
+// prog
+func (prog *Program) touches(delta *Database) bool {
+	for _, r := range *prog {
+		for _, a := range r.body {
+			if isVariable(a.p) {
+				return !delta.empty()
+			}
+			if len(delta.rel(a.p.(Constant))) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// derivationCount returns the number of derivations of a counted by
+// the counting maintenance, or 0 if a is not counted
+func (db *Database) derivationCount(a Atom) int {
+	if !a.isGround() || !isConstant(a.p) {
+		return 0
+	}
+	if db.sameAs != nil {
+		a = db.canonicalAtom(&a)
+	}
+	t, ok := lookupAtom(&a)
+	if !ok {
+		return 0
+	}
+	return db.counts[a.p.(Constant)][t]
+}
+
+// dropCounts drops the counts of the head relations of prog, which
+// are no longer valid once they are derived in other ways
+func (db *Database) dropCounts(prog *Program) {
+	for _, r := range *prog {
+		if isVariable(r.head.p) {
+			db.counts = nil
+			return
+		}
+		delete(db.counts, r.head.p.(Constant))
+	}
+}
+
+// initCounts counts the derivations of the facts of the counted
+// component prog in db, unless they are counted already
+func (prog *Program) initCounts(db *Database) {
+	c := prog.head()
+	if _, ok := db.counts[c]; ok {
+		return
+	}
+
+	counts := make(map[triple]int)
+	for _, r := range *prog {
+		omega := r.eval(db)
+		for _, mu := range omega.distinct() {
+			h := r.head.bind(&mu)
+			if h.isStorable() {
+				if db.sameAs != nil {
+					h = db.canonicalAtom(&h)
+				}
+				counts[encodeAtom(&h)]++
+			}
+		}
+	}
+
+	if db.counts == nil {
+		db.counts = make(map[Constant]map[triple]int)
+	}
+	db.counts[c] = counts
+}
+
+// head returns the head relation of the counted component prog
+func (prog *Program) head() Constant {
+	return (*prog)[0].head.p.(Constant)
+}
+
+// countDerivations counts the derivations of the counted component
+// prog in db that use at least one fact of delta. A derivation using
+// several facts of delta is counted once, by the delta rule of the
+// first of them. If record is set, the derivations are recorded for
+// provenance.
+func (prog *Program) countDerivations(db, delta *Database, record bool) map[triple]int {
+	n := make(map[triple]int)
+	for _, r := range *prog {
+		for _, dr := range r.toDeltaRules(db, false) {
+			omega := dr.eval(db, delta)
+			for _, mu := range omega.distinct() {
+				counted := false
+				for _, a := range r.body[:dr.pos] {
+					if a.neg {
+						continue
+					}
+					a_ := a.bind(&mu)
+					if delta.knows(a_) {
+						counted = true
+						break
+					}
+				}
+				h := r.head.bind(&mu)
+				if record {
+					db.recordDerivation(dr.rule, &mu)
+				}
+				if !counted && h.isStorable() {
+					if db.sameAs != nil {
+						h = db.canonicalAtom(&h)
+					}
+					n[encodeAtom(&h)]++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// countInsert adds the derivations gained by the new facts delta,
+// which db contains already, and adds the facts derived for the first
+// time to db and delta
+func (prog *Program) countInsert(db, delta *Database) {
+	counts := db.counts[prog.head()]
+	added := db.shallowCopy()
+
+	for t, n := range prog.countDerivations(db, delta, true) {
+		if counts[t] == 0 && !db.knows(t.toAtom()) {
+			added.addDerived(t.toAtom())
+		}
+		counts[t] += n
+	}
+
+	db.append(&added, false)
+	delta.append(&added, false)
+}
+
+// countDelete subtracts the derivations lost by the deleted facts
+// gone, which db no longer contains, and deletes the facts left
+// without derivation from db, adding them to gone
+func (prog *Program) countDelete(db, gone *Database) {
+	counts := db.counts[prog.head()]
+	removed := db.shallowCopy()
+
+	var lost map[triple]int
+	db.withFacts(gone, func() {
+		lost = prog.countDerivations(db, gone, false)
+	})
+
+	for t, n := range lost {
+		counts[t] -= n
+		if counts[t] <= 0 {
+			delete(counts, t)
+			removed.addDerived(t.toAtom())
+		}
+	}
+
+	db.remove(&removed)
+	gone.append(&removed, false)
+}
+
+// seminaiveInsert derives the consequences of the new facts delta,
+// which db contains already, adding them to db and delta
+func (prog *Program) seminaiveInsert(db, delta *Database) {
+	dprog := prog.toDeltaProgram(db, false)
+
+	d := dprog.evalSeminaive_(db, delta)
+	for !d.empty() {
+		db.append(&d, false)
+		delta.append(&d, false)
+		d = dprog.evalSeminaive_(db, &d)
+	}
+}
+
+// dRedDelete deletes the facts of prog that are no longer derivable
+// without the deleted facts gone, which db no longer contains, and adds
+// them to gone. The overestimate is computed over the state before
+// the deletion.
+func (prog *Program) dRedDelete(db, gone *Database) {
+	db.withFacts(gone, func() {
+		prog.evalOverEstimate(db, gone)
+	})
+	db.remove(gone)
+	prog.evalAltDerive(db, gone)
+	gone.removeKnown(db)
+}
+
+// withFacts adds the facts of d_, which d must not contain, to d while
+// f runs
+func (d *Database) withFacts(d_ *Database, f func()) {
+	idbLens, edbLens := relLengths(d.idb), relLengths(d.edb)
+	d.append(d_, false)
+	f()
+	truncateRels(&d.idb, idbLens, d.idbIndexes)
+	truncateRels(&d.edb, edbLens, d.edbIndexes)
+}
+
+// withoutFacts removes the facts of d_, which d must contain, from d
+// while f runs
+func (d *Database) withoutFacts(d_ *Database, f func()) {
+	d.remove(d_)
+	f()
+	d.append(d_, true)
+}
+
+func relLengths(rels map[Constant][]triple) map[Constant]int {
+	lens := make(map[Constant]int, len(rels))
+	for relName, rel := range rels {
+		lens[relName] = len(rel)
+	}
+	return lens
+}
+
+func truncateRels(rels *map[Constant][]triple, lens map[Constant]int, indexes map[Constant]*relIndex) {
+	for relName, rel := range *rels {
+		l := lens[relName]
+		if l < len(rel) {
+			indexes[relName].truncate(rel, l)
+			(*rels)[relName] = rel[:l]
+		}
+	}
+}
+
+// newFacts returns the facts of d_ that d does not know
+func (d *Database) newFacts(d_ *Database) Database {
+	delta := d.shallowCopy()
+	for _, rel := range d_.edb {
+		for _, t := range rel {
+			if a := t.toAtom(); !d.knows(a) && !delta.knows(a) {
+				delta.addAtom(a)
+			}
+		}
+	}
+	for _, rel := range d_.idb {
+		for _, t := range rel {
+			if a := t.toAtom(); !d.knows(a) && !delta.knows(a) {
+				delta.addDerived(a)
+			}
+		}
+	}
+	return delta
+}
+
+// filter removes the facts of d that keep rejects
+func (d *Database) filter(keep func(a Atom) bool) {
+	filterRels(&(*d).idb, (*d).idbIndexes, keep)
+	filterRels(&(*d).edb, (*d).edbIndexes, keep)
+}
+
+// removeKnown removes the facts d_ knows from d
+func (d *Database) removeKnown(d_ *Database) {
+	d.filter(func(a Atom) bool { return !d_.knows(a) })
+}
+
+func filterRels(rels *map[Constant][]triple, indexes map[Constant]*relIndex, keep func(a Atom) bool) {
+	for relName, rel := range *rels {
+		rel_ := make([]triple, 0, len(rel))
+		for _, t := range rel {
+			if keep(t.toAtom()) {
+				rel_ = append(rel_, t)
+			}
+		}
+		if len(rel_) < len(rel) {
+			(*rels)[relName] = rel_
+			indexes[relName].rebuild(rel_)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// countingRules has non-recursive relations below (:linked), above
+// (:hub) and beside (:can) the recursive :reachable
+const countingRules = `
+?x :linked ?y :- ?x :link ?y.
+?x :linked ?y :- ?y :link ?x.
+?x :reachable ?y :- ?x :linked ?y.
+?x :reachable ?z :- ?x :linked ?y, ?y :reachable ?z.
+?x :hub ?y :- ?x :reachable ?y, ?y :a :Hub, ?x :a :Hub.
+?x :can :fly :- ?x :has :Wings.
+?x :can :fly :- ?x :a :Bird.
+`
+
+func parseTestProgram(t *testing.T, src string, db *Database) Program {
+	prog, err := parseProgramString(src, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog.register(db)
+	return prog
+}
+
+// checkCounting compares db and its counts to the recomputation of its
+// base facts
+func checkCounting(t *testing.T, prog Program, db *Database) {
+	t.Helper()
+
+	expected := db.deepCopy()
+	expected.clearIdb()
+	prog.evalSeminaive(&expected)
+
+	if facts, expectedFacts := strings.Join(allFacts(db), "\n"), strings.Join(allFacts(&expected), "\n"); facts != expectedFacts {
+		t.Fatalf("wrong facts:\n%s\nexpected:\n%s", facts, expectedFacts)
+	}
+
+	for _, comp := range prog.components(&expected) {
+		if !comp.counting {
+			continue
+		}
+		comp.prog.initCounts(&expected)
+		c := comp.prog.head()
+		if len(db.counts[c]) != len(expected.counts[c]) {
+			t.Fatalf("wrong number of counts for %s: %d, expected %d", c, len(db.counts[c]), len(expected.counts[c]))
+		}
+		for tr, n := range expected.counts[c] {
+			if db.counts[c][tr] != n {
+				t.Fatalf("wrong count for %v: %d, expected %d", tr.toAtom(), db.counts[c][tr], n)
+			}
+		}
+	}
+}
+
+func TestComponents(t *testing.T) {
+	db := newDatabase()
+	prog := parseTestProgram(t, countingRules, &db)
+
+	comps := prog.components(&db)
+	heads := make([]string, 0, len(comps))
+	for _, comp := range comps {
+		heads = append(heads, string(relationOf(&comp.prog[0].head))+" "+strconv.FormatBool(comp.counting))
+	}
+
+	expected := ":linked true,:reachable false,:hub true,:can true"
+	if strings.Join(heads, ",") != expected {
+		t.Errorf("wrong components: %s, expected %s", strings.Join(heads, ","), expected)
+	}
+}
+
+func TestCounting(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		:tweety :has :Wings ; :a :Bird .
+		:pingu :a :Bird .
+	`)
+	prog := parseTestProgram(t, countingRules, &db)
+	prog.evalSeminaive(&db)
+
+	canFly := func(s string) Atom { return Atom{Constant(s), Constant(":can"), Constant(":fly"), false} }
+
+	ins := loadTestTurtle(t, ":pingu :has :Wings .")
+	prog.evalSeminaiveAppend(&db, &ins)
+	if db.derivationCount(canFly(":tweety")) != 2 || db.derivationCount(canFly(":pingu")) != 2 {
+		t.Error("wrong counts after insert")
+	}
+
+	del := loadTestTurtle(t, ":tweety :a :Bird .\n:pingu :a :Bird .\n:pingu :has :Wings .")
+	dRed(&db, &del, &prog)
+	if !db.knows(canFly(":tweety")) || db.derivationCount(canFly(":tweety")) != 1 {
+		t.Error("fact with remaining derivation was deleted")
+	}
+	if db.knows(canFly(":pingu")) || db.derivationCount(canFly(":pingu")) != 0 {
+		t.Error("fact without derivation was kept")
+	}
+
+	checkCounting(t, prog, &db)
+}
+
+func TestCountingLeadingNegation(t *testing.T) {
+
+	db := loadTestTurtle(t, ":p :c :q .")
+	prog := parseTestProgram(t, "?x :h ?y :- not ?x :blocked ?y, ?x :a ?y, ?x :b ?y.", &db)
+	prog.evalSeminaive(&db)
+
+	// both new facts are used by the one derivation, which is counted
+	// once, by the delta rule of :a
+	h := newAtom(":p", ":h", ":q")
+	ins := loadTestTurtle(t, ":p :a :q .\n:p :b :q .")
+	prog.evalSeminaiveAppend(&db, &ins)
+	if n := db.derivationCount(h); n != 1 {
+		t.Errorf("%d derivations counted, expected 1", n)
+	}
+
+	del := loadTestTurtle(t, ":p :a :q .")
+	dRed(&db, &del, &prog)
+	if db.knows(h) {
+		t.Error("fact without derivation was kept")
+	}
+	checkCounting(t, prog, &db)
+}
+
+func TestCountingRandom(t *testing.T) {
+
+	rng := rand.New(rand.NewSource(1))
+	node := func() string { return ":n" + strconv.Itoa(rng.Intn(30)) }
+	randomFacts := func(n int) Database {
+		d := newDatabase()
+		for i := 0; i < n; i++ {
+			switch rng.Intn(4) {
+			case 0:
+				d.addAtom(newAtom(node(), ":a", ":Hub"))
+			case 1:
+				d.addAtom(newAtom(node(), ":has", ":Wings"))
+			default:
+				d.addAtom(newAtom(node(), ":link", node()))
+			}
+		}
+		return d
+	}
+
+	db := randomFacts(40)
+	prog := parseTestProgram(t, countingRules, &db)
+	prog.evalSeminaive(&db)
+
+	for i := 0; i < 20; i++ {
+		ins := randomFacts(5)
+		prog.evalSeminaiveAppend(&db, &ins)
+		checkCounting(t, prog, &db)
+
+		// delete some base facts, and some unknown ones
+		del := randomFacts(3)
+		for _, rel := range db.edb {
+			for _, tr := range rel {
+				if rng.Intn(8) == 0 {
+					del.addAtom(tr.toAtom())
+				}
+			}
+		}
+		dRed(&db, &del, &prog)
+		checkCounting(t, prog, &db)
+	}
+}
@@ -1,37 +1,49 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Vocabulary {{{
 
 type Constant string
 type Variable string
 
-// type Literal interface {
-// 	getLiteralType() uint8
-// }
+// Literals are only allowed in object position, see literal.go for
+// their lexical forms
+type Literal interface {
+	Term
+	getLiteralType() uint8
+}
+
+const (
+	LONG    = iota
+	DOUBLE  = iota
+	STRING  = iota
+	ARRAY   = iota
+	DECIMAL = iota
+)
 
-// const (
-// 	LONG   = iota
-// 	DOUBLE = iota
-// 	STRING = iota
-// 	ARRAY  = iota
-// )
+type Long int64
+type Double float64
+type String string
+type Array []Literal
 
-// type Long uint64
-// type Double uint64
-// type String string
-// type Array []Literal
+// Decimal is an exact decimal number in its canonical lexical form,
+// see parseDecimal
+type Decimal string
 
-// func (l Long) getLiteralType() uint8   { return LONG }
-// func (d Double) getLiteralType() uint8 { return DOUBLE }
-// func (s String) getLiteralType() uint8 { return STRING }
-// func (a Array) getLiteralType() uint8  { return ARRAY }
+func (l Long) getLiteralType() uint8    { return LONG }
+func (d Double) getLiteralType() uint8  { return DOUBLE }
+func (s String) getLiteralType() uint8  { return STRING }
+func (a Array) getLiteralType() uint8   { return ARRAY }
+func (d Decimal) getLiteralType() uint8 { return DECIMAL }
 
 const (
 	CONSTANT = iota
 	VARIABLE = iota
-	// LITERAL  = iota
+	LITERAL  = iota
 )
 
 type Term interface {
@@ -41,15 +53,34 @@ type Term interface {
 func (c Constant) getTermType() uint8 { return CONSTANT }
 func (v Variable) getTermType() uint8 { return VARIABLE }
 
-// func (l Long) getTermType() uint8     { return LITERAL }
-// func (l Double) getTermType() uint8   { return LITERAL }
-// func (l String) getTermType() uint8   { return LITERAL }
-// func (l Array) getTermType() uint8    { return LITERAL }
+func (l Long) getTermType() uint8    { return LITERAL }
+func (l Double) getTermType() uint8  { return LITERAL }
+func (l String) getTermType() uint8  { return LITERAL }
+func (l Array) getTermType() uint8   { return LITERAL }
+func (l Decimal) getTermType() uint8 { return LITERAL }
 
 func isConstant(t Term) bool { return t.getTermType() == CONSTANT }
 func isVariable(t Term) bool { return t.getTermType() == VARIABLE }
-
-// func isLiteral(t Term) bool  { return t.getTermType() == LITERAL }
+func isLiteral(t Term) bool  { return t.getTermType() == LITERAL }
+
+// termEqual compares two terms, arrays are compared element wise as
+// they can not be compared with ==
+func termEqual(t1, t2 Term) bool {
+	a1, ok1 := t1.(Array)
+	a2, ok2 := t2.(Array)
+	if ok1 || ok2 {
+		if !ok1 || !ok2 || len(a1) != len(a2) {
+			return false
+		}
+		for i := range a1 {
+			if !termEqual(a1[i], a2[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return t1 == t2
+}
 
 type Atom struct {
 	s, p, o Term
@@ -69,8 +100,8 @@ func newAtom(s, p, o string) Atom {
 	switch p[0] {
 	case ':':
 		a.p = Constant(p)
-	// case '?':
-	// 	a.p = Variable(p)
+	case '?':
+		a.p = Variable(p)
 	default:
 		panic("only constant allowed in p position")
 	}
@@ -79,8 +110,17 @@ func newAtom(s, p, o string) Atom {
 		a.o = Constant(o)
 	case '?':
 		a.o = Variable(o)
+	case '"':
+		if len(o) < 2 || o[len(o)-1] != '"' {
+			panic("unterminated string literal in o position")
+		}
+		a.o = String(o[1 : len(o)-1])
 	default:
-		panic("only constant or variable allowed in o position")
+		l, ok := parseNumber(o)
+		if !ok {
+			panic("only constant, variable or literal allowed in o position")
+		}
+		a.o = l
 	}
 
 	return a
@@ -93,7 +133,14 @@ func newNegAtom(s, p, o string) Atom {
 }
 
 func (a *Atom) isGround() bool {
-	return isConstant(a.s) && isConstant(a.p) && isConstant(a.o)
+	return !isVariable(a.s) && !isVariable(a.p) && !isVariable(a.o)
+}
+
+// isStorable tests if the ground atom a can be stored in a database.
+// Rules may derive atoms that can not, e.g. with a literal bound to a
+// variable in subject position.
+func (a *Atom) isStorable() bool {
+	return isConstant(a.p) && !isLiteral(a.s)
 }
 
 func (a1 *Atom) equalTo(a2 *Atom) bool {
@@ -101,24 +148,42 @@ func (a1 *Atom) equalTo(a2 *Atom) bool {
 		return false
 	}
 
-	return a1.s.(Constant) == a2.s.(Constant) && a1.p.(Constant) == a2.p.(Constant) && a1.o.(Constant) == a2.o.(Constant)
+	return termEqual(a1.s, a2.s) && termEqual(a1.p, a2.p) && termEqual(a1.o, a2.o)
 }
 
 // }}}
 
 // Database {{{
 
+// Database stores the atoms of each relation as dictionary encoded
+// triples, see dictionary.go. A relation may be both an edb and an idb
+// relation, e.g. rdf:type under rdfs entailment, its base and derived
+// facts are then stored apart with their own index.
 type Database struct {
-	idb     map[Constant][]Atom
-	edb     map[Constant][]Atom
-	commits map[Constant][]int
+	idb        map[Constant][]triple
+	edb        map[Constant][]triple
+	idbIndexes map[Constant]*relIndex
+	edbIndexes map[Constant]*relIndex
+	// savepoints holds the open savepoints, innermost last, see
+	// savepoint
+	savepoints []savepoint
+	// sameAs is set if equality reasoning is enabled, see equality.go.
+	// Deltas share it with their database.
+	sameAs *equality
+	// why records derivations if provenance is enabled, see
+	// provenance.go. Deltas share it with their database.
+	why *provenance
+	// counts holds the number of derivations of the facts of counted
+	// relations, see counting.go
+	counts map[Constant]map[triple]int
 }
 
 func newDatabase() Database {
 	return Database{
-		idb:     make(map[Constant][]Atom),
-		edb:     make(map[Constant][]Atom),
-		commits: make(map[Constant][]int),
+		idb:        make(map[Constant][]triple),
+		edb:        make(map[Constant][]triple),
+		idbIndexes: make(map[Constant]*relIndex),
+		edbIndexes: make(map[Constant]*relIndex),
 	}
 }
 
@@ -144,6 +209,9 @@ func (d *Database) shallowCopy() Database {
 		d_.registerEdbRel(k)
 	}
 
+	d_.sameAs = d.sameAs
+	d_.why = d.why
+
 	return d_
 
 }
@@ -160,15 +228,40 @@ func (d *Database) deepCopy() Database {
 		d_.edb[relName] = append(d_.edb[relName], rel...)
 	}
 
-	for relName, cs := range d.commits {
-		d_.commits[relName] = append(d_.commits[relName], cs...)
+	for relName, idx := range d_.idbIndexes {
+		idx.rebuild(d_.idb[relName])
+	}
+
+	for relName, idx := range d_.edbIndexes {
+		idx.rebuild(d_.edb[relName])
+	}
+
+	if d.sameAs != nil {
+		d_.sameAs = d.sameAs.clone()
+	}
+
+	if d.why != nil {
+		d_.why = d.why.clone()
+	}
+
+	// savepoints only read the relations they share with d
+	d_.savepoints = append(d_.savepoints, d.savepoints...)
+
+	for relName, counts := range d.counts {
+		if d_.counts == nil {
+			d_.counts = make(map[Constant]map[triple]int)
+		}
+		d_.counts[relName] = make(map[triple]int, len(counts))
+		for t, n := range counts {
+			d_.counts[relName][t] = n
+		}
 	}
 
 	return d_
 
 }
 
-func relsEqualTo(rels, rels_ *map[Constant][]Atom) bool {
+func relsEqualTo(rels, rels_ *map[Constant][]triple, indexes_ map[Constant]*relIndex) bool {
 	for relName, rel := range *rels {
 		rel_, ok := (*rels_)[relName]
 
@@ -177,7 +270,7 @@ func relsEqualTo(rels, rels_ *map[Constant][]Atom) bool {
 		}
 
 		for _, a := range rel {
-			if !relKnows(rel_, a) {
+			if !relKnows(indexes_[relName], a) {
 				return false
 			}
 		}
@@ -186,7 +279,7 @@ func relsEqualTo(rels, rels_ *map[Constant][]Atom) bool {
 }
 
 func (d *Database) equalTo(d_ *Database) bool {
-	return relsEqualTo(&(*d).idb, &(*d_).idb) && relsEqualTo(&(*d).edb, &(*d_).edb)
+	return relsEqualTo(&(*d).idb, &(*d_).idb, (*d_).idbIndexes) && relsEqualTo(&(*d).edb, &(*d_).edb, (*d_).edbIndexes)
 }
 
 func (d *Database) empty() bool {
@@ -205,88 +298,209 @@ func (d *Database) empty() bool {
 	return true
 }
 
-func (d *Database) commitRel(rel *map[Constant][]Atom) {
-	for relName, rel := range *rel {
-		(*d).commits[relName] = append((*d).commits[relName], len(rel))
+// snapshot records the relations of a database. Facts are only ever
+// appended to the slice of a relation, every other change replaces the
+// slice, so keeping the slices suffices. Slices that are restored are
+// capped, appending to them copies them instead of overwriting facts
+// later snapshots may still hold.
+type snapshot struct {
+	idb, edb map[Constant][]triple
+	sameAs   *equality
+}
+
+// savepoint is a named snapshot, see savepoint
+type savepoint struct {
+	name string
+	snapshot
+}
+
+func copyRels(rels map[Constant][]triple) map[Constant][]triple {
+	rels_ := make(map[Constant][]triple, len(rels))
+	for relName, rel := range rels {
+		rels_[relName] = rel
 	}
+	return rels_
 }
 
-func (d *Database) commit() {
-	d.commitRel(&(*d).idb)
-	d.commitRel(&(*d).edb)
+func (d *Database) snapshot() snapshot {
+	s := snapshot{idb: copyRels(d.idb), edb: copyRels(d.edb)}
+	if d.sameAs != nil {
+		s.sameAs = d.sameAs.clone()
+	}
+	return s
 }
 
-func (d *Database) revertRel(rels *map[Constant][]Atom) {
+// restoreRels resets the relations to the slices of the snapshot,
+// relations created since are emptied. The index of a relation that was
+// only appended to is truncated, otherwise it is rebuilt.
+func restoreRels(rels *map[Constant][]triple, rels_ map[Constant][]triple, indexes map[Constant]*relIndex) {
 	for relName, rel := range *rels {
-		l := len((*d).commits[relName])
-		if l > 0 {
-			l_ := (*d).commits[relName][l-1]
-			(*d).commits[relName] = (*d).commits[relName][:l-1]
-			(*rels)[relName] = rel[:l_]
+		rel_ := rels_[relName]
+		l := len(rel_)
+		if l == 0 {
+			indexes[relName] = newRelIndex()
+		} else if len(rel) >= l && &rel[0] == &rel_[0] {
+			indexes[relName].truncate(rel, l)
+		} else {
+			indexes[relName].rebuild(rel_)
 		}
+		(*rels)[relName] = rel_[:l:l]
+	}
+}
+
+func (d *Database) restore(s snapshot) {
+	restoreRels(&(*d).idb, s.idb, (*d).idbIndexes)
+	restoreRels(&(*d).edb, s.edb, (*d).edbIndexes)
+	if s.sameAs != nil {
+		// the snapshot may be restored again
+		d.sameAs = s.sameAs.clone()
 	}
+	// counts are recounted on demand
+	d.counts = nil
 }
 
+// savepoint opens a savepoint named name. Savepoints nest, all changes
+// to d after it, inserts and deletes to both edb and idb, can be undone
+// by rollback or kept by release. Names may repeat, the innermost
+// savepoint of a name is meant.
+func (d *Database) savepoint(name string) {
+	d.savepoints = append(d.savepoints, savepoint{name, d.snapshot()})
+}
+
+// findSavepoint returns the position of the innermost savepoint named
+// name
+func (d *Database) findSavepoint(name string) (int, error) {
+	for i := len(d.savepoints) - 1; i >= 0; i-- {
+		if d.savepoints[i].name == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no savepoint %s", name)
+}
+
+// rollback undoes all changes since the savepoint name and closes the
+// savepoints opened after it, the savepoint itself stays open
+func (d *Database) rollback(name string) error {
+	i, err := d.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	d.restore(d.savepoints[i].snapshot)
+	d.savepoints = d.savepoints[:i+1]
+	return nil
+}
+
+// release closes the savepoint name and the ones opened after it,
+// keeping their changes
+func (d *Database) release(name string) error {
+	i, err := d.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	d.savepoints = d.savepoints[:i]
+	return nil
+}
+
+// commit opens an unnamed savepoint
+func (d *Database) commit() {
+	d.savepoint("")
+}
+
+// revert undoes all changes since the last commit or savepoint and
+// closes it
 func (d *Database) revert() {
-	d.revertRel(&(*d).idb)
-	d.revertRel(&(*d).edb)
+	if l := len(d.savepoints); l > 0 {
+		d.restore(d.savepoints[l-1].snapshot)
+		d.savepoints = d.savepoints[:l-1]
+	}
 }
 
-func appendRels(rels, rels_ *map[Constant][]Atom, checkDoublette bool) {
+func appendRels(rels, rels_ *map[Constant][]triple, indexes map[Constant]*relIndex, checkDoublette bool) {
 	for relName, rel := range *rels {
 		rel_, ok := (*rels_)[relName]
 		if ok {
 			if checkDoublette {
-				rel__ := make([]Atom, 0, len(rel_))
+				// checking each atom against the growing index also
+				// drops doublettes within rel_
+				idx := indexes[relName]
 				for _, a := range rel_ {
-					if !relKnows(rel, a) {
-						rel__ = append(rel__, a)
+					if !relKnows(idx, a) {
+						idx.add(a, len(rel))
+						rel = append(rel, a)
 					}
 				}
-				(*rels)[relName] = append(rel, rel__...)
 			} else {
-				(*rels)[relName] = append(rel, rel_...)
+				l := len(rel)
+				rel = append(rel, rel_...)
+				indexes[relName].addFrom(rel, l)
 			}
+			(*rels)[relName] = rel
 		}
 	}
 }
 
+// append adds the facts of d_ to d. Relations created in d_, e.g. by
+// addDerived, are created in d as well.
 func (d *Database) append(d_ *Database, checkDoublette bool) {
-	appendRels(&(*d).idb, &(*d_).idb, checkDoublette)
-	appendRels(&(*d).edb, &(*d_).edb, checkDoublette)
+	for relName, rel := range (*d_).idb {
+		if len(rel) > 0 {
+			d.registerIdbRel(relName)
+		}
+	}
+	for relName, rel := range (*d_).edb {
+		if len(rel) > 0 {
+			d.registerEdbRel(relName)
+		}
+	}
+	appendRels(&(*d).idb, &(*d_).idb, (*d).idbIndexes, checkDoublette)
+	appendRels(&(*d).edb, &(*d_).edb, (*d).edbIndexes, checkDoublette)
 }
 
-func removeRels(rels, rels_ *map[Constant][]Atom) {
+func removeRels(rels, rels_ *map[Constant][]triple, indexes, indexes_ map[Constant]*relIndex) {
 	for relName, rel := range *rels {
-		rel_, ok := (*rels_)[relName]
+		_, ok := (*rels_)[relName]
 		if ok {
-			rel__ := make([]Atom, 0, len(rel))
+			rel__ := make([]triple, 0, len(rel))
 			for _, a := range rel {
-				if !relKnows(rel_, a) {
+				if !relKnows(indexes_[relName], a) {
 					rel__ = append(rel__, a)
 				}
 			}
-			(*rels)[relName] = rel__
+			if len(rel__) < len(rel) {
+				(*rels)[relName] = rel__
+				indexes[relName].rebuild(rel__)
+			}
 		}
 	}
 }
 
+// clearIdb drops all derived facts, merges of sameAs classes by
+// derived facts included
 func (d *Database) clearIdb() {
 	for relName, _ := range (*d).idb {
-		(*d).idb[relName] = make([]Atom, 0)
+		d.clearIdbRel(relName)
+	}
+	if d.sameAs != nil {
+		d.resetSameAs()
 	}
 }
 
+func (d *Database) clearIdbRel(c Constant) {
+	(*d).idb[c] = make([]triple, 0)
+	(*d).idbIndexes[c] = newRelIndex()
+	delete(d.counts, c)
+}
+
 func (d *Database) remove(d_ *Database) {
-	removeRels(&(*d).idb, &(*d_).idb)
-	removeRels(&(*d).edb, &(*d_).edb)
+	removeRels(&(*d).idb, &(*d_).idb, (*d).idbIndexes, (*d_).idbIndexes)
+	removeRels(&(*d).edb, &(*d_).edb, (*d).edbIndexes, (*d_).edbIndexes)
 }
 
-func dumpRels(rels *map[Constant][]Atom) {
+func dumpRels(rels *map[Constant][]triple) {
 	for relName, rel := range *rels {
 		fmt.Println("\t", relName)
-		for _, a := range rel {
-			fmt.Println("\t", a)
+		for _, t := range rel {
+			fmt.Println("\t", t.toAtom())
 		}
 		fmt.Println("")
 	}
@@ -305,46 +519,60 @@ func (d *Database) addAtom(a Atom) {
 		panic("only ground atoms can be added to the database")
 	}
 
-	if d.isEdbRelation(a.p.(Constant)) {
-		rel, _ := (*d).edb[a.p.(Constant)]
-		rel = append(rel, a)
-		(*d).edb[a.p.(Constant)] = rel
-	} else if d.isIdbRelation(a.p.(Constant)) {
-		rel, _ := (*d).idb[a.p.(Constant)]
-		rel = append(rel, a)
-		(*d).idb[a.p.(Constant)] = rel
+	if !isConstant(a.p) {
+		panic("only constant allowed in p position")
+	}
+
+	t := encodeAtom(&a)
+	c := a.p.(Constant)
+
+	if d.isEdbRelation(c) || !d.isIdbRelation(c) {
+		d.registerEdbRel(c)
+		(*d).edb[c] = append((*d).edb[c], t)
+		(*d).edbIndexes[c].add(t, len((*d).edb[c])-1)
 	} else {
-		d.registerEdbRel(a.p.(Constant))
-		rel, _ := (*d).edb[a.p.(Constant)]
-		rel = append(rel, a)
-		(*d).edb[a.p.(Constant)] = rel
+		(*d).idb[c] = append((*d).idb[c], t)
+		(*d).idbIndexes[c].add(t, len((*d).idb[c])-1)
 	}
 
 }
 
-func (d *Database) registerEdbRel(c Constant) {
-	if d.isIdbRelation(c) {
-		panic("relation already registered as idb relation")
+// addDerived adds the derived fact a to the idb part of d, registering
+// its relation if necessary
+func (d *Database) addDerived(a Atom) {
+
+	if !a.isGround() || !isConstant(a.p) {
+		panic("only ground atoms with constant predicate can be derived")
 	}
 
+	if d.sameAs != nil {
+		a = d.canonicalAtom(&a)
+	}
+
+	t := encodeAtom(&a)
+	c := a.p.(Constant)
+
+	d.registerIdbRel(c)
+	(*d).idb[c] = append((*d).idb[c], t)
+	(*d).idbIndexes[c].add(t, len((*d).idb[c])-1)
+
+}
+
+func (d *Database) registerEdbRel(c Constant) {
 	_, ok := d.edb[c]
 
 	if !ok {
-		d.edb[c] = make([]Atom, 0)
-		d.commits[c] = make([]int, 0)
+		d.edb[c] = make([]triple, 0)
+		d.edbIndexes[c] = newRelIndex()
 	}
 }
 
 func (d *Database) registerIdbRel(c Constant) {
-	if d.isEdbRelation(c) {
-		panic("relation already registered as edb relation")
-	}
-
 	_, ok := d.idb[c]
 
 	if !ok {
-		d.idb[c] = make([]Atom, 0)
-		d.commits[c] = make([]int, 0)
+		d.idb[c] = make([]triple, 0)
+		d.idbIndexes[c] = newRelIndex()
 	}
 }
 
@@ -358,39 +586,124 @@ func (d *Database) isEdbRelation(c Constant) bool {
 	return ok
 }
 
+// rel returns the triples of relation c, regardless of it being an
+// idb or edb relation. For relations that are both, the derived facts
+// follow the base facts and may repeat them.
+func (d *Database) rel(c Constant) []triple {
+	rel, ok := d.idb[c]
+	if !ok {
+		return d.edb[c]
+	}
+	if edb, ok := d.edb[c]; ok {
+		rel = append(append(make([]triple, 0, len(edb)+len(rel)), edb...), rel...)
+	}
+	return rel
+}
+
+// relNames returns the names of all relations
+func (d *Database) relNames() []Constant {
+	names := make([]Constant, 0, len(d.idb)+len(d.edb))
+	for relName, _ := range d.edb {
+		names = append(names, relName)
+	}
+	for relName, _ := range d.idb {
+		if !d.isEdbRelation(relName) {
+			names = append(names, relName)
+		}
+	}
+	return names
+}
+
 // findMappings finds all mappings in an abox (i.e. list of ground
-// atoms) corresponding to graph pattern bgp
+// atoms) corresponding to graph pattern bgp. A variable predicate
+// matches the atoms of all relations.
 func (db *Database) findMappingsFor(bgp *Atom) Omega {
 	omega := make(Omega, 0, 100)
 
-	if !isConstant(bgp.p) {
-		panic("only bgp with constant predicate are currently supported.")
+	if isLiteral(bgp.p) {
+		return omega
+	}
+
+	if db.sameAs != nil {
+		bgp_ := db.canonicalAtom(bgp)
+		bgp = &bgp_
 	}
 
-	rel, ok := db.idb[bgp.p.(Constant)]
+	pat, ok := bgp.compile()
 	if !ok {
-		rel, ok = db.edb[bgp.p.(Constant)]
-		if !ok {
-			return omega
-		}
+		return omega
+	}
+
+	if isConstant(bgp.p) {
+		return db.findMappingsIn(bgp.p.(Constant), &pat, omega)
+	}
+
+	for _, relName := range db.relNames() {
+		omega = db.findMappingsIn(relName, &pat, omega)
+	}
+
+	return omega
+}
+
+// findMappingsIn appends the mappings of pat in the relation relName
+// to omega. Derived facts that are also base facts are skipped, as are
+// facts hidden by sameAs.
+func (db *Database) findMappingsIn(relName Constant, pat *pattern, omega Omega) Omega {
+
+	edbIdx, isEdb := db.edbIndexes[relName]
+
+	if isEdb {
+		omega = scanRel(db.edb[relName], edbIdx, pat, omega, nil, db.sameAs)
 	}
 
-	for _, a := range rel {
-		if bgp.matches(&a) {
-			omega = append(omega, bgp.toMu(&a))
+	if idx, ok := db.idbIndexes[relName]; ok {
+		if !isEdb {
+			edbIdx = nil
 		}
+		omega = scanRel(db.idb[relName], idx, pat, omega, edbIdx, db.sameAs)
 	}
 
 	return omega
 }
 
-func relKnows(rel []Atom, a Atom) bool {
-	for _, a_ := range rel {
-		if a.equalTo(&a_) {
-			return true
+// scanRel appends the mappings of pat in rel to omega, using the index
+// if the bgp has a constant subject and/or object. Triples known in
+// skip or mentioning an alias of eq are left out.
+func scanRel(rel []triple, idx *relIndex, pat *pattern, omega Omega, skip *relIndex, eq *equality) Omega {
+
+	match := func(t triple) {
+		if pat.matches(t) && (skip == nil || !relKnows(skip, t)) && (eq == nil || !eq.isAlias(t[0]) && !eq.isAlias(t[2])) {
+			omega = append(omega, pat.toMu(t))
 		}
 	}
-	return false
+
+	positions, ok := idx.lookup(pat)
+	if !ok {
+		for _, t := range rel {
+			match(t)
+		}
+		return omega
+	}
+
+	for _, i := range positions {
+		match(rel[i])
+	}
+
+	return omega
+}
+
+// findMappingsBound finds all mappings for bgp after substituting the
+// variables already bound by mu, so that the indexes can be used for
+// them. The resulting mappings only bind the remaining variables.
+func (db *Database) findMappingsBound(bgp *Atom, mu *Mu) Omega {
+	bgp_ := bgp.bind(mu)
+	return db.findMappingsFor(&bgp_)
+}
+
+// relKnows tests if the relation indexed by idx contains the triple
+// t, the (subject, object) index doubles as hash set for that.
+func relKnows(idx *relIndex, t triple) bool {
+	return len(idx.so[[2]termID{t[0], t[2]}]) > 0
 }
 
 func (db *Database) knows(a Atom) bool {
@@ -399,15 +712,136 @@ func (db *Database) knows(a Atom) bool {
 		return false
 	}
 
-	rel, ok := db.idb[a.p.(Constant)]
+	if !isConstant(a.p) {
+		return false
+	}
+
+	if db.sameAs != nil {
+		a = db.canonicalAtom(&a)
+	}
+
+	t, ok := lookupAtom(&a)
 	if !ok {
-		rel, ok = db.edb[a.p.(Constant)]
-		if !ok {
-			return false
+		return false
+	}
+
+	if idx, ok := db.edbIndexes[a.p.(Constant)]; ok && relKnows(idx, t) {
+		return true
+	}
+
+	idx, ok := db.idbIndexes[a.p.(Constant)]
+	return ok && relKnows(idx, t)
+}
+
+// knowsDerived tests if a is known as derived fact, regardless of it
+// being a base fact too
+func (db *Database) knowsDerived(a Atom) bool {
+	return db.knowsIn(db.idbIndexes, a)
+}
+
+// knowsBase tests if a is known as base fact, regardless of it being a
+// derived fact too
+func (db *Database) knowsBase(a Atom) bool {
+	return db.knowsIn(db.edbIndexes, a)
+}
+
+func (db *Database) knowsIn(indexes map[Constant]*relIndex, a Atom) bool {
+
+	if !a.isGround() || !isConstant(a.p) {
+		return false
+	}
+
+	idx, ok := indexes[a.p.(Constant)]
+	if !ok {
+		return false
+	}
+
+	if db.sameAs != nil {
+		a = db.canonicalAtom(&a)
+	}
+
+	t, ok := lookupAtom(&a)
+	return ok && relKnows(idx, t)
+}
+
+// }}}
+
+// Index {{{
+
+// relIndex indexes the triples of a single relation by subject,
+// object and (subject, object), each mapping to positions in the
+// relation slice.
+type relIndex struct {
+	s  map[termID][]int
+	o  map[termID][]int
+	so map[[2]termID][]int
+}
+
+func newRelIndex() *relIndex {
+	return &relIndex{
+		s:  make(map[termID][]int),
+		o:  make(map[termID][]int),
+		so: make(map[[2]termID][]int),
+	}
+}
+
+func (idx *relIndex) add(t triple, i int) {
+	idx.s[t[0]] = append(idx.s[t[0]], i)
+	idx.o[t[2]] = append(idx.o[t[2]], i)
+	k := [2]termID{t[0], t[2]}
+	idx.so[k] = append(idx.so[k], i)
+}
+
+// addFrom indexes all triples of rel starting at position l
+func (idx *relIndex) addFrom(rel []triple, l int) {
+	for i := l; i < len(rel); i++ {
+		idx.add(rel[i], i)
+	}
+}
+
+func (idx *relIndex) rebuild(rel []triple) {
+	*idx = *newRelIndex()
+	idx.addFrom(rel, 0)
+}
+
+func popIndex(ps []int) []int {
+	return ps[:len(ps)-1]
+}
+
+// truncate removes all positions >= l, which are always the last
+// entries of their position lists
+func (idx *relIndex) truncate(rel []triple, l int) {
+	for i := len(rel) - 1; i >= l; i-- {
+		t := rel[i]
+		k := [2]termID{t[0], t[2]}
+		idx.s[t[0]] = popIndex(idx.s[t[0]])
+		idx.o[t[2]] = popIndex(idx.o[t[2]])
+		idx.so[k] = popIndex(idx.so[k])
+		if len(idx.s[t[0]]) == 0 {
+			delete(idx.s, t[0])
+		}
+		if len(idx.o[t[2]]) == 0 {
+			delete(idx.o, t[2])
+		}
+		if len(idx.so[k]) == 0 {
+			delete(idx.so, k)
 		}
 	}
+}
 
-	return relKnows(rel, a)
+// lookup returns the candidate positions for pat, ok is false if
+// neither subject nor object are constant
+func (idx *relIndex) lookup(pat *pattern) ([]int, bool) {
+	sConst, oConst := !isVariable(pat.terms[0]), !isVariable(pat.terms[2])
+	switch {
+	case sConst && oConst:
+		return idx.so[[2]termID{pat.ids[0], pat.ids[2]}], true
+	case sConst:
+		return idx.s[pat.ids[0]], true
+	case oConst:
+		return idx.o[pat.ids[2]], true
+	}
+	return nil, false
 }
 
 // }}}
@@ -417,23 +851,23 @@ func (db *Database) knows(a Atom) bool {
 // matches tests if a bgp matches a ground atom
 func (bgp *Atom) matches(a *Atom) bool {
 
-	// if any of the bgp is constant and does not match the
-	// corresponding atom part return false
-	if isConstant(bgp.s) && bgp.s != a.s || isConstant(bgp.p) && bgp.p != a.p || isConstant(bgp.o) && bgp.o != a.o {
+	// if any of the bgp is constant or literal and does not match
+	// the corresponding atom part return false
+	if !isVariable(bgp.s) && !termEqual(bgp.s, a.s) || !isVariable(bgp.p) && !termEqual(bgp.p, a.p) || !isVariable(bgp.o) && !termEqual(bgp.o, a.o) {
 		return false
 	}
 
 	// if a is variable, and p or o happens to point at the
 	// same variable, but the atom is different return false
 	if isVariable(bgp.s) {
-		if bgp.s == bgp.p && a.s != a.p || bgp.s == bgp.o && a.s != a.o {
+		if bgp.s == bgp.p && !termEqual(a.s, a.p) || bgp.s == bgp.o && !termEqual(a.s, a.o) {
 			return false
 		}
 	}
 
 	// if p is variable, and a happens to point at the same
 	// variable, but the atom is different return false
-	if isVariable(bgp.p) && bgp.p == bgp.o && a.p != a.o {
+	if isVariable(bgp.p) && bgp.p == bgp.o && !termEqual(a.p, a.o) {
 		return false
 	}
 
@@ -465,6 +899,28 @@ func (bgp *Atom) toMu(a *Atom) Mu {
 	return mu
 }
 
+// bind substitutes all variables of a that are bound in mu, unbound
+// variables are kept
+func (a *Atom) bind(mu *Mu) Atom {
+	a_ := *a
+	if isVariable(a.s) {
+		if t, ok := (*mu)[a.s.(Variable)]; ok {
+			a_.s = t
+		}
+	}
+	if isVariable(a.p) {
+		if t, ok := (*mu)[a.p.(Variable)]; ok {
+			a_.p = t
+		}
+	}
+	if isVariable(a.o) {
+		if t, ok := (*mu)[a.o.(Variable)]; ok {
+			a_.o = t
+		}
+	}
+	return a_
+}
+
 // applyMapping creates a ground atom from an non ground atom and a
 // corresponding mu mapping
 func (a *Atom) applyMapping(mu *Mu) Atom {
@@ -478,19 +934,19 @@ func (a *Atom) applyMapping(mu *Mu) Atom {
 	if isVariable(a.s) {
 		ga.s = (*mu)[a.s.(Variable)]
 	} else {
-		ga.s = a.s.(Constant)
+		ga.s = a.s
 	}
 
 	if isVariable(a.p) {
 		ga.p = (*mu)[a.p.(Variable)]
 	} else {
-		ga.p = a.p.(Constant)
+		ga.p = a.p
 	}
 
 	if isVariable(a.o) {
 		ga.o = (*mu)[a.o.(Variable)]
 	} else {
-		ga.o = a.o.(Constant)
+		ga.o = a.o
 	}
 
 	return ga
@@ -506,7 +962,7 @@ type Mu map[Variable]Term
 func (m1 *Mu) compatible(m2 *Mu) bool {
 	for k, v := range *m1 {
 		v_, ok := (*m2)[k]
-		if ok && v != v_ {
+		if ok && !termEqual(v, v_) {
 			return false
 		}
 	}
@@ -518,7 +974,7 @@ func (m1 *Mu) negCompatible(m2 *Mu) bool {
 	for k, v := range *m2 {
 		v_, ok := (*m1)[k]
 		if ok {
-			if v != v_ {
+			if !termEqual(v, v_) {
 				return true
 			}
 		} else {
@@ -593,7 +1049,9 @@ func (o1 *Omega) joinNeg(o2 *Omega) Omega {
 	return o3
 }
 
-func (o1 *Omega) join(o2 *Omega) Omega {
+// joinNestedLoop joins o1 and o2 by testing every pair of mappings
+// for compatibility
+func (o1 *Omega) joinNestedLoop(o2 *Omega) Omega {
 	o3 := make(Omega, 0, len(*o1)+len(*o2))
 
 	for _, mu1 := range *o1 {
@@ -607,4 +1065,123 @@ func (o1 *Omega) join(o2 *Omega) Omega {
 	return o3
 }
 
+// domain returns the variables bound by every mapping of o
+func (o *Omega) domain() []Variable {
+	if len(*o) == 0 {
+		return nil
+	}
+	vars := make([]Variable, 0, len((*o)[0]))
+	for v, _ := range (*o)[0] {
+		inAll := true
+		for _, mu := range (*o)[1:] {
+			if _, ok := mu[v]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			vars = append(vars, v)
+		}
+	}
+	return vars
+}
+
+// sharedVariables returns the variables bound in all mappings of both
+// o1 and o2
+func (o1 *Omega) sharedVariables(o2 *Omega) []Variable {
+	vars := make([]Variable, 0)
+	dom2 := o2.domain()
+	for _, v := range o1.domain() {
+		for _, v_ := range dom2 {
+			if v == v_ {
+				vars = append(vars, v)
+				break
+			}
+		}
+	}
+	return vars
+}
+
+// termKey returns a string identifying t, literals are keyed by
+// their N-Triples form, which can never collide with a constant
+func termKey(t Term) string {
+	switch t_ := t.(type) {
+	case Constant:
+		return string(t_)
+	case Literal:
+		return literalToNT(t_)
+	}
+	return fmt.Sprint(t)
+}
+
+// muKey builds a hash key from the values mu binds to vars
+func muKey(mu *Mu, vars []Variable) string {
+	if len(vars) == 1 {
+		return termKey((*mu)[vars[0]])
+	}
+	var sb strings.Builder
+	for _, v := range vars {
+		sb.WriteString(termKey((*mu)[v]))
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
+
+// distinct returns the mappings of o without repetitions, which
+// duplicate facts in a database lead to
+func (o *Omega) distinct() Omega {
+	vars := o.domain()
+	seen := make(map[string]bool, len(*o))
+	o_ := make(Omega, 0, len(*o))
+	for _, mu := range *o {
+		k := muKey(&mu, vars)
+		if !seen[k] {
+			seen[k] = true
+			o_ = append(o_, mu)
+		}
+	}
+	return o_
+}
+
+// join joins two multisets o1 and o2 together based on mu
+// compatibility. A hash table is built on the smaller side keyed on
+// the shared variables and probed with the other side; without shared
+// variables this is the cross product.
+func (o1 *Omega) join(o2 *Omega) Omega {
+
+	if len(*o1) == 0 || len(*o2) == 0 {
+		return make(Omega, 0)
+	}
+
+	vars := o1.sharedVariables(o2)
+	if len(vars) == 0 {
+		return o1.joinNestedLoop(o2)
+	}
+
+	build, probe := o1, o2
+	if len(*o2) < len(*o1) {
+		build, probe = o2, o1
+	}
+
+	table := make(map[string][]int, len(*build))
+	for i, mu := range *build {
+		k := muKey(&mu, vars)
+		table[k] = append(table[k], i)
+	}
+
+	o3 := make(Omega, 0, len(*o1)+len(*o2))
+
+	for _, mu := range *probe {
+		for _, i := range table[muKey(&mu, vars)] {
+			// there may be more common variables than the ones
+			// shared by all mappings
+			if mu.compatible(&(*build)[i]) {
+				o3 = append(o3, mu.join(&(*build)[i]))
+			}
+		}
+	}
+
+	return o3
+}
+
 // }}}
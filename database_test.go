@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -79,6 +80,20 @@ func TestAtomMatches(t *testing.T) {
 	}
 }
 
+func TestNewAtomLoneQuote(t *testing.T) {
+
+	if a := newAtom(":a", ":name", `""`); a.o != String("") {
+		t.Error("wrong empty string", a.o)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("a lone quote should be rejected")
+		}
+	}()
+	newAtom(":a", ":name", `"`)
+}
+
 func TestDatabase(t *testing.T) {
 
 	as, db := mkDatabase()
@@ -100,3 +115,224 @@ func TestDatabase(t *testing.T) {
 	}
 
 }
+
+// scanMappings finds the mappings for bgp without using indexes
+func scanMappings(db *Database, bgp *Atom) Omega {
+	omega := make(Omega, 0)
+	for _, a := range decodeRel(db.rel(bgp.p.(Constant))) {
+		if bgp.matches(&a) {
+			if bgp.isGround() {
+				return Omega{make(Mu)}
+			}
+			omega = append(omega, bgp.toMu(&a))
+		}
+	}
+	return omega
+}
+
+func TestIndexMaintenance(t *testing.T) {
+
+	_, db := mkDatabase()
+
+	bgps := []Atom{
+		newAtom(":b", ":link", "?y"),
+		newAtom("?x", ":link", ":c"),
+		newAtom(":c", ":link", ":c"),
+		newAtom(":c", ":link", ":d"),
+		newAtom(":a", ":link", ":c"),
+	}
+
+	check := func(when string) {
+		for _, bgp := range bgps {
+			if len(db.findMappingsFor(&bgp)) != len(scanMappings(&db, &bgp)) {
+				t.Error(when, "index and scan disagree for", bgp)
+			}
+		}
+	}
+
+	check("after addAtom")
+
+	db.commit()
+	db_ := db.shallowCopy()
+	db_.addAtom(newAtom(":a", ":link", ":c"))
+	db.append(&db_, true)
+	check("after append")
+
+	if len(db.findMappingsFor(&bgps[4])) != 1 {
+		t.Error("appended atom not found through index")
+	}
+
+	db.revert()
+	check("after revert")
+
+	if len(db.findMappingsFor(&bgps[4])) != 0 {
+		t.Error("reverted atom still found through index")
+	}
+
+	del := db.shallowCopy()
+	del.addAtom(newAtom(":c", ":link", ":c"))
+	db.remove(&del)
+	check("after remove")
+
+	if len(db.findMappingsFor(&bgps[2])) != 0 {
+		t.Error("removed atom still found through index")
+	}
+
+	mu := Mu{Variable("?x"): Constant(":b")}
+	omega := db.findMappingsBound(&Atom{Variable("?x"), Constant(":link"), Variable("?y"), false}, &mu)
+	if len(omega) != 2 {
+		t.Error("expected two mappings for bound ?x", omega)
+	}
+}
+
+func TestSetSemantics(t *testing.T) {
+
+	_, db := mkDatabase()
+
+	db_ := db.shallowCopy()
+	db_.addAtom(newAtom(":a", ":link", ":b"))
+	db_.addAtom(newAtom(":x", ":link", ":y"))
+	db_.addAtom(newAtom(":x", ":link", ":y"))
+
+	db.append(&db_, true)
+
+	if len(db.edb[":link"]) != 6 {
+		t.Error("doublettes were appended", db.edb[":link"])
+	}
+
+	_, db2 := mkDatabase()
+	db2.addAtom(newAtom(":x", ":link", ":y"))
+
+	if !db.equalTo(&db2) || !db2.equalTo(&db) {
+		t.Error("databases should be equal")
+	}
+
+	db.remove(&db_)
+
+	if db.knows(newAtom(":a", ":link", ":b")) || db.knows(newAtom(":x", ":link", ":y")) {
+		t.Error("atoms were not removed")
+	}
+
+	if len(db.edb[":link"]) != 4 || !db.knows(newAtom(":c", ":link", ":d")) {
+		t.Error("wrong atoms removed", db.edb[":link"])
+	}
+}
+
+func TestHashJoin(t *testing.T) {
+
+	_, db := mkDatabase()
+
+	o1 := db.findMappingsFor(&Atom{Variable("?x"), Constant(":link"), Variable("?z"), false})
+	o2 := db.findMappingsFor(&Atom{Variable("?z"), Constant(":link"), Variable("?y"), false})
+	o3 := db.findMappingsFor(&Atom{Variable("?a"), Constant(":link"), Variable("?b"), false})
+
+	vars := o1.sharedVariables(&o2)
+	if len(vars) != 1 || vars[0] != Variable("?z") {
+		t.Error("wrong shared variables", vars)
+	}
+
+	for _, c := range [][2]Omega{{o1, o2}, {o2, o1}, {o1, o3}, {o1, o1}, {o1, Omega{}}} {
+		hashed := c[0].join(&c[1])
+		nested := c[0].joinNestedLoop(&c[1])
+
+		if len(hashed) != len(nested) {
+			t.Fatal("hash join and nested loop join differ", len(hashed), len(nested))
+		}
+
+		for _, mu := range nested {
+			found := false
+			for _, mu_ := range hashed {
+				if len(mu) == len(mu_) && mu.compatible(&mu_) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Error("missing mapping in hash join", mu)
+			}
+		}
+	}
+}
+
+func TestSavepoints(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		:a :link :b .
+		:b :link :c .
+		:c :link :d .
+	`)
+	prog := mkProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+
+	facts := func() string { return strings.Join(allFacts(&db), "\n") }
+	checkIndex := func() {
+		t.Helper()
+		bgp := newAtom("?x", ":reachable", ":d")
+		if len(db.findMappingsFor(&bgp)) != len(scanMappings(&db, &bgp)) {
+			t.Error("index and scan disagree after rollback")
+		}
+	}
+	initial := facts()
+
+	db.savepoint("outer")
+	del := loadTestTurtle(t, ":b :link :c .")
+	dRed(&db, &del, &prog)
+	ins := loadTestTurtle(t, ":d :link :e .")
+	prog.evalSeminaiveAppend(&db, &ins)
+	outer := facts()
+
+	db.savepoint("inner")
+	ins = loadTestTurtle(t, ":b :link :c .\n:e :link :a .")
+	prog.evalSeminaiveAppend(&db, &ins)
+	del = loadTestTurtle(t, ":c :link :d .")
+	dRed(&db, &del, &prog)
+
+	if err := db.rollback("inner"); err != nil || facts() != outer {
+		t.Fatalf("rollback to inner failed: %v\n%s\nexpected:\n%s", err, facts(), outer)
+	}
+	checkIndex()
+
+	// the inner savepoint stays open and can be rolled back again
+	ins = loadTestTurtle(t, ":x :link :a .")
+	prog.evalSeminaiveAppend(&db, &ins)
+	if err := db.rollback("inner"); err != nil || facts() != outer {
+		t.Fatal("second rollback to inner failed", err)
+	}
+
+	if err := db.rollback("outer"); err != nil || facts() != initial {
+		t.Fatalf("rollback to outer failed: %v\n%s\nexpected:\n%s", err, facts(), initial)
+	}
+	checkIndex()
+	if err := db.release("inner"); err == nil {
+		t.Error("inner savepoint still open after rollback to outer")
+	}
+
+	// released changes are kept and undone by an enclosing rollback
+	db.savepoint("inner")
+	prog.evalSeminaiveAppend(&db, &ins)
+	changed := facts()
+	if err := db.release("inner"); err != nil || facts() != changed {
+		t.Fatal("release failed", err)
+	}
+	if err := db.rollback("outer"); err != nil || facts() != initial {
+		t.Fatal("released changes not rolled back")
+	}
+	if err := db.release("outer"); err != nil || len(db.savepoints) != 0 {
+		t.Fatal("release of outer failed", err)
+	}
+
+	// commit and revert undo deletes as well
+	db.commit()
+	del = loadTestTurtle(t, ":a :link :b .")
+	dRed(&db, &del, &prog)
+	db.revert()
+	if facts() != initial {
+		t.Errorf("revert after delete failed:\n%s\nexpected:\n%s", facts(), initial)
+	}
+	checkIndex()
+
+	if err := db.rollback("unknown"); err == nil {
+		t.Error("rollback to unknown savepoint succeeded")
+	}
+}
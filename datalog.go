@@ -1,5 +1,7 @@
 package main
 
+import "fmt"
+
 type Program []Rule
 
 type DeltaProgram struct {
@@ -10,51 +12,115 @@ type DeltaProgram struct {
 type DeltaRule struct {
 	head, delta Atom
 	body        []Atom
+	builtins    []Builtin
+	// rule is the rule the delta rule was created from, pos the
+	// position of delta in its body
+	rule *Rule
+	pos  int
+	// plan caches the join order across seminaive iterations until
+	// the relation sizes change materially
+	plan *rulePlan
 }
 
 type Rule struct {
-	head Atom
-	body []Atom
+	head     Atom
+	body     []Atom
+	builtins []Builtin
+	// agg is set for aggregate rules, see Aggregate
+	agg *Aggregate
 }
 
-func (prog *Program) register(db *Database) {
+// register registers the relations of prog in db, see Rule.register.
+// If prog can not be evaluated, db is left untouched and an error is
+// returned.
+func (prog *Program) register(db *Database) error {
+	if err := prog.check(); err != nil {
+		return err
+	}
 	for _, r := range *prog {
 		r.register(db)
 	}
+	return nil
 }
 
-func (r *Rule) register(db *Database) {
-
-	if db.isEdbRelation(r.head.p.(Constant)) {
-		panic("rule head relation already registered as EDB relation in database")
+// check tests if prog can be stratified and each of its rules can be
+// evaluated, see Rule.check
+func (prog *Program) check() error {
+	if _, err := prog.stratify(); err != nil {
+		return err
+	}
+	for i := range *prog {
+		if err := (*prog)[i].check(); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// check tests if r can be evaluated: its head is a positive atom whose
+// variables are bound by the body, and each builtin input is bound
+func (r *Rule) check() error {
 
 	if r.head.neg {
-		panic("negation is not allowed in head atoms")
+		return fmt.Errorf("negation is not allowed in head atoms")
+	}
+
+	if isLiteral(r.head.p) {
+		return fmt.Errorf("only constant or variable allowed in p position")
+	}
+
+	bound, err := boundVariables(r.body, r.builtins)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range atomVariables(&r.head) {
+		if !bound[v] {
+			return fmt.Errorf("head variable %s of %s is not bound by the body", v, r)
+		}
+	}
+
+	if r.agg != nil && (r.head.o != r.agg.v || r.head.s == r.agg.v || r.head.p == r.agg.v) {
+		return fmt.Errorf("aggregated variable must be the object of the head only")
+	}
+
+	return nil
+}
+
+// register registers the relations of r in db. Body relations that
+// are unknown become edb relations. Heads with a variable predicate
+// create their idb relations when facts are derived, see addDerived.
+// Rules that can not be evaluated are rejected, see check.
+func (r *Rule) register(db *Database) error {
+
+	if err := r.check(); err != nil {
+		return err
 	}
 
-	if !db.isIdbRelation(r.head.p.(Constant)) {
+	if isConstant(r.head.p) {
 		db.registerIdbRel(r.head.p.(Constant))
 	}
 
 	for _, a := range r.body {
-		isIdb := db.isIdbRelation(a.p.(Constant))
-		if isIdb && a.neg {
-			panic("negation only allowed in edb predicates")
-		}
-		if !isIdb && !db.isEdbRelation(a.p.(Constant)) {
+		if isConstant(a.p) && !db.isIdbRelation(a.p.(Constant)) {
 			db.registerEdbRel(a.p.(Constant))
 		}
 	}
 
+	return nil
 }
 
+// toDeltaRules creates a DeltaRule per positive body atom. Negated
+// atoms get none, they refer to complete relations of lower strata.
+// Atoms with a variable predicate may match idb relations and always
+// get one.
 func (r *Rule) toDeltaRules(db *Database, idbOnly bool) []DeltaRule {
 	drules := make([]DeltaRule, 0, len(r.body))
+	rule := *r
 
 	for i, d := range r.body {
-		if isConstant(d.p) && (!idbOnly || db.isIdbRelation(d.p.(Constant))) {
-			dr := DeltaRule{head: r.head, delta: d, body: make([]Atom, 0, len(r.body)-1)}
+		if !d.neg && (!idbOnly || isVariable(d.p) || db.isIdbRelation(d.p.(Constant))) {
+			dr := DeltaRule{head: r.head, delta: d, body: make([]Atom, 0, len(r.body)-1), builtins: r.builtins, rule: &rule, pos: i, plan: &rulePlan{}}
 			for j := 0; j < i; j++ {
 				dr.body = append(dr.body, r.body[j])
 			}
@@ -71,6 +137,11 @@ func (r *Rule) toDeltaRules(db *Database, idbOnly bool) []DeltaRule {
 func (prog *Program) toDeltaProgram(db *Database, idbOnly bool) DeltaProgram {
 	dprog := DeltaProgram{rules: make([]Rule, 0), drules: make([]DeltaRule, 0)}
 	for _, r := range *prog {
+		if r.agg != nil {
+			// aggregates are computed over complete lower strata
+			dprog.rules = append(dprog.rules, r)
+			continue
+		}
 		drules := r.toDeltaRules(db, idbOnly)
 		if len(drules) == 0 {
 			dprog.rules = append(dprog.rules, r)
@@ -82,66 +153,36 @@ func (prog *Program) toDeltaProgram(db *Database, idbOnly bool) DeltaProgram {
 }
 
 // eval evaluates a DeltaRule w.r.t. to database instance and a delta
-// database instance, and returns a multiset omega
+// database instance, and returns a multiset omega. The delta atom is
+// joined first, the remaining body atoms in the order of the plan,
+// which is computed on the first call and reused as long as the sizes
+// of the relations it joins do not change materially.
 func (r *DeltaRule) eval(db, delta *Database) Omega {
 
-	omegas := make([]Omega, 0)
-	negOmegas := make([]Omega, 0)
-
-	if (*r).delta.neg {
-		negOmegas = append(negOmegas, delta.findMappingsFor(&(*r).delta))
-	} else {
-		omegas = append(omegas, delta.findMappingsFor(&(*r).delta))
+	if r.plan == nil {
+		plan := planBody(db, &(*r).delta, (*r).body, (*r).builtins)
+		return plan.eval(db, delta)
 	}
 
-	for _, b := range (*r).body {
-		if b.neg {
-			negOmegas = append(negOmegas, db.findMappingsFor(&b))
-		} else {
-			omegas = append(omegas, db.findMappingsFor(&b))
-		}
-	}
-
-	result := omegas[0]
-
-	for i := 1; i < len(omegas); i++ {
-		result = result.join(&omegas[i])
+	if sizes := bodySizes(db, r.body); r.plan.outdated(sizes) {
+		*r.plan = planBody(db, &(*r).delta, (*r).body, (*r).builtins)
+		r.plan.sizes = sizes
 	}
 
-	for i := 0; i < len(negOmegas); i++ {
-		result = result.joinNeg(&negOmegas[i])
-	}
-
-	return result
+	return r.plan.eval(db, delta)
 
 }
 
-// eval evaluates a DeltaRule w.r.t. to database instance and a delta
-// database instance, and returns a multiset omega
+// eval evaluates a Rule w.r.t. to database instance and returns a
+// multiset omega. For aggregate rules omega holds a mapping per group.
 func (r *Rule) eval(db *Database) Omega {
 
-	omegas := make([]Omega, 0)
-	negOmegas := make([]Omega, 0)
-
-	for _, b := range (*r).body {
-		if b.neg {
-			negOmegas = append(negOmegas, db.findMappingsFor(&b))
-		} else {
-			omegas = append(omegas, db.findMappingsFor(&b))
-		}
-	}
-
-	result := omegas[0]
-
-	for i := 1; i < len(omegas); i++ {
-		result = result.join(&omegas[i])
+	plan := planBody(db, nil, (*r).body, (*r).builtins)
+	if r.agg != nil {
+		omega := plan.eval(db, db)
+		return r.agg.apply(&(*r).head, omega.distinct())
 	}
-
-	for i := 0; i < len(negOmegas); i++ {
-		result = result.joinNeg(&negOmegas[i])
-	}
-
-	return result
+	return plan.eval(db, db)
 
 }
 
@@ -149,12 +190,13 @@ func (dprog *DeltaProgram) evalSeminaive_(db, delta *Database) Database {
 
 	delta_ := db.shallowCopy()
 
-	for _, r := range dprog.rules {
+	for i, r := range dprog.rules {
 		omega := r.eval(db)
 		for _, mu := range omega {
+			db.recordDerivation(&dprog.rules[i], &mu)
 			groundHead := r.head.applyMapping(&mu)
-			if !db.knows(groundHead) && !delta_.knows(groundHead) {
-				delta_.addAtom(groundHead)
+			if groundHead.isStorable() && !db.knows(groundHead) && !delta_.knows(groundHead) {
+				delta_.addDerived(groundHead)
 			}
 		}
 	}
@@ -162,9 +204,10 @@ func (dprog *DeltaProgram) evalSeminaive_(db, delta *Database) Database {
 	for _, r := range dprog.drules {
 		omega := r.eval(db, delta)
 		for _, mu := range omega {
+			db.recordDerivation(r.rule, &mu)
 			groundHead := r.head.applyMapping(&mu)
-			if !db.knows(groundHead) && !delta_.knows(groundHead) {
-				delta_.addAtom(groundHead)
+			if groundHead.isStorable() && !db.knows(groundHead) && !delta_.knows(groundHead) {
+				delta_.addDerived(groundHead)
 			}
 		}
 	}
@@ -172,7 +215,59 @@ func (dprog *DeltaProgram) evalSeminaive_(db, delta *Database) Database {
 	return delta_
 }
 
+// evalSeminaive evaluates prog stratum by stratum. With sameAs enabled
+// the strata are evaluated again until no more classes merge, see
+// sameAsMerged.
 func (prog *Program) evalSeminaive(db *Database) {
+	strata, err := prog.stratify()
+	if err != nil {
+		panic(err.Error())
+	}
+	db.dropCounts(prog)
+	if db.sameAs != nil {
+		db.mergeSameAs()
+		db.canonicalize(db)
+	}
+	for {
+		for _, s := range strata {
+			s.evalStratum(db)
+		}
+		if !prog.sameAsMerged(db) {
+			return
+		}
+	}
+}
+
+// sameAsMerged merges the classes related by new sameAs facts of db
+// and rewrites the facts mentioning the new aliases. Facts derived by
+// negation or aggregation may no longer hold, their relations are
+// cleared. The first iteration of evalStratum evaluates all rules, so
+// evaluating prog again completes db.
+func (prog *Program) sameAsMerged(db *Database) bool {
+	if db.sameAs == nil || !db.mergeSameAs() {
+		return false
+	}
+	db.canonicalize(db)
+	if !prog.isMonotonic(db) {
+		_, high := prog.splitMonotonic(db)
+		for _, r := range high {
+			if isVariable(r.head.p) {
+				for _, c := range db.relNames() {
+					if c != sameAsRel && db.isIdbRelation(c) {
+						db.clearIdbRel(c)
+					}
+				}
+				break
+			}
+			if r.head.p != sameAsRel {
+				db.clearIdbRel(r.head.p.(Constant))
+			}
+		}
+	}
+	return true
+}
+
+func (prog *Program) evalStratum(db *Database) {
 	dprog := prog.toDeltaProgram(db, true)
 
 	delta := dprog.evalSeminaive_(db, db)
@@ -186,12 +281,13 @@ func (prog *Program) evalNaive_(db *Database) Database {
 
 	delta := db.shallowCopy()
 
-	for _, r := range *prog {
+	for i, r := range *prog {
 		omega := r.eval(db)
 		for _, mu := range omega {
+			db.recordDerivation(&(*prog)[i], &mu)
 			groundHead := r.head.applyMapping(&mu)
-			if !db.knows(groundHead) && !delta.knows(groundHead) {
-				delta.addAtom(groundHead)
+			if groundHead.isStorable() && !db.knows(groundHead) && !delta.knows(groundHead) {
+				delta.addDerived(groundHead)
 			}
 		}
 	}
@@ -200,21 +296,23 @@ func (prog *Program) evalNaive_(db *Database) Database {
 }
 
 func (prog *Program) evalNaive(db *Database) {
-	delta := prog.evalNaive_(db)
-	for !delta.empty() {
-		db.append(&delta, false)
-		delta = prog.evalNaive_(db)
+	strata, err := prog.stratify()
+	if err != nil {
+		panic(err.Error())
+	}
+	db.dropCounts(prog)
+	for _, s := range strata {
+		delta := s.evalNaive_(db)
+		for !delta.empty() {
+			db.append(&delta, false)
+			delta = s.evalNaive_(db)
+		}
 	}
 }
 
+// evalSeminaiveAppend appends db_ to db and derives the consequences,
+// see maintain
 func (prog *Program) evalSeminaiveAppend(db, db_ *Database) {
-	dprog := prog.toDeltaProgram(db, false)
-
-	delta := dprog.evalSeminaive_(db, db_)
-	db.append(db_, false)
-
-	for !delta.empty() {
-		db.append(&delta, false)
-		delta = dprog.evalSeminaive_(db, &delta)
-	}
+	del := db.shallowCopy()
+	maintain(db, db_, &del, prog, (*Program).dRedDelete)
 }
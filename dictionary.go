@@ -0,0 +1,153 @@
+package main
+
+import "sync"
+
+// termID is the dictionary encoding of a ground term
+type termID uint32
+
+// triple is the stored form of a ground atom: the ids of its subject,
+// predicate and object
+type triple [3]termID
+
+// dictionary interns ground terms to dense integer ids. Terms are
+// decoded from the same Term values they were interned with, so
+// decoding does not allocate. It is safe for concurrent use.
+type dictionary struct {
+	mu    sync.RWMutex
+	ids   map[string]termID
+	terms []Term
+}
+
+func newDictionary() *dictionary {
+	return &dictionary{
+		ids:   make(map[string]termID),
+		terms: make([]Term, 0),
+	}
+}
+
+// dict is shared by all databases, so that triples can be moved
+// between a database and its deltas without reencoding. Terms are
+// never removed, the dictionary lives as long as the process and
+// keeps the terms of databases that are no longer used.
+var dict = newDictionary()
+
+// encode returns the id of t, interning it if necessary
+func (d *dictionary) encode(t Term) termID {
+	k := termKey(t)
+	d.mu.RLock()
+	id, ok := d.ids[k]
+	d.mu.RUnlock()
+	if ok {
+		return id
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// another writer may have interned t in the meantime
+	if id, ok := d.ids[k]; ok {
+		return id
+	}
+	id = termID(len(d.terms))
+	d.ids[k] = id
+	d.terms = append(d.terms, t)
+	return id
+}
+
+// lookup returns the id of t without interning it, ok is false if
+// the term was never stored
+func (d *dictionary) lookup(t Term) (termID, bool) {
+	k := termKey(t)
+	d.mu.RLock()
+	id, ok := d.ids[k]
+	d.mu.RUnlock()
+	return id, ok
+}
+
+func (d *dictionary) decode(id termID) Term {
+	d.mu.RLock()
+	t := d.terms[id]
+	d.mu.RUnlock()
+	return t
+}
+
+func encodeAtom(a *Atom) triple {
+	return triple{dict.encode(a.s), dict.encode(a.p), dict.encode(a.o)}
+}
+
+// lookupAtom encodes the ground atom a without interning new terms,
+// ok is false if any of its terms is unknown
+func lookupAtom(a *Atom) (triple, bool) {
+	s, ok1 := dict.lookup(a.s)
+	p, ok2 := dict.lookup(a.p)
+	o, ok3 := dict.lookup(a.o)
+	return triple{s, p, o}, ok1 && ok2 && ok3
+}
+
+func (t triple) toAtom() Atom {
+	return Atom{s: dict.decode(t[0]), p: dict.decode(t[1]), o: dict.decode(t[2])}
+}
+
+func decodeRel(rel []triple) []Atom {
+	as := make([]Atom, 0, len(rel))
+	for _, t := range rel {
+		as = append(as, t.toAtom())
+	}
+	return as
+}
+
+// pattern is a bgp with its constants encoded, it matches stored
+// triples by comparing ids only
+type pattern struct {
+	terms [3]Term
+	ids   [3]termID
+	// same[i] is the position of the first occurrence of the variable
+	// at position i
+	same [3]int
+}
+
+// compile encodes the constants of bgp, ok is false if any of them is
+// unknown and the bgp can thus not match anything
+func (bgp *Atom) compile() (pattern, bool) {
+	pat := pattern{terms: [3]Term{bgp.s, bgp.p, bgp.o}}
+	for i, t := range pat.terms {
+		pat.same[i] = i
+		if !isVariable(t) {
+			id, ok := dict.lookup(t)
+			if !ok {
+				return pat, false
+			}
+			pat.ids[i] = id
+			continue
+		}
+		for j := 0; j < i; j++ {
+			if pat.terms[j] == t {
+				pat.same[i] = j
+				break
+			}
+		}
+	}
+	return pat, true
+}
+
+func (pat *pattern) matches(t triple) bool {
+	for i, term := range pat.terms {
+		if !isVariable(term) {
+			if t[i] != pat.ids[i] {
+				return false
+			}
+		} else if t[i] != t[pat.same[i]] {
+			return false
+		}
+	}
+	return true
+}
+
+func (pat *pattern) toMu(t triple) Mu {
+	mu := make(Mu)
+	for i, term := range pat.terms {
+		if isVariable(term) {
+			mu[term.(Variable)] = dict.decode(t[i])
+		}
+	}
+	return mu
+}
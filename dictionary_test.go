@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDictionary(t *testing.T) {
+
+	a := newAtom(":dict1", ":dictLink", ":dict2")
+	tr := encodeAtom(&a)
+
+	if tr.toAtom() != a {
+		t.Error("decoding does not give back the atom", tr.toAtom(), a)
+	}
+
+	if tr2 := encodeAtom(&a); tr2 != tr {
+		t.Error("terms were interned twice", tr, tr2)
+	}
+
+	n := len(dict.terms)
+	db := newDatabase()
+	if db.knows(newAtom(":dictUnknown", ":dictLink", ":dict2")) {
+		t.Error("unknown atom should not be known")
+	}
+	if len(dict.terms) != n {
+		t.Error("lookups must not intern terms")
+	}
+}
+
+func TestPatternMatches(t *testing.T) {
+
+	a1 := newAtom(":a", ":link", ":a")
+	a2 := newAtom(":a", ":link", ":b")
+	t1, t2 := encodeAtom(&a1), encodeAtom(&a2)
+
+	bgp := newAtom("?x", ":link", "?x")
+	pat, ok := bgp.compile()
+	if !ok {
+		t.Fatal("bgp with known constants should compile")
+	}
+
+	if !pat.matches(t1) || pat.matches(t2) {
+		t.Error("repeated variables are not respected")
+	}
+
+	if mu := pat.toMu(t1); len(mu) != 1 || mu["?x"] != Constant(":a") {
+		t.Error("wrong mapping", mu)
+	}
+
+	bgp2 := newAtom("?x", ":link", ":neverStored")
+	if _, ok := bgp2.compile(); ok {
+		t.Error("bgp with unknown constant should not compile")
+	}
+}
+
+func TestDictionaryConcurrent(t *testing.T) {
+
+	// databases loaded concurrently share the dictionary
+	dbs := make([]Database, 4)
+	var wg sync.WaitGroup
+	for i := range dbs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var sb strings.Builder
+			for j := 0; j < 200; j++ {
+				fmt.Fprintf(&sb, ":c%d :dictLink :c%d, :d%d_%d .\n", j, j+1, i, j)
+			}
+			dbs[i] = newDatabase()
+			if _, err := loadTurtle(strings.NewReader(sb.String()), &dbs[i]); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, db := range dbs {
+		if len(db.rel(":dictLink")) != 400 {
+			t.Fatal("wrong number of facts", len(db.rel(":dictLink")))
+		}
+		for j := 0; j < 200; j += 50 {
+			c := fmt.Sprintf(":c%d", j)
+			if !db.knows(newAtom(c, ":dictLink", fmt.Sprintf(":c%d", j+1))) || !db.knows(newAtom(c, ":dictLink", fmt.Sprintf(":d%d_%d", i, j))) {
+				t.Error("fact lost while loading concurrently", c)
+			}
+		}
+	}
+}
@@ -8,8 +8,9 @@ func (dprog *DeltaProgram) evalOverEstimate_(db, del *Database) Database {
 		omega := r.eval(db, del)
 		for _, mu := range omega {
 			groundHead := r.head.applyMapping(&mu)
-			if !del.knows(groundHead) && !delta_.knows(groundHead) {
-				delta_.addAtom(groundHead)
+			// deleted base facts may be derived facts as well
+			if groundHead.isStorable() && !del.knowsDerived(groundHead) && !delta_.knows(groundHead) {
+				delta_.addDerived(groundHead)
 			}
 		}
 	}
@@ -32,7 +33,7 @@ func (prog *Program) evalOverEstimate(db, del *Database) {
 func (prog *Program) toAltDeriveDeltaProgram() DeltaProgram {
 	dprog := DeltaProgram{rules: make([]Rule, 0), drules: make([]DeltaRule, 0)}
 	for _, r := range *prog {
-		dr := DeltaRule{head: r.head, delta: r.head, body: r.body}
+		dr := DeltaRule{head: r.head, delta: r.head, body: r.body, builtins: r.builtins, plan: &rulePlan{}}
 		dprog.drules = append(dprog.drules, dr)
 	}
 	return dprog
@@ -46,8 +47,8 @@ func (dprog *DeltaProgram) evalAltDerive_(db, del *Database) Database {
 		omega := r.eval(db, del)
 		for _, mu := range omega {
 			groundHead := r.head.applyMapping(&mu)
-			if !db.knows(groundHead) && !delta_.knows(groundHead) {
-				delta_.addAtom(groundHead)
+			if groundHead.isStorable() && !db.knows(groundHead) && !delta_.knows(groundHead) {
+				delta_.addDerived(groundHead)
 			}
 		}
 	}
@@ -65,51 +66,27 @@ func (prog *Program) evalAltDerive(db, del *Database) {
 	}
 }
 
-func dRed(db, del *Database, prog *Program) {
-
-	// fmt.Println("DRed start:")
-	// db.dump()
-	// del.dump()
-
-	// orig_del_size := del.size()
-	// db_size := db.size()
-
-	// overEstStart := time.Now()
-	prog.evalOverEstimate(db, del)
-	// overEstElapsed := time.Since(overEstStart)
-
-	// fmt.Println("Overest:")
-	// db.dump()
-	// del.dump()
-
-	// overest_size := del.size()
-
-	// removeRelsStart := time.Now()
-	// removeRels(&(*db).edb, &(*del).edb)
-	db.remove(del)
-	// removeRelsElapsed := time.Since(removeRelsStart)
+// deletion maintains a recursive component after deletions, see
+// dRedDelete and bfDelete
+type deletion func(prog *Program, db, gone *Database)
 
-	// fmt.Println("Removed Overest:")
-	// db.dump()
-	// del.dump()
-
-	// del_size := del.size()
-
-	// altDeriveStart := time.Now()
-	prog.evalAltDerive(db, del)
-	// altDeriveElapsed := time.Since(altDeriveStart)
-
-	// fmt.Println("Rederived:")
-	// db.dump()
-	// del.dump()
+// dRed removes del from db together with all facts that are no longer
+// derivable. Recursive components are maintained by overestimating and
+// rederiving, see deleteFacts.
+func dRed(db, del *Database, prog *Program) {
+	deleteFacts(db, del, prog, (*Program).dRedDelete)
+}
 
-	// fmt.Println(
-	// 	orig_del_size,
-	// 	db_size,
-	// 	overest_size,
-	// 	del_size,
-	// 	uint64(overEstElapsed/time.Millisecond),
-	// 	uint64(removeRelsElapsed/time.Millisecond),
-	// 	uint64(altDeriveElapsed/time.Millisecond))
+// backwardForward removes del from db like dRed, recursive components
+// are maintained by the Backward/Forward algorithm, see bf.go
+func backwardForward(db, del *Database, prog *Program) {
+	deleteFacts(db, del, prog, (*Program).bfDelete)
+}
 
+// deleteFacts removes del from db together with all facts that are no
+// longer derivable, see maintain. Recursive components are maintained
+// by recursive.
+func deleteFacts(db, del *Database, prog *Program, recursive deletion) {
+	ins := db.shallowCopy()
+	maintain(db, &ins, del, prog, recursive)
 }
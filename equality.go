@@ -0,0 +1,286 @@
+package main
+
+// Equality reasoning under owl:sameAs. Materializing the sameAs closure
+// and all facts it entails grows quadratically with the size of the
+// equivalence classes. A Database with sameAs enabled instead keeps a
+// union-find over the constants related by owl:sameAs and works with
+// one representative per class, the constant with the smallest id:
+//
+//   - base facts are stored as inserted, so that they can be deleted.
+//     Facts mentioning an alias (a constant that is not its own
+//     representative) are hidden from evaluation, their rewritten
+//     copies are added as derived facts, see canonicalize.
+//   - derived facts and the atoms looked up by findMappingsFor, knows
+//     and knowsDerived are rewritten to representatives.
+//   - owl:sameAs facts, base or derived, merge classes. evalSeminaive
+//     and evalSeminaiveAppend evaluate until no more classes merge.
+//   - classes are never split incrementally: clearIdb rebuilds the
+//     union-find from the base facts, dRed recomputes if sameAs facts
+//     are deleted.
+//   - findMappingsExpanded and the exporters expand representatives
+//     back to all aliases.
+//
+// Only subjects and objects are rewritten, predicates are not.
+
+// equality is a union-find over the ids of constants
+type equality struct {
+	parent  map[termID]termID
+	members map[termID][]termID
+}
+
+var sameAsRel = iriToConstant(owlNs + "sameAs")
+
+func newEquality() *equality {
+	return &equality{
+		parent:  make(map[termID]termID),
+		members: make(map[termID][]termID),
+	}
+}
+
+func (eq *equality) find(id termID) termID {
+	p, ok := eq.parent[id]
+	if !ok || p == id {
+		return id
+	}
+	r := eq.find(p)
+	eq.parent[id] = r
+	return r
+}
+
+// union merges the classes of a and b, it returns false if they were
+// equal already
+func (eq *equality) union(a, b termID) bool {
+	ra, rb := eq.find(a), eq.find(b)
+	if ra == rb {
+		return false
+	}
+
+	// the smallest id represents the class, regardless of the order
+	// of the unions
+	if rb < ra {
+		ra, rb = rb, ra
+	}
+
+	eq.members[ra] = append(eq.aliases(ra), eq.aliases(rb)...)
+	delete(eq.members, rb)
+	eq.parent[ra] = ra
+	eq.parent[rb] = ra
+	return true
+}
+
+func (eq *equality) isAlias(id termID) bool {
+	return eq.find(id) != id
+}
+
+// aliases returns all members of the class of id
+func (eq *equality) aliases(id termID) []termID {
+	if ms, ok := eq.members[eq.find(id)]; ok {
+		return ms
+	}
+	return []termID{id}
+}
+
+func (eq *equality) clone() *equality {
+	eq_ := newEquality()
+	for k, v := range eq.parent {
+		eq_.parent[k] = v
+	}
+	for k, v := range eq.members {
+		eq_.members[k] = append([]termID(nil), v...)
+	}
+	return eq_
+}
+
+// enableSameAs turns on equality reasoning for d, see above
+func (d *Database) enableSameAs() {
+	d.registerEdbRel(sameAsRel)
+	d.resetSameAs()
+}
+
+// resetSameAs rebuilds the union-find from the sameAs facts of d
+func (d *Database) resetSameAs() {
+	d.sameAs = newEquality()
+	d.mergeSameAs()
+}
+
+// mergeSameAs merges the classes related by the sameAs facts of d, it
+// returns whether any classes were merged
+func (d *Database) mergeSameAs() bool {
+	merged := false
+	for _, t := range d.rel(sameAsRel) {
+		if isConstant(dict.decode(t[0])) && isConstant(dict.decode(t[2])) && d.sameAs.union(t[0], t[2]) {
+			merged = true
+		}
+	}
+	return merged
+}
+
+func (d *Database) canonicalTerm(t Term) Term {
+	if !isConstant(t) {
+		return t
+	}
+	id, ok := dict.lookup(t)
+	if !ok {
+		return t
+	}
+	return dict.decode(d.sameAs.find(id))
+}
+
+// canonicalAtom rewrites subject and object of a to their
+// representatives
+func (d *Database) canonicalAtom(a *Atom) Atom {
+	a_ := *a
+	if d.sameAs != nil {
+		a_.s, a_.o = d.canonicalTerm(a.s), d.canonicalTerm(a.o)
+	}
+	return a_
+}
+
+// isAliasTriple tests if t mentions an alias and is thus hidden from
+// evaluation
+func (d *Database) isAliasTriple(t triple) bool {
+	return d.sameAs.isAlias(t[0]) || d.sameAs.isAlias(t[2])
+}
+
+// aliasCopies returns the rewritten copies of the facts of d that
+// mention an alias
+func (d *Database) aliasCopies() []Atom {
+	as := make([]Atom, 0)
+	for _, rels := range []map[Constant][]triple{d.edb, d.idb} {
+		for _, rel := range rels {
+			for _, t := range rel {
+				if d.isAliasTriple(t) {
+					a := t.toAtom()
+					as = append(as, d.canonicalAtom(&a))
+				}
+			}
+		}
+	}
+	return as
+}
+
+// canonicalize adds the rewritten copies of the facts of d mentioning
+// an alias, unless d or db knows them already. The union-find of db is
+// used, so that d may be a delta of db.
+func (d *Database) canonicalize(db *Database) {
+	d.sameAs = db.sameAs
+	for _, a := range d.aliasCopies() {
+		if a.isStorable() && !db.knows(a) && !d.knows(a) {
+			d.addDerived(a)
+		}
+	}
+}
+
+// aliases returns all terms the representative t stands for
+func (d *Database) aliases(t Term) []Term {
+	if d.sameAs == nil || !isConstant(t) {
+		return []Term{t}
+	}
+	id, ok := dict.lookup(t)
+	if !ok {
+		return []Term{t}
+	}
+	ts := make([]Term, 0, 1)
+	for _, id_ := range d.sameAs.aliases(id) {
+		ts = append(ts, dict.decode(id_))
+	}
+	return ts
+}
+
+// expand returns all atoms a represents, rewriting subject and object
+// to each of their aliases
+func (d *Database) expand(a Atom) []Atom {
+	if d.sameAs == nil {
+		return []Atom{a}
+	}
+
+	as := make([]Atom, 0, 1)
+	for _, s := range d.aliases(a.s) {
+		for _, o := range d.aliases(a.o) {
+			as = append(as, Atom{s: s, p: a.p, o: o})
+		}
+	}
+	return as
+}
+
+// findMappingsExpanded finds all mappings for bgp like
+// findMappingsFor, with the representatives bound to subject and
+// object variables expanded to all their aliases
+func (db *Database) findMappingsExpanded(bgp *Atom) Omega {
+	return db.expandMappings(db.findMappingsFor(bgp), []Atom{*bgp})
+}
+
+// expandMappings expands the representatives bound to the subject and
+// object variables of the positive atoms of body in omega to all their
+// aliases, each mapping of omega to one mapping per combination
+func (db *Database) expandMappings(omega Omega, body []Atom) Omega {
+	if db.sameAs == nil {
+		return omega
+	}
+
+	vars := make([]Variable, 0)
+	seen := make(map[Variable]bool)
+	for _, a := range body {
+		if a.neg {
+			continue
+		}
+		for _, t := range []Term{a.s, a.o} {
+			if v, ok := t.(Variable); ok && !seen[v] {
+				seen[v] = true
+				vars = append(vars, v)
+			}
+		}
+	}
+
+	omega_ := make(Omega, 0, len(omega))
+	for _, mu := range omega {
+		expanded := Omega{mu}
+		for _, v := range vars {
+			aliases := db.aliases(mu[v])
+			if len(aliases) < 2 {
+				continue
+			}
+			expanded_ := make(Omega, 0, len(expanded)*len(aliases))
+			for _, mu_ := range expanded {
+				for _, t := range aliases {
+					mu__ := make(Mu, len(mu_))
+					for k, t_ := range mu_ {
+						mu__[k] = t_
+					}
+					mu__[v] = t
+					expanded_ = append(expanded_, mu__)
+				}
+			}
+			expanded = expanded_
+		}
+		omega_ = append(omega_, expanded...)
+	}
+	return omega_
+}
+
+// exportRel returns the triples of relName in rels to be exported. With
+// sameAs enabled, derived facts are expanded to all aliases, leaving out
+// hidden facts and base facts.
+func (d *Database) exportRel(rels *map[Constant][]triple, relName Constant) []triple {
+	rel := (*rels)[relName]
+	if d.sameAs == nil || rels != &d.idb {
+		return rel
+	}
+
+	edbIdx := d.edbIndexes[relName]
+	seen := make(map[triple]bool)
+	rel_ := make([]triple, 0, len(rel))
+	for _, t := range rel {
+		if d.isAliasTriple(t) {
+			continue
+		}
+		for _, a := range d.expand(t.toAtom()) {
+			t_ := encodeAtom(&a)
+			if !seen[t_] && (edbIdx == nil || !relKnows(edbIdx, t_)) {
+				seen[t_] = true
+				rel_ = append(rel_, t_)
+			}
+		}
+	}
+	return rel_
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const sameAsData = `
+@prefix owl: <http://www.w3.org/2002/07/owl#> .
+
+:a owl:sameAs :b .
+:c owl:sameAs :b .
+:a :knows :x .
+:c :likes :y .
+:x owl:sameAs :x2 .
+:y :likes :z .
+`
+
+const sameAsRules = `
+?s :friendly ?o :- ?s :knows ?o, ?s :likes ?y.
+?s :likes ?o :- ?s :likes ?y, ?y :likes ?o.
+`
+
+// sameAsReference materializes owl:sameAs with plain rules, which
+// sameAs enabled databases must agree with
+const sameAsReference = `
+@prefix owl: <http://www.w3.org/2002/07/owl#> .
+?y owl:sameAs ?x :- ?x owl:sameAs ?y.
+?x owl:sameAs ?z :- ?x owl:sameAs ?y, ?y owl:sameAs ?z.
+?s2 ?p ?o :- ?s owl:sameAs ?s2, ?s ?p ?o.
+?s ?p ?o2 :- ?o owl:sameAs ?o2, ?s ?p ?o.
+`
+
+// expandedFacts returns the facts of db like allFacts, expanded to all
+// aliases
+func expandedFacts(db *Database) string {
+	facts := make([]string, 0)
+	for _, mu := range db.findMappingsExpanded(&Atom{Variable("?s"), Variable("?p"), Variable("?o"), false}) {
+		facts = append(facts, termToNT(mu["?s"])+" "+termToNT(mu["?p"])+" "+termToNT(mu["?o"]))
+	}
+	sort.Strings(facts)
+	return strings.Join(facts, "\n")
+}
+
+func sameAsProgram(t *testing.T, db *Database, reference bool) Program {
+	src := sameAsRules
+	if reference {
+		src += sameAsReference
+	}
+	prog, err := parseProgramString(src, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog.register(db)
+	return prog
+}
+
+// checkSameAs compares db to the recomputation of its base facts with
+// the reference rules
+func checkSameAs(t *testing.T, db *Database) {
+	t.Helper()
+
+	expected := db.deepCopy()
+	expected.sameAs = nil
+	expected.clearIdb()
+	prog := sameAsProgram(t, &expected, true)
+	prog.evalSeminaive(&expected)
+
+	if facts, expectedFacts := expandedFacts(db), expandedFacts(&expected); facts != expectedFacts {
+		t.Errorf("wrong facts:\n%s\nexpected:\n%s", facts, expectedFacts)
+	}
+}
+
+func TestEquality(t *testing.T) {
+	eq := newEquality()
+	eq.union(3, 5)
+	eq.union(7, 5)
+	eq.union(1, 9)
+
+	if eq.find(7) != 3 || eq.find(5) != 3 || eq.find(9) != 1 || eq.find(4) != 4 {
+		t.Error("wrong representatives")
+	}
+	if eq.union(7, 3) {
+		t.Error("union of equal constants")
+	}
+	if !eq.isAlias(5) || eq.isAlias(3) || eq.isAlias(4) {
+		t.Error("wrong aliases")
+	}
+	if ms := eq.aliases(5); len(ms) != 3 {
+		t.Error("wrong members:", ms)
+	}
+
+	eq_ := eq.clone()
+	eq_.union(3, 1)
+	if eq.find(9) != 1 || eq.find(3) != 3 || eq_.find(3) != 1 {
+		t.Error("clone is not independent")
+	}
+}
+
+func TestSameAs(t *testing.T) {
+
+	db := loadTestTurtle(t, sameAsData)
+	db.enableSameAs()
+	prog := sameAsProgram(t, &db, false)
+	prog.evalSeminaive(&db)
+
+	checkSameAs(t, &db)
+
+	// the join on :a, :c needs the rewriting, the class of :x is only
+	// expanded
+	for _, s := range []string{":a", ":b", ":c"} {
+		for _, o := range []string{":x", ":x2"} {
+			if !db.knows(Atom{Constant(s), Constant(":friendly"), Constant(o), false}) {
+				t.Error("not derived:", s, ":friendly", o)
+			}
+		}
+	}
+
+	// one fact between the representatives instead of 6
+	if n := len(db.idb[Constant(":friendly")]); n != 1 {
+		t.Error("wrong number of derived :friendly facts:", n)
+	}
+
+	var buf bytes.Buffer
+	if err := db.writeNTriples(&buf, exportAll); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<urn:contki:b> <urn:contki:friendly> <urn:contki:x2> .") {
+		t.Error("export is not expanded:\n", buf.String())
+	}
+}
+
+func TestSameAsAppend(t *testing.T) {
+
+	db := loadTestTurtle(t, sameAsData)
+	db.enableSameAs()
+	prog := sameAsProgram(t, &db, false)
+	prog.evalSeminaive(&db)
+	before := expandedFacts(&db)
+	db.commit()
+
+	// merges the classes of :a and :z
+	db_ := loadTestTurtle(t, `
+		@prefix owl: <http://www.w3.org/2002/07/owl#> .
+		:z owl:sameAs :c .
+		:d :knows :a .
+	`)
+	prog.evalSeminaiveAppend(&db, &db_)
+	checkSameAs(t, &db)
+
+	db.revert()
+	if facts := expandedFacts(&db); facts != before {
+		t.Errorf("wrong facts after revert:\n%s\nexpected:\n%s", facts, before)
+	}
+}
+
+func TestSameAsDRed(t *testing.T) {
+
+	for _, src := range []string{
+		// splits the class of :a, :b and :c
+		`
+		@prefix owl: <http://www.w3.org/2002/07/owl#> .
+		:c owl:sameAs :b .
+		`,
+		// a base fact of an alias, whose rewritten copy is still
+		// derivable
+		`
+		:c :likes :y .
+		`,
+	} {
+		db := loadTestTurtle(t, sameAsData, ":b :likes :y .")
+		db.enableSameAs()
+		prog := sameAsProgram(t, &db, false)
+		prog.evalSeminaive(&db)
+
+		del := loadTestTurtle(t, src)
+		dRed(&db, &del, &prog)
+		checkSameAs(t, &db)
+	}
+
+	db := loadTestTurtle(t, sameAsData)
+	db.enableSameAs()
+	prog := sameAsProgram(t, &db, false)
+	prog.evalSeminaive(&db)
+
+	del := loadTestTurtle(t, `
+		@prefix owl: <http://www.w3.org/2002/07/owl#> .
+		:c owl:sameAs :b .
+	`)
+	dRed(&db, &del, &prog)
+	checkSameAs(t, &db)
+
+	if db.knows(Atom{Constant(":c"), Constant(":friendly"), Constant(":x"), false}) {
+		t.Error(":c is still the same as :a")
+	}
+}
+
+func TestSameAsOwlRl(t *testing.T) {
+
+	db := loadTestTurtle(t, owlOntology, `
+		@prefix owl: <http://www.w3.org/2002/07/owl#> .
+		:bob owl:sameAs :robert .
+		:robert owl:differentFrom :bob .
+	`)
+	db.enableSameAs()
+	prog := owlRlProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+
+	if !db.knows(Atom{Constant(":robert"), Constant(":childOf"), Constant(":ann"), false}) {
+		t.Error(":robert is not the same as :bob")
+	}
+
+	err := owlRlViolations(&db)
+	if err == nil || !strings.Contains(err.Error(), "eq-diff1") {
+		t.Error("sameAs and differentFrom not reported:", err)
+	}
+}
@@ -14,14 +14,28 @@ func mkProgram() Program {
 			newAtom("?x", ":link", "?z"),
 			newAtom("?z", ":reachable", "?y")}}
 
-	// semi positive datalog
-	// r3 := Rule{
-	// 	head: newAtom("?x", ":indirect", "?y"),
-	// 	body: []Atom{
-	// 		newNegAtom("?x", ":link", "?y"),
-	// 		newAtom("?x", ":reachable", "?y")}}
-
-	return Program{r1, r2} //, r3}
+	return Program{r1, r2}
+
+}
+
+// mkStratifiedProgram extends mkProgram by a rule negating the idb
+// relation :reachable, evaluated in a second stratum
+func mkStratifiedProgram() Program {
+
+	r3 := Rule{
+		head: newAtom("?x", ":indirect", "?y"),
+		body: []Atom{
+			newNegAtom("?x", ":link", "?y"),
+			newAtom("?x", ":reachable", "?y")}}
+
+	r4 := Rule{
+		head: newAtom("?x", ":unreachable", "?y"),
+		body: []Atom{
+			newAtom("?x", ":link", "?z"),
+			newAtom("?w", ":link", "?y"),
+			newNegAtom("?x", ":reachable", "?y")}}
+
+	return append(mkProgram(), r3, r4)
 
 }
 
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// selects the relations written by the exporters
+const (
+	exportEdb = 1 << iota
+	exportIdb
+	exportAll = exportEdb | exportIdb
+)
+
+// termToNT renders a term in N-Triples syntax, constants of the
+// default namespace are expanded to full iris.
+func termToNT(t Term) string {
+	if isLiteral(t) {
+		return literalToNT(t.(Literal))
+	}
+	c := string(t.(Constant))
+	if c[0] == ':' {
+		return "<" + defaultNamespace + c[1:] + ">"
+	}
+	return c
+}
+
+// isHelperRel tests if c is a relation in helperNamespace, which is
+// not exported
+func isHelperRel(c Constant) bool {
+	return strings.HasPrefix(string(c), "<"+helperNamespace)
+}
+
+// sortedRelNames returns the exported relation names of rels in order
+func sortedRelNames(rels *map[Constant][]triple) []Constant {
+	names := make([]Constant, 0, len(*rels))
+	for relName, _ := range *rels {
+		if !isHelperRel(relName) {
+			names = append(names, relName)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// exportRels returns the relation maps selected by which
+func (d *Database) exportRels(which int) []*map[Constant][]triple {
+	rels := make([]*map[Constant][]triple, 0, 2)
+	if which&exportEdb != 0 {
+		rels = append(rels, &(*d).edb)
+	}
+	if which&exportIdb != 0 {
+		rels = append(rels, &(*d).idb)
+	}
+	return rels
+}
+
+// writeNTriples writes the selected relations of d to w, one triple
+// per line
+func (d *Database) writeNTriples(w io.Writer, which int) error {
+	bw := bufio.NewWriter(w)
+
+	for _, rels := range d.exportRels(which) {
+		for _, relName := range sortedRelNames(rels) {
+			p := termToNT(relName)
+			for _, t := range d.exportRel(rels, relName) {
+				a := t.toAtom()
+				bw.WriteString(termToNT(a.s))
+				bw.WriteByte(' ')
+				bw.WriteString(p)
+				bw.WriteByte(' ')
+				bw.WriteString(termToNT(a.o))
+				bw.WriteString(" .\n")
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// compactIri abbreviates a full iri constant to a prefixed name using
+// the longest matching namespace in prefixes
+func compactIri(t Term, prefixes map[string]string) string {
+	if isLiteral(t) {
+		// arrays are only written as [...] inside other arrays
+		if _, ok := t.(Array); ok {
+			return literalToNT(t.(Literal))
+		}
+		return literalShort(t.(Literal))
+	}
+	iri := termToNT(t)
+	if iri[0] != '<' {
+		return iri
+	}
+	iri = iri[1 : len(iri)-1]
+
+	best, bestNs := "", ""
+	for prefix, ns := range prefixes {
+		if len(ns) > len(bestNs) && strings.HasPrefix(iri, ns) && isLocalName(iri[len(ns):]) {
+			best, bestNs = prefix, ns
+		}
+	}
+
+	if bestNs == "" {
+		return "<" + iri + ">"
+	}
+	return best + ":" + iri[len(bestNs):]
+}
+
+// writeTurtle writes the selected relations of d to w in Turtle
+// syntax, grouping triples by subject. Iris are compacted against
+// prefixes (prefix -> namespace), the default namespace is always
+// available as ':'.
+func (d *Database) writeTurtle(w io.Writer, which int, prefixes map[string]string) error {
+	bw := bufio.NewWriter(w)
+
+	ps := map[string]string{"": defaultNamespace}
+	for k, v := range prefixes {
+		ps[k] = v
+	}
+
+	names := make([]string, 0, len(ps))
+	for k, _ := range ps {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		bw.WriteString("@prefix " + k + ": <" + ps[k] + "> .\n")
+	}
+	bw.WriteString("\n")
+
+	as := make([]Atom, 0)
+	for _, rels := range d.exportRels(which) {
+		for _, relName := range sortedRelNames(rels) {
+			as = append(as, decodeRel(d.exportRel(rels, relName))...)
+		}
+	}
+
+	// stable, so that relations keep their order for equal subjects
+	sort.SliceStable(as, func(i, j int) bool {
+		return termKey(as[i].s) < termKey(as[j].s)
+	})
+
+	rdfType := iriToConstant(rdfNs + "type")
+
+	for i, a := range as {
+		if i == 0 || as[i-1].s != a.s {
+			if i > 0 {
+				bw.WriteString(" .\n")
+			}
+			bw.WriteString(compactIri(a.s, ps))
+			bw.WriteString("\n    ")
+		} else if as[i-1].p != a.p {
+			bw.WriteString(" ;\n    ")
+		} else {
+			bw.WriteString(" ,\n        ")
+			bw.WriteString(compactIri(a.o, ps))
+			continue
+		}
+		if a.p == rdfType {
+			bw.WriteString("a")
+		} else {
+			bw.WriteString(compactIri(a.p, ps))
+		}
+		bw.WriteString(" ")
+		bw.WriteString(compactIri(a.o, ps))
+	}
+
+	if len(as) > 0 {
+		bw.WriteString(" .\n")
+	}
+
+	return bw.Flush()
+}
+
+// jsonTriple holds a subject and an object, which is either a string
+// for constants or a jsonLiteral
+type jsonTriple struct {
+	S string      `json:"s"`
+	O interface{} `json:"o"`
+}
+
+type jsonLiteral struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+func termToJSON(t Term) interface{} {
+	switch l := t.(type) {
+	case Long:
+		return jsonLiteral{"long", int64(l)}
+	case Double:
+		return jsonLiteral{"double", float64(l)}
+	case Decimal:
+		return jsonLiteral{"decimal", string(l)}
+	case String:
+		return jsonLiteral{"string", string(l)}
+	case Array:
+		vs := make([]interface{}, 0, len(l))
+		for _, l_ := range l {
+			vs = append(vs, termToJSON(l_))
+		}
+		return jsonLiteral{"array", vs}
+	}
+	return termKey(t)
+}
+
+// writeJSON writes the selected relations of d to w as a json object
+// with the members "edb" and/or "idb", each mapping relation names to
+// the list of their triples.
+func (d *Database) writeJSON(w io.Writer, which int) error {
+	out := make(map[string]map[string][]jsonTriple)
+
+	toJSON := func(rels *map[Constant][]triple) map[string][]jsonTriple {
+		m := make(map[string][]jsonTriple)
+		for _, relName := range sortedRelNames(rels) {
+			rel := d.exportRel(rels, relName)
+			ts := make([]jsonTriple, 0, len(rel))
+			for _, a := range decodeRel(rel) {
+				ts = append(ts, jsonTriple{S: termKey(a.s), O: termToJSON(a.o)})
+			}
+			m[string(relName)] = ts
+		}
+		return m
+	}
+
+	if which&exportEdb != 0 {
+		out["edb"] = toJSON(&(*d).edb)
+	}
+	if which&exportIdb != 0 {
+		out["idb"] = toJSON(&(*d).idb)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+
+	_, db := mkDatabase()
+	prog := mkProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+
+	db.addAtom(Atom{Constant(":a"), Constant("<" + rdfNs + "type>"), Constant("<http://example.org/Node>"), false})
+	db.addAtom(Atom{Constant("_:b1"), Constant("<" + rdfsNs + "label>"), Constant(`"x \"y\""@en`), false})
+
+	var nt, ttl bytes.Buffer
+
+	if err := db.writeNTriples(&nt, exportAll); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.writeTurtle(&ttl, exportAll, map[string]string{"ex": "http://example.org/", "rdfs": rdfsNs}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(ttl.String(), "a ex:Node") || !strings.Contains(ttl.String(), "rdfs:label") {
+		t.Error("iris were not compacted", ttl.String())
+	}
+
+	for _, load := range []func() (Database, error){
+		func() (Database, error) {
+			db_ := newDatabase()
+			_, err := loadNTriples(&nt, &db_)
+			return db_, err
+		},
+		func() (Database, error) {
+			db_ := newDatabase()
+			_, err := loadTurtle(&ttl, &db_)
+			return db_, err
+		},
+	} {
+		db_, err := load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db_.size() != db.size() {
+			t.Error("wrong size after round trip", db_.size(), db.size())
+		}
+		for _, rels := range db.exportRels(exportAll) {
+			for _, rel := range *rels {
+				for _, a := range decodeRel(rel) {
+					if a.s == Constant("_:b1") {
+						continue
+					}
+					if !db_.knows(a) {
+						t.Error("lost atom in round trip", a)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+
+	_, db := mkDatabase()
+	prog := mkProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+
+	var buf bytes.Buffer
+	if err := db.writeJSON(&buf, exportIdb); err != nil {
+		t.Fatal(err)
+	}
+
+	var out map[string]map[string][]jsonTriple
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := out["edb"]; ok {
+		t.Error("edb should not be exported")
+	}
+
+	if len(out["idb"][":reachable"]) != len(db.idb[":reachable"]) {
+		t.Error("wrong number of :reachable triples", out["idb"])
+	}
+}
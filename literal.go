@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Literals map onto RDF literals as follows: Long is xsd:integer,
+// Double is xsd:double, Decimal is xsd:decimal and String is
+// xsd:string. Arrays have no RDF counterpart and are written as string
+// of their turtle like lexical form, e.g. [1, 2.5e0, "x"], typed with
+// arrayDatatype. Literals of any other datatype or with a language
+// tag, and integers out of the range of Long, are kept as Constant in
+// their N-Triples form.
+
+const arrayDatatype = defaultNamespace + "Array"
+
+// doubleLexical always contains a '.' or an exponent, so that doubles
+// can not be read back as longs
+func doubleLexical(d Double) string {
+	s := strconv.FormatFloat(float64(d), 'g', -1, 64)
+	switch s {
+	case "+Inf":
+		return "INF"
+	case "-Inf":
+		return "-INF"
+	case "NaN":
+		return s
+	}
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// literalLexical returns the lexical form and datatype iri of l
+func literalLexical(l Literal) (string, string) {
+	switch l_ := l.(type) {
+	case Long:
+		return strconv.FormatInt(int64(l_), 10), xsdNs + "integer"
+	case Double:
+		return doubleLexical(l_), xsdNs + "double"
+	case String:
+		return string(l_), xsdNs + "string"
+	case Array:
+		return arrayLexical(l_), arrayDatatype
+	case Decimal:
+		return string(l_), xsdNs + "decimal"
+	}
+	panic(fmt.Sprintf("unknown literal %v", l))
+}
+
+// literalShort renders l the way it is written in turtle object
+// position. Doubles get an exponent, without one turtle reads a
+// decimal.
+func literalShort(l Literal) string {
+	switch l_ := l.(type) {
+	case Long:
+		return strconv.FormatInt(int64(l_), 10)
+	case Decimal:
+		return string(l_)
+	case Double:
+		if d := doubleLexical(l_); !strings.ContainsAny(d, "IN") {
+			if !strings.ContainsRune(d, 'e') {
+				d += "e0"
+			}
+			return d
+		}
+	case String:
+		return ntLiteral(string(l_), "", "")
+	case Array:
+		return arrayLexical(l_)
+	}
+	return literalToNT(l)
+}
+
+func arrayLexical(a Array) string {
+	var sb strings.Builder
+	sb.WriteByte('[')
+	for i, l := range a {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(literalShort(l))
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
+
+// literalToNT renders l in N-Triples syntax
+func literalToNT(l Literal) string {
+	lex, datatype := literalLexical(l)
+	return ntLiteral(lex, "", datatype)
+}
+
+// newLiteral creates the term for a parsed RDF literal. The integer
+// types derived from xsd:integer become Long and xsd:float becomes
+// Double, their datatype is not kept: they are exported as xsd:integer
+// and xsd:double. Integers too large for a Long and literals of other
+// datatypes are kept as opaque constants.
+func newLiteral(lex, lang, datatype string) (Term, error) {
+
+	if lang != "" {
+		return Constant(ntLiteral(lex, lang, "")), nil
+	}
+
+	switch strings.TrimPrefix(datatype, xsdNs) {
+	case "", "string":
+		return String(lex), nil
+	case "integer", "long", "int", "short", "byte", "nonNegativeInteger",
+		"positiveInteger", "nonPositiveInteger", "negativeInteger":
+		i, err := strconv.ParseInt(strings.TrimPrefix(lex, "+"), 10, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			// a valid integer, but too large for a Long
+			return Constant(ntLiteral(lex, "", datatype)), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed integer %q", lex)
+		}
+		return Long(i), nil
+	case "decimal":
+		return parseDecimal(lex)
+	case "double", "float":
+		d, err := strconv.ParseFloat(lex, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed double %q", lex)
+		}
+		return Double(d), nil
+	}
+
+	if datatype == arrayDatatype {
+		return parseArrayLexical(lex)
+	}
+
+	return Constant(ntLiteral(lex, "", datatype)), nil
+}
+
+// parseArrayLexical parses the lexical form of an array
+func parseArrayLexical(lex string) (Array, error) {
+	p := newTtlParser(strings.NewReader(lex), nil, false)
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	a, err := p.parseArray()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != ttlEOF {
+		return nil, p.errorf(p.tok, "trailing input after array")
+	}
+	return a, nil
+}
+
+// parseNumber creates a Long or a Double from a numeric token
+func parseNumber(s string) (Literal, bool) {
+	if i, err := strconv.ParseInt(strings.TrimPrefix(s, "+"), 10, 64); err == nil {
+		return Long(i), true
+	}
+	if d, err := strconv.ParseFloat(s, 64); err == nil {
+		return Double(d), true
+	}
+	return nil, false
+}
+
+// parseDecimal returns the canonical form of a decimal: without a
+// sign if positive, without leading zeros in the integer part and
+// without trailing zeros in the fraction, but at least one digit on
+// either side of the dot, e.g. "+01.50" is 1.5 and "-.0" is 0.0
+func parseDecimal(lex string) (Decimal, error) {
+	s, sign := lex, ""
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		if s[0] == '-' {
+			sign = "-"
+		}
+		s = s[1:]
+	}
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i+1:]
+	}
+	if intPart == "" && frac == "" || !isDigits(intPart) || !isDigits(frac) {
+		return "", fmt.Errorf("malformed decimal %q", lex)
+	}
+	if intPart = strings.TrimLeft(intPart, "0"); intPart == "" {
+		intPart = "0"
+	}
+	if frac = strings.TrimRight(frac, "0"); frac == "" {
+		frac = "0"
+	}
+	if intPart == "0" && frac == "0" {
+		sign = ""
+	}
+	return Decimal(sign + intPart + "." + frac), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// toRat returns the exact value of longs and decimals
+func toRat(t Term) (*big.Rat, bool) {
+	switch n := t.(type) {
+	case Long:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case Decimal:
+		return new(big.Rat).SetString(string(n))
+	}
+	return nil, false
+}
+
+// ratToDecimal returns r as decimal, ok is false if r has no finite
+// decimal expansion
+func ratToDecimal(r *big.Rat) (Decimal, bool) {
+	// the number of fraction digits is the larger exponent of 2 and
+	// 5 in the denominator, which must not have other factors
+	d := new(big.Int).Set(r.Denom())
+	digits := 0
+	for _, f := range []int64{2, 5} {
+		n := 0
+		m := new(big.Int)
+		for {
+			q, rem := new(big.Int).QuoRem(d, big.NewInt(f), m)
+			if rem.Sign() != 0 {
+				break
+			}
+			d = q
+			n++
+		}
+		digits = max(digits, n)
+	}
+	if d.Cmp(big.NewInt(1)) != 0 {
+		return "", false
+	}
+	dec, err := parseDecimal(r.FloatString(digits))
+	return dec, err == nil
+}
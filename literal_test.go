@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLiteralEquality(t *testing.T) {
+
+	a1 := Array{Long(1), String("x"), Array{Double(2.5)}}
+	a2 := Array{Long(1), String("x"), Array{Double(2.5)}}
+	a3 := Array{Long(1), String("x")}
+
+	if !termEqual(a1, a2) || termEqual(a1, a3) || termEqual(a1, Long(1)) {
+		t.Error("wrong array equality")
+	}
+
+	if termEqual(Long(1), Double(1)) || termEqual(String(":a"), Constant(":a")) {
+		t.Error("terms of different types should not be equal")
+	}
+
+	mu1 := Mu{Variable("?x"): a1}
+	mu2 := Mu{Variable("?x"): a2}
+	mu3 := Mu{Variable("?x"): a3}
+
+	if !mu1.compatible(&mu2) || mu1.compatible(&mu3) {
+		t.Error("wrong compatibility for array bindings")
+	}
+
+	g1 := Atom{Constant(":a"), Constant(":tags"), a1, false}
+	g2 := Atom{Constant(":a"), Constant(":tags"), a2, false}
+	bgp := Atom{Variable("?x"), Constant(":tags"), a2, false}
+
+	if !g1.equalTo(&g2) || !bgp.matches(&g1) {
+		t.Error("atoms with equal arrays should be equal and match")
+	}
+}
+
+func TestLiteralsInDatabase(t *testing.T) {
+
+	db := newDatabase()
+
+	prog, err := parseProgramString(`
+		:tom :age 17.
+		:ann :age 42.
+		:ann :weight 61.5.
+		:ann :name "Ann \"A\"".
+		:ann :tags [1, "x", [2.5]].
+		?x :hasAge ?y :- ?x :age ?y.
+	`, &db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prog.evalSeminaive(&db)
+
+	if !db.knows(Atom{Constant(":ann"), Constant(":hasAge"), Long(42), false}) {
+		t.Error("literal was not propagated")
+	}
+
+	omega := db.findMappingsFor(&Atom{Variable("?x"), Constant(":age"), Long(17), false})
+	if len(omega) != 1 || omega[0]["?x"] != Constant(":tom") {
+		t.Error("wrong mappings for literal object", omega)
+	}
+
+	if a := newAtom("?x", ":age", "42"); a.o != Long(42) {
+		t.Error("newAtom should create a Long", a.o)
+	}
+
+	for _, write := range []func(*bytes.Buffer) error{
+		func(b *bytes.Buffer) error { return db.writeNTriples(b, exportEdb) },
+		func(b *bytes.Buffer) error { return db.writeTurtle(b, exportEdb, nil) },
+	} {
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		db_ := newDatabase()
+		if _, err := loadTurtle(strings.NewReader(buf.String()), &db_); err != nil {
+			t.Fatal(err, buf.String())
+		}
+
+		for _, a := range decodeRel(db.rel(":tags")) {
+			if !db_.knows(a) {
+				t.Error("array did not survive the round trip", buf.String())
+			}
+		}
+
+		if !db_.equalTo(&db) {
+			t.Error("literals did not survive the round trip", buf.String())
+		}
+	}
+}
+
+func TestParseLiteralErrors(t *testing.T) {
+	db := newDatabase()
+	_, err := parseProgramString(`"x" :p :o.`, &db)
+	if pe, ok := err.(*parseError); !ok || pe.col != 1 {
+		t.Error("literal subjects should be rejected", err)
+	}
+}
+
+func TestDecimalsAndLargeIntegers(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		@prefix xsd: <http://www.w3.org/2001/XMLSchema#> .
+		:a :big 123456789012345678901234567890 ;
+		   :dec 0.1, "+01.50"^^xsd:decimal ;
+		   :dbl 0.1e0 .
+	`)
+
+	for _, a := range []Atom{
+		{Constant(":a"), Constant(":big"), Constant(`"123456789012345678901234567890"^^<` + xsdNs + `integer>`), false},
+		{Constant(":a"), Constant(":dec"), Decimal("0.1"), false},
+		{Constant(":a"), Constant(":dec"), Decimal("1.5"), false},
+		{Constant(":a"), Constant(":dbl"), Double(0.1), false},
+	} {
+		if !db.knows(a) {
+			t.Error("missing triple", a)
+		}
+	}
+
+	for _, write := range []func(*bytes.Buffer) error{
+		func(b *bytes.Buffer) error { return db.writeNTriples(b, exportEdb) },
+		func(b *bytes.Buffer) error { return db.writeTurtle(b, exportEdb, nil) },
+	} {
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		db_ := newDatabase()
+		if _, err := loadTurtle(strings.NewReader(buf.String()), &db_); err != nil {
+			t.Fatal(err, buf.String())
+		}
+		if !db_.equalTo(&db) {
+			t.Error("literals did not survive the round trip", buf.String())
+		}
+	}
+
+	for lex, expected := range map[string]Decimal{
+		"1": "1.0", "-0.0": "0.0", ".5": "0.5", "+007.2500": "7.25", "-3.": "-3.0",
+	} {
+		if d, err := parseDecimal(lex); err != nil || d != expected {
+			t.Errorf("%s parsed as %s, expected %s", lex, d, expected)
+		}
+	}
+	for _, lex := range []string{"", ".", "1e3", "--1", "1.2.3"} {
+		if _, err := parseDecimal(lex); err == nil {
+			t.Error("malformed decimal accepted", lex)
+		}
+	}
+
+	if sum, _ := arith("+", Decimal("0.1"), Decimal("0.2")); sum != Decimal("0.3") {
+		t.Error("decimal sum is not exact", sum)
+	}
+	if q, _ := arith("/", Decimal("1.0"), Long(8)); q != Decimal("0.125") {
+		t.Error("wrong decimal quotient", q)
+	}
+	if q, _ := arith("/", Long(1), Decimal("3.0")); !isLiteral(q) || isDecimal(q) {
+		t.Error("1/3 has no finite decimal expansion", q)
+	}
+	if !valueEqual(Decimal("2.0"), Long(2)) || !valueEqual(Decimal("0.5"), Double(0.5)) {
+		t.Error("decimals should equal numbers of the same value")
+	}
+	if c, ok := compareTerms(Decimal("0.3"), Decimal("0.25")); !ok || c <= 0 {
+		t.Error("wrong decimal order")
+	}
+}
+
+func TestLossyNumericDatatypes(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		@prefix xsd: <http://www.w3.org/2001/XMLSchema#> .
+		:a :int "7"^^xsd:int ;
+		   :short "-3"^^xsd:short ;
+		   :float "0.5"^^xsd:float .
+	`)
+
+	for _, a := range []Atom{
+		{Constant(":a"), Constant(":int"), Long(7), false},
+		{Constant(":a"), Constant(":short"), Long(-3), false},
+		{Constant(":a"), Constant(":float"), Double(0.5), false},
+	} {
+		if !db.knows(a) {
+			t.Error("missing triple", a)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := db.writeNTriples(&buf, exportEdb); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "#int>") || strings.Contains(out, "#short>") || strings.Contains(out, "#float>") ||
+		!strings.Contains(out, "#integer>") || !strings.Contains(out, "#double>") {
+		t.Error("numeric datatypes should be exported as xsd:integer and xsd:double", out)
+	}
+}
@@ -0,0 +1,859 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// Iris in defaultNamespace are mapped onto the ':'-prefixed
+// constants used throughout contki, every other iri is kept as
+// Constant("<iri>"). Blank nodes become Constant("_:label") and
+// literals are mapped by newLiteral.
+const defaultNamespace = "urn:contki:"
+
+// Iris in helperNamespace name relations contki derives for its own
+// rules, e.g. owlRlRules, they are not exported, see sortedRelNames
+const helperNamespace = "urn:x-contki-helper:"
+
+const (
+	xsdNs  = "http://www.w3.org/2001/XMLSchema#"
+	rdfNs  = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	rdfsNs = "http://www.w3.org/2000/01/rdf-schema#"
+)
+
+func iriToConstant(iri string) Constant {
+	if strings.HasPrefix(iri, defaultNamespace) {
+		local := iri[len(defaultNamespace):]
+		if isLocalName(local) {
+			return Constant(":" + local)
+		}
+	}
+	return Constant("<" + iri + ">")
+}
+
+func isLocalName(s string) bool {
+	if s == "" || s[0] == '-' || s[0] == '.' || s[len(s)-1] == '.' {
+		return false
+	}
+	for _, c := range s {
+		if !isNameRune(c) && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// ntLiteral renders a literal in N-Triples syntax
+func ntLiteral(lex, lang, datatype string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range lex {
+		switch c {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	sb.WriteByte('"')
+	if lang != "" {
+		sb.WriteString("@" + lang)
+	} else if datatype != "" && datatype != xsdNs+"string" {
+		sb.WriteString("^^<" + datatype + ">")
+	}
+	return sb.String()
+}
+
+// Turtle lexer {{{
+
+const (
+	ttlEOF = iota
+	ttlIRI
+	ttlPName
+	ttlBlank
+	ttlString
+	ttlLang
+	ttlDType
+	ttlInteger
+	ttlDecimal
+	ttlDouble
+	ttlBoolean
+	ttlA
+	ttlPunct
+	ttlPrefix
+	ttlBase
+	ttlSparqlPrefix
+	ttlSparqlBase
+)
+
+type ttlLexer struct {
+	lexer
+}
+
+func isPNameRune(c rune) bool {
+	return isNameRune(c) || c == ':' || c == '%' || c == '\\'
+}
+
+// readPName reads a prefixed name, a trailing dot is left for the
+// statement terminator
+func (l *ttlLexer) readPName(sb *strings.Builder) error {
+	for {
+		c, ok := l.peek()
+		if !ok {
+			return nil
+		}
+		if c == '\\' {
+			l.read()
+			c_, ok := l.read()
+			if !ok {
+				return l.errorf(l.line, l.col, "unterminated escape")
+			}
+			sb.WriteRune(c_)
+			continue
+		}
+		if isPNameRune(c) {
+			l.read()
+			sb.WriteRune(c)
+			continue
+		}
+		if c == '.' {
+			l.read()
+			c_, ok := l.peek()
+			if ok && isPNameRune(c_) {
+				sb.WriteRune('.')
+				continue
+			}
+			l.unread('.')
+		}
+		return nil
+	}
+}
+
+func (l *lexer) readUnicodeEscape(n int) (rune, error) {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		c, ok := l.read()
+		if !ok {
+			return 0, l.errorf(l.line, l.col, "unterminated unicode escape")
+		}
+		sb.WriteRune(c)
+	}
+	v, err := strconv.ParseUint(sb.String(), 16, 32)
+	if err != nil {
+		return 0, l.errorf(l.line, l.col, "malformed unicode escape %q", sb.String())
+	}
+	return rune(v), nil
+}
+
+func (l *ttlLexer) readIRI(line, col int) (string, error) {
+	var sb strings.Builder
+	for {
+		c, ok := l.read()
+		if !ok || c == '\n' {
+			return "", l.errorf(line, col, "unterminated iri")
+		}
+		if c == '>' {
+			return sb.String(), nil
+		}
+		if c == ' ' || c == '<' || c == '"' {
+			return "", l.errorf(l.line, l.col, "illegal character %q in iri", c)
+		}
+		if c == '\\' {
+			e, _ := l.read()
+			var err error
+			switch e {
+			case 'u':
+				c, err = l.readUnicodeEscape(4)
+			case 'U':
+				c, err = l.readUnicodeEscape(8)
+			default:
+				err = l.errorf(l.line, l.col, "illegal escape in iri")
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+		sb.WriteRune(c)
+	}
+}
+
+func (l *lexer) readString(quote rune, line, col int) (string, error) {
+
+	long := false
+	if c, ok := l.peek(); ok && c == quote {
+		l.read()
+		if c, ok := l.peek(); ok && c == quote {
+			l.read()
+			long = true
+		} else {
+			// empty string
+			return "", nil
+		}
+	}
+
+	var sb strings.Builder
+	for {
+		c, ok := l.read()
+		if !ok {
+			return "", l.errorf(line, col, "unterminated string")
+		}
+		if c == '\n' && !long {
+			return "", l.errorf(line, col, "unterminated string")
+		}
+		if c == quote {
+			if !long {
+				return sb.String(), nil
+			}
+			n := 1
+			for n < 3 {
+				c_, ok := l.peek()
+				if !ok || c_ != quote {
+					break
+				}
+				l.read()
+				n++
+			}
+			if n == 3 {
+				return sb.String(), nil
+			}
+			for i := 0; i < n; i++ {
+				sb.WriteRune(quote)
+			}
+			continue
+		}
+		if c == '\\' {
+			e, _ := l.read()
+			var err error
+			switch e {
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 'f':
+				c = '\f'
+			case '"', '\'', '\\':
+				c = e
+			case 'u':
+				c, err = l.readUnicodeEscape(4)
+			case 'U':
+				c, err = l.readUnicodeEscape(8)
+			default:
+				err = l.errorf(l.line, l.col, "illegal escape \\%c", e)
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+		sb.WriteRune(c)
+	}
+}
+
+func (l *lexer) readNumber(first rune) (int, string) {
+	var sb strings.Builder
+	sb.WriteRune(first)
+	kind := ttlInteger
+
+	digits := func() {
+		for {
+			c, ok := l.peek()
+			if !ok || !unicode.IsDigit(c) {
+				return
+			}
+			l.read()
+			sb.WriteRune(c)
+		}
+	}
+
+	digits()
+	if c, ok := l.peek(); ok && c == '.' {
+		l.read()
+		if c_, ok := l.peek(); ok && unicode.IsDigit(c_) {
+			sb.WriteRune('.')
+			kind = ttlDecimal
+			digits()
+		} else {
+			l.unread('.')
+		}
+	}
+	if c, ok := l.peek(); ok && (c == 'e' || c == 'E') {
+		l.read()
+		sb.WriteRune(c)
+		kind = ttlDouble
+		if c, ok := l.peek(); ok && (c == '+' || c == '-') {
+			l.read()
+			sb.WriteRune(c)
+		}
+		digits()
+	}
+	return kind, sb.String()
+}
+
+// skipSpace skips whitespace and comments
+func (l *ttlLexer) skipSpace() {
+	for {
+		c, ok := l.peek()
+		if !ok {
+			return
+		}
+		if unicode.IsSpace(c) {
+			l.read()
+			continue
+		}
+		if c == '#' {
+			for {
+				c, ok := l.read()
+				if !ok || c == '\n' {
+					break
+				}
+			}
+			continue
+		}
+		return
+	}
+}
+
+func (l *ttlLexer) next() (token, error) {
+
+	l.skipSpace()
+	c, ok := l.read()
+	if !ok {
+		return token{kind: ttlEOF, line: l.line, col: l.col + 1}, nil
+	}
+	tok := token{line: l.line, col: l.col}
+
+	switch {
+	case strings.ContainsRune(".;,[]()", c):
+		tok.kind = ttlPunct
+		tok.text = string(c)
+	case c == '<':
+		iri, err := l.readIRI(tok.line, tok.col)
+		if err != nil {
+			return tok, err
+		}
+		tok.kind = ttlIRI
+		tok.text = iri
+	case c == '"' || c == '\'':
+		s, err := l.readString(c, tok.line, tok.col)
+		if err != nil {
+			return tok, err
+		}
+		tok.kind = ttlString
+		tok.text = s
+	case c == '^':
+		c_, ok := l.read()
+		if !ok || c_ != '^' {
+			return tok, l.errorf(tok.line, tok.col, "expected '^^'")
+		}
+		tok.kind = ttlDType
+		tok.text = "^^"
+	case c == '@':
+		var sb strings.Builder
+		for {
+			c, ok := l.peek()
+			if !ok || !(unicode.IsLetter(c) || unicode.IsDigit(c) || c == '-') {
+				break
+			}
+			l.read()
+			sb.WriteRune(c)
+		}
+		switch sb.String() {
+		case "prefix":
+			tok.kind = ttlPrefix
+		case "base":
+			tok.kind = ttlBase
+		case "":
+			return tok, l.errorf(tok.line, tok.col, "empty language tag")
+		default:
+			tok.kind = ttlLang
+		}
+		tok.text = sb.String()
+	case unicode.IsDigit(c) || c == '+' || c == '-':
+		tok.kind, tok.text = l.readNumber(c)
+		if tok.text == "+" || tok.text == "-" {
+			return tok, l.errorf(tok.line, tok.col, "malformed number")
+		}
+	case c == '_':
+		c_, ok := l.read()
+		if !ok || c_ != ':' {
+			return tok, l.errorf(tok.line, tok.col, "malformed blank node label")
+		}
+		var sb strings.Builder
+		sb.WriteString("_:")
+		if err := l.readPName(&sb); err != nil {
+			return tok, err
+		}
+		tok.kind = ttlBlank
+		tok.text = sb.String()
+	case isNameRune(c) || c == ':':
+		var sb strings.Builder
+		sb.WriteRune(c)
+		if err := l.readPName(&sb); err != nil {
+			return tok, err
+		}
+		s := sb.String()
+		tok.text = s
+		switch {
+		case strings.ContainsRune(s, ':'):
+			tok.kind = ttlPName
+		case s == "a":
+			tok.kind = ttlA
+		case s == "true" || s == "false":
+			tok.kind = ttlBoolean
+		case strings.EqualFold(s, "PREFIX"):
+			tok.kind = ttlSparqlPrefix
+		case strings.EqualFold(s, "BASE"):
+			tok.kind = ttlSparqlBase
+		default:
+			return tok, l.errorf(tok.line, tok.col, "unexpected name %q", s)
+		}
+	default:
+		return tok, l.errorf(tok.line, tok.col, "unexpected character %q", c)
+	}
+
+	return tok, nil
+}
+
+// }}}
+
+// Turtle / N-Triples parser {{{
+
+// tokenSource yields the tokens of a ttlParser, the SPARQL lexer
+// extends the turtle lexer
+type tokenSource interface {
+	next() (token, error)
+}
+
+type ttlParser struct {
+	lex      tokenSource
+	tok      token
+	prefixes map[string]string
+	base     string
+	ntriples bool
+	db       *Database
+	count    int
+	// blank node labels are scoped per document
+	bnodes map[string]Constant
+}
+
+// blankCount is shared by all loaders so that anonymous and relabeled
+// blank nodes of different documents, which may end up in the same
+// database, never collide. It is incremented atomically, loaders may
+// run concurrently.
+var blankCount uint64
+
+func freshBlank() Constant {
+	n := atomic.AddUint64(&blankCount, 1)
+	return Constant("_:genid" + strconv.FormatUint(n, 10))
+}
+
+func (p *ttlParser) advance() error {
+	t, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = t
+	return nil
+}
+
+func (p *ttlParser) errorf(t token, format string, args ...interface{}) error {
+	return &parseError{line: t.line, col: t.col, msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *ttlParser) isPunct(s string) bool {
+	return p.tok.kind == ttlPunct && p.tok.text == s
+}
+
+func (p *ttlParser) expectPunct(s string) error {
+	if !p.isPunct(s) {
+		return p.errorf(p.tok, "expected '%s', found %s", s, describeToken(p.tok))
+	}
+	return p.advance()
+}
+
+func (p *ttlParser) resolve(iri string) string {
+	if p.base != "" && !strings.Contains(iri, ":") {
+		return p.base + iri
+	}
+	return iri
+}
+
+func (p *ttlParser) blank(label string) Constant {
+	c, ok := p.bnodes[label]
+	if !ok {
+		c = freshBlank()
+		p.bnodes[label] = c
+	}
+	return c
+}
+
+func (p *ttlParser) emit(t token, s, pr, o Term) error {
+	// facts of idb relations are added as base facts, repeated
+	// triples only once, graphs are sets
+	rel := pr.(Constant)
+	p.db.registerEdbRel(rel)
+	a := Atom{s: s, p: pr, o: o}
+	if tr, ok := lookupAtom(&a); !ok || !relKnows(p.db.edbIndexes[rel], tr) {
+		p.db.addAtom(a)
+	}
+	p.count++
+	return nil
+}
+
+func (p *ttlParser) pname(t token) (Term, error) {
+	if p.ntriples {
+		return nil, p.errorf(t, "prefixed names are not allowed in N-Triples")
+	}
+	i := strings.IndexRune(t.text, ':')
+	iri, ok := p.prefixes[t.text[:i]]
+	if !ok {
+		return nil, p.errorf(t, "undeclared prefix %q", t.text[:i])
+	}
+	return iriToConstant(iri + t.text[i+1:]), nil
+}
+
+func (p *ttlParser) parseIRI() (Term, error) {
+	t := p.tok
+	var c Term
+	var err error
+	switch t.kind {
+	case ttlIRI:
+		c = iriToConstant(p.resolve(t.text))
+	case ttlPName:
+		c, err = p.pname(t)
+	case ttlA:
+		if p.ntriples {
+			return nil, p.errorf(t, "'a' is not allowed in N-Triples")
+		}
+		c = iriToConstant(rdfNs + "type")
+	default:
+		return nil, p.errorf(t, "expected iri, found %s", describeToken(t))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, p.advance()
+}
+
+func (p *ttlParser) parseLiteral() (Term, error) {
+	t := p.tok
+	if p.ntriples && t.kind != ttlString {
+		return nil, p.errorf(t, "only quoted literals are allowed in N-Triples")
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	lex, lang, datatype := t.text, "", ""
+
+	switch t.kind {
+	case ttlInteger:
+		datatype = xsdNs + "integer"
+	case ttlDecimal:
+		datatype = xsdNs + "decimal"
+	case ttlDouble:
+		datatype = xsdNs + "double"
+	case ttlBoolean:
+		datatype = xsdNs + "boolean"
+	default:
+		switch p.tok.kind {
+		case ttlLang:
+			lang = p.tok.text
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		case ttlDType:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			dt, err := p.parseIRI()
+			if err != nil {
+				return nil, err
+			}
+			datatype = termToNT(dt)
+			datatype = datatype[1 : len(datatype)-1]
+		}
+	}
+
+	l, err := newLiteral(lex, lang, datatype)
+	if err != nil {
+		return nil, p.errorf(t, "%s", err)
+	}
+	return l, nil
+}
+
+// parseArray parses the lexical form of an Array
+func (p *ttlParser) parseArray() (Array, error) {
+	if err := p.expectPunct("["); err != nil {
+		return nil, err
+	}
+	a := make(Array, 0)
+	for !p.isPunct("]") {
+		if len(a) > 0 {
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+		}
+		if p.isPunct("[") {
+			a_, err := p.parseArray()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, a_)
+			continue
+		}
+		switch p.tok.kind {
+		case ttlString, ttlInteger, ttlDecimal, ttlDouble:
+		default:
+			return nil, p.errorf(p.tok, "expected literal, found %s", describeToken(p.tok))
+		}
+		t := p.tok
+		l, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		l_, ok := l.(Literal)
+		if !ok {
+			return nil, p.errorf(t, "unsupported literal %s in array", l)
+		}
+		a = append(a, l_)
+	}
+	return a, p.advance()
+}
+
+func (p *ttlParser) parseBlankPropertyList() (Term, error) {
+	if p.ntriples {
+		return nil, p.errorf(p.tok, "anonymous blank nodes are not allowed in N-Triples")
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	b := freshBlank()
+	if !p.isPunct("]") {
+		if err := p.parsePredicateObjectList(b); err != nil {
+			return nil, err
+		}
+	}
+	return b, p.expectPunct("]")
+}
+
+func (p *ttlParser) parseCollection() (Term, error) {
+	if p.ntriples {
+		return nil, p.errorf(p.tok, "collections are not allowed in N-Triples")
+	}
+	t := p.tok
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	first := iriToConstant(rdfNs + "first")
+	rest := iriToConstant(rdfNs + "rest")
+	var head, prev Term = iriToConstant(rdfNs + "nil"), nil
+
+	for !p.isPunct(")") {
+		if p.tok.kind == ttlEOF {
+			return nil, p.errorf(t, "unterminated collection")
+		}
+		o, err := p.parseObject()
+		if err != nil {
+			return nil, err
+		}
+		node := freshBlank()
+		if prev == nil {
+			head = node
+		} else if err := p.emit(t, prev, rest, node); err != nil {
+			return nil, err
+		}
+		if err := p.emit(t, node, first, o); err != nil {
+			return nil, err
+		}
+		prev = node
+	}
+
+	if prev != nil {
+		if err := p.emit(t, prev, rest, iriToConstant(rdfNs+"nil")); err != nil {
+			return nil, err
+		}
+	}
+
+	return head, p.advance()
+}
+
+func (p *ttlParser) parseSubject() (Term, error) {
+	switch {
+	case p.tok.kind == ttlBlank:
+		b := p.blank(p.tok.text)
+		return b, p.advance()
+	case p.isPunct("["):
+		return p.parseBlankPropertyList()
+	case p.isPunct("("):
+		return p.parseCollection()
+	case p.tok.kind == ttlA:
+		return nil, p.errorf(p.tok, "'a' is only allowed in predicate position")
+	}
+	return p.parseIRI()
+}
+
+func (p *ttlParser) parseObject() (Term, error) {
+	switch p.tok.kind {
+	case ttlString, ttlInteger, ttlDecimal, ttlDouble, ttlBoolean:
+		return p.parseLiteral()
+	}
+	return p.parseSubject()
+}
+
+func (p *ttlParser) parsePredicateObjectList(s Term) error {
+	for {
+		t := p.tok
+		pr, err := p.parseIRI()
+		if err != nil {
+			return err
+		}
+		for {
+			o, err := p.parseObject()
+			if err != nil {
+				return err
+			}
+			if err := p.emit(t, s, pr, o); err != nil {
+				return err
+			}
+			if !p.isPunct(",") || p.ntriples {
+				break
+			}
+			if err := p.advance(); err != nil {
+				return err
+			}
+		}
+		if !p.isPunct(";") || p.ntriples {
+			return nil
+		}
+		for p.isPunct(";") {
+			if err := p.advance(); err != nil {
+				return err
+			}
+		}
+		if p.isPunct(".") || p.isPunct("]") {
+			return nil
+		}
+	}
+}
+
+func (p *ttlParser) parseDirective() error {
+	t := p.tok
+	if p.ntriples {
+		return p.errorf(t, "directives are not allowed in N-Triples")
+	}
+	if err := p.advance(); err != nil {
+		return err
+	}
+
+	switch t.kind {
+	case ttlPrefix, ttlSparqlPrefix:
+		if p.tok.kind != ttlPName || !strings.HasSuffix(p.tok.text, ":") {
+			return p.errorf(p.tok, "expected prefix name, found %s", describeToken(p.tok))
+		}
+		prefix := strings.TrimSuffix(p.tok.text, ":")
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if p.tok.kind != ttlIRI {
+			return p.errorf(p.tok, "expected iri, found %s", describeToken(p.tok))
+		}
+		p.prefixes[prefix] = p.resolve(p.tok.text)
+	default:
+		if p.tok.kind != ttlIRI {
+			return p.errorf(p.tok, "expected iri, found %s", describeToken(p.tok))
+		}
+		p.base = p.resolve(p.tok.text)
+	}
+
+	if err := p.advance(); err != nil {
+		return err
+	}
+
+	if t.kind == ttlPrefix || t.kind == ttlBase {
+		return p.expectPunct(".")
+	}
+	return nil
+}
+
+func (p *ttlParser) parse() error {
+
+	if err := p.advance(); err != nil {
+		return err
+	}
+
+	for p.tok.kind != ttlEOF {
+
+		switch p.tok.kind {
+		case ttlPrefix, ttlBase, ttlSparqlPrefix, ttlSparqlBase:
+			if err := p.parseDirective(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		blankList := p.isPunct("[")
+		s, err := p.parseSubject()
+		if err != nil {
+			return err
+		}
+
+		// a lone '[ ... ] .' is a valid statement
+		if !(blankList && p.isPunct(".")) {
+			if err := p.parsePredicateObjectList(s); err != nil {
+				return err
+			}
+		}
+
+		if err := p.expectPunct("."); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newTtlParser(r io.Reader, db *Database, ntriples bool) *ttlParser {
+	return &ttlParser{
+		lex:      &ttlLexer{*newLexer(r)},
+		prefixes: map[string]string{"": defaultNamespace},
+		ntriples: ntriples,
+		db:       db,
+		bnodes:   make(map[string]Constant),
+	}
+}
+
+// loadTurtle streams the Turtle document in r into the EDB relations
+// of db, keyed by predicate. It returns the number of triples read;
+// triples before a syntax error stay in the database.
+func loadTurtle(r io.Reader, db *Database) (int, error) {
+	p := newTtlParser(r, db, false)
+	err := p.parse()
+	return p.count, err
+}
+
+// loadNTriples streams the N-Triples document in r into the EDB
+// relations of db, see loadTurtle.
+func loadNTriples(r io.Reader, db *Database) (int, error) {
+	p := newTtlParser(r, db, true)
+	err := p.parse()
+	return p.count, err
+}
+
+// }}}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLoadNTriples(t *testing.T) {
+
+	db := newDatabase()
+
+	n, err := loadNTriples(strings.NewReader(`
+<urn:contki:a> <urn:contki:link> <urn:contki:b> .
+<http://example.org/b> <urn:contki:link> <urn:contki:c> . # comment
+_:x <urn:contki:label> "B \"quoted\""@en .
+<urn:contki:a> <urn:contki:age> "42"^^<http://www.w3.org/2001/XMLSchema#integer> .
+`), &db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 4 {
+		t.Error("wrong number of triples", n)
+	}
+
+	if !db.knows(newAtom(":a", ":link", ":b")) {
+		t.Error("iris in the default namespace should map onto ':' constants")
+	}
+
+	if !db.knows(Atom{Constant("<http://example.org/b>"), Constant(":link"), Constant(":c"), false}) {
+		t.Error("foreign iri was not loaded")
+	}
+
+	if !db.isEdbRelation(":label") || !db.isEdbRelation(":age") {
+		t.Error("relations were not registered")
+	}
+
+	if !db.knows(Atom{Constant(":a"), Constant(":age"), Long(42), false}) {
+		t.Error("typed literal was not loaded as Long")
+	}
+
+	if _, err := loadNTriples(strings.NewReader("<urn:contki:a> :link <urn:contki:b> ."), &db); err == nil {
+		t.Error("prefixed names must be rejected in N-Triples")
+	}
+}
+
+func TestLoadTurtle(t *testing.T) {
+
+	db := newDatabase()
+
+	n, err := loadTurtle(strings.NewReader(`
+@prefix ex: <http://example.org/> .
+PREFIX rdfs: <http://www.w3.org/2000/01/rdf-schema#>
+
+:a :link :b , :c ;
+   a ex:Node ;
+   rdfs:label "A", 'a'@de .
+:c :link [ :link :d ] .
+ex:list ex:members ( :a :b ) .
+:d :age 7; :weight 7.5 .
+`), &db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 14 {
+		t.Error("wrong number of triples", n)
+	}
+
+	for _, a := range []Atom{
+		newAtom(":a", ":link", ":b"),
+		newAtom(":a", ":link", ":c"),
+		{Constant(":a"), Constant("<" + rdfNs + "type>"), Constant("<http://example.org/Node>"), false},
+		{Constant(":a"), Constant("<" + rdfsNs + "label>"), String("A"), false},
+		{Constant(":a"), Constant("<" + rdfsNs + "label>"), Constant(`"a"@de`), false},
+		{Constant(":d"), Constant(":age"), Long(7), false},
+		{Constant(":d"), Constant(":weight"), Decimal("7.5"), false},
+	} {
+		if !db.knows(a) {
+			t.Error("missing triple", a)
+		}
+	}
+
+	// the blank node links :c to :d
+	omega := db.findMappingsFor(&Atom{Variable("?b"), Constant(":link"), Constant(":d"), false})
+	if len(omega) != 1 || !db.knows(Atom{Constant(":c"), Constant(":link"), omega[0]["?b"], false}) {
+		t.Error("blank node property list was not loaded", omega)
+	}
+
+	if len(db.edb[Constant("<"+rdfNs+"first>")]) != 2 || len(db.edb[Constant("<"+rdfNs+"rest>")]) != 2 {
+		t.Error("collection was not loaded")
+	}
+
+	_, err = loadTurtle(strings.NewReader(":a :link :b .\n:a :link ex:b ."), &db)
+	pe, ok := err.(*parseError)
+	if !ok || pe.line != 2 || pe.col != 10 {
+		t.Error("expected error at 2:10", err)
+	}
+}
+
+func TestLoadRepeatedTriples(t *testing.T) {
+
+	db := newDatabase()
+	n, err := loadTurtle(strings.NewReader(":a :link :b , :c .\n:a :link :b ."), &db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadNTriples(strings.NewReader("<urn:contki:a> <urn:contki:link> <urn:contki:c> .\n"), &db); err != nil {
+		t.Fatal(err)
+	}
+
+	// graphs are sets, repeated triples are read but stored once
+	if n != 3 || len(db.edb[Constant(":link")]) != 2 {
+		t.Errorf("%d triples read, %d stored", n, len(db.edb[Constant(":link")]))
+	}
+	var sb strings.Builder
+	if err := db.writeNTriples(&sb, exportAll); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(sb.String(), "\n"); lines != 2 {
+		t.Errorf("%d triples written:\n%s", lines, sb.String())
+	}
+}
+
+func TestFreshBlankConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	blanks := make([][]Constant, 8)
+	for i := range blanks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				blanks[i] = append(blanks[i], freshBlank())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[Constant]bool)
+	for _, bs := range blanks {
+		for _, b := range bs {
+			if seen[b] {
+				t.Fatal("blank node generated twice", b)
+			}
+			seen[b] = true
+		}
+	}
+}
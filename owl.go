@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const owlNs = "http://www.w3.org/2002/07/owl#"
+
+// owlRlRules are the rules of the owl 2 rl profile
+// (https://www.w3.org/TR/owl2-profiles/#OWL_2_RL) on properties, class
+// expressions and equality that go beyond rdfs, see rdfsRules, which
+// covers prp-dom, prp-rng, prp-spo1, cax-sco, scm-sco and scm-spo.
+// owl:sameAs is only made symmetric and transitive, the replacement
+// rules eq-rep-* are left out. Databases with sameAs enabled replace
+// equal constants instead, see equality.go.
+//
+// Lists are traversed by the helper relations listMember (the members
+// of a list) and hasAllTypesOf (an individual has all classes of a list
+// as type) in helperNamespace. Inconsistencies are not derived, they
+// are checked by owlRlViolations instead of making everything true.
+const owlRlRules = `
+@prefix rdf: <` + rdfNs + `> .
+@prefix owl: <` + owlNs + `> .
+@prefix h: <` + helperNamespace + `> .
+
+# prp-symp, prp-trp
+?y ?p ?x :- ?p rdf:type owl:SymmetricProperty, ?x ?p ?y.
+?x ?p ?z :- ?p rdf:type owl:TransitiveProperty, ?x ?p ?y, ?y ?p ?z.
+# prp-inv1, prp-inv2
+?y ?q ?x :- ?p owl:inverseOf ?q, ?x ?p ?y.
+?y ?p ?x :- ?p owl:inverseOf ?q, ?x ?q ?y.
+# prp-eqp1, prp-eqp2
+?x ?q ?y :- ?p owl:equivalentProperty ?q, ?x ?p ?y.
+?x ?p ?y :- ?p owl:equivalentProperty ?q, ?x ?q ?y.
+
+# cax-eqc1, cax-eqc2
+?x rdf:type ?d :- ?c owl:equivalentClass ?d, ?x rdf:type ?c.
+?x rdf:type ?c :- ?c owl:equivalentClass ?d, ?x rdf:type ?d.
+
+# cls-svf1, cls-svf2
+?u rdf:type ?x :- ?x owl:someValuesFrom ?y, ?x owl:onProperty ?p, ?u ?p ?v, ?v rdf:type ?y.
+?u rdf:type ?x :- ?x owl:someValuesFrom owl:Thing, ?x owl:onProperty ?p, ?u ?p ?v.
+# cls-avf
+?v rdf:type ?y :- ?x owl:allValuesFrom ?y, ?x owl:onProperty ?p, ?u rdf:type ?x, ?u ?p ?v.
+# cls-hv1, cls-hv2
+?u ?p ?y :- ?x owl:hasValue ?y, ?x owl:onProperty ?p, ?u rdf:type ?x.
+?u rdf:type ?x :- ?x owl:hasValue ?y, ?x owl:onProperty ?p, ?u ?p ?y.
+
+# lists
+?l h:listMember ?m :- ?l rdf:first ?m.
+?l h:listMember ?m :- ?l rdf:rest ?r, ?r h:listMember ?m.
+?y h:hasAllTypesOf ?l :- ?l rdf:first ?c, ?l rdf:rest rdf:nil, ?y rdf:type ?c.
+?y h:hasAllTypesOf ?l :- ?l rdf:first ?c, ?l rdf:rest ?r, ?y h:hasAllTypesOf ?r, ?y rdf:type ?c.
+# cls-int1, cls-int2
+?y rdf:type ?c :- ?c owl:intersectionOf ?l, ?y h:hasAllTypesOf ?l.
+?y rdf:type ?d :- ?c owl:intersectionOf ?l, ?l h:listMember ?d, ?y rdf:type ?c.
+
+# eq-sym, eq-trans
+?y owl:sameAs ?x :- ?x owl:sameAs ?y.
+?x owl:sameAs ?z :- ?x owl:sameAs ?y, ?y owl:sameAs ?z.
+`
+
+// owlRlConstraints are the owl 2 rl rules deriving false. They are
+// never evaluated as program, owlRlViolations evaluates their bodies
+// and reports the subject ?x of each solution as violating the rule
+// named by the head object.
+const owlRlConstraints = `
+@prefix rdf: <` + rdfNs + `> .
+@prefix owl: <` + owlNs + `> .
+
+?x :violates "cls-nothing2" :- ?x rdf:type owl:Nothing.
+?x :violates "cax-dw" :- ?c owl:disjointWith ?d, ?x rdf:type ?c, ?x rdf:type ?d.
+?x :violates "eq-diff1" :- ?x owl:sameAs ?y, ?x owl:differentFrom ?y.
+`
+
+// owlRlProgram returns the rdfs and owl 2 rl rules as Program, see
+// rdfsProgram
+func owlRlProgram() Program {
+	db := newDatabase()
+	prog, err := parseProgramString(rdfsRules+owlRlRules, &db)
+	if err != nil {
+		panic(err.Error())
+	}
+	return prog
+}
+
+// owlViolation is an inconsistency derived by owlRlProgram: subject
+// violates the named owl 2 rl rule
+type owlViolation struct {
+	rule    string
+	subject Term
+}
+
+type inconsistencyError struct {
+	violations []owlViolation
+}
+
+func (e *inconsistencyError) Error() string {
+	vs := make([]string, 0, len(e.violations))
+	for _, v := range e.violations {
+		vs = append(vs, fmt.Sprintf("%s violates %s", v.subject, v.rule))
+	}
+	return "inconsistent ontology: " + strings.Join(vs, ", ")
+}
+
+// owlRlViolations checks the facts owlRlProgram derived in db against
+// owlRlConstraints and returns the inconsistencies as error, or nil if
+// db is consistent
+func owlRlViolations(db *Database) error {
+	c := newDatabase()
+	constraints, err := parseProgramString(owlRlConstraints, &c)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	vs := make([]owlViolation, 0)
+	seen := make(map[string]bool)
+	for _, r := range constraints {
+		rule, _ := stringValue(r.head.o)
+		for _, mu := range r.eval(db) {
+			x := mu[Variable("?x")]
+			if k := rule + " " + termKey(x); !seen[k] {
+				seen[k] = true
+				vs = append(vs, owlViolation{rule, x})
+			}
+		}
+	}
+	if len(vs) == 0 {
+		return nil
+	}
+
+	sort.Slice(vs, func(i, j int) bool {
+		if vs[i].rule != vs[j].rule {
+			return vs[i].rule < vs[j].rule
+		}
+		return termKey(vs[i].subject) < termKey(vs[j].subject)
+	})
+
+	return &inconsistencyError{vs}
+}
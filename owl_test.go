@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const owlOntology = `
+@prefix rdf: <http://www.w3.org/1999/02/22-rdf-syntax-ns#> .
+@prefix rdfs: <http://www.w3.org/2000/01/rdf-schema#> .
+@prefix owl: <http://www.w3.org/2002/07/owl#> .
+
+:ancestorOf a owl:TransitiveProperty .
+:marriedTo a owl:SymmetricProperty .
+:parentOf owl:inverseOf :childOf ; rdfs:subPropertyOf :ancestorOf .
+
+:Parent owl:equivalentClass [ owl:onProperty :parentOf ; owl:someValuesFrom owl:Thing ] .
+:Vegan owl:onProperty :eats ; owl:allValuesFrom :Plant .
+:Dutch owl:onProperty :citizenOf ; owl:hasValue :nl .
+:DutchParent owl:intersectionOf ( :Dutch :Parent ) .
+
+:ann :parentOf :bob .
+:bob :parentOf :cid .
+:ann :marriedTo :dan .
+:ann :citizenOf :nl .
+:eve a :Vegan ; :eats :kale .
+:fay a :DutchParent .
+`
+
+const owlClosure = `
+@prefix rdf: <http://www.w3.org/1999/02/22-rdf-syntax-ns#> .
+@prefix owl: <http://www.w3.org/2002/07/owl#> .
+
+:ann :ancestorOf :bob , :cid .
+:bob :ancestorOf :cid .
+:bob :childOf :ann .
+:cid :childOf :bob .
+:dan :marriedTo :ann .
+:ann a :Parent , :Dutch , :DutchParent .
+:bob a :Parent .
+:kale a :Plant .
+:fay a :Dutch , :Parent ; :citizenOf :nl .
+`
+
+func TestOwlRl(t *testing.T) {
+
+	db := loadTestTurtle(t, owlOntology)
+	prog := owlRlProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+
+	facts := make(map[string]bool)
+	for _, f := range allFacts(&db) {
+		facts[f] = true
+	}
+
+	closure := loadTestTurtle(t, owlClosure)
+	for _, f := range allFacts(&closure) {
+		if !facts[f] {
+			t.Error("not derived:", f)
+		}
+	}
+
+	if db.knows(Atom{Constant(":bob"), iriToConstant(rdfNs + "type"), Constant(":DutchParent"), false}) {
+		t.Error(":bob is no :Dutch")
+	}
+
+	if err := owlRlViolations(&db); err != nil {
+		t.Error("consistent ontology reported as inconsistent", err)
+	}
+
+	// helper relations are derived but not exported
+	listMember := iriToConstant(helperNamespace + "listMember")
+	if len(db.rel(listMember)) == 0 {
+		t.Error("list members not derived")
+	}
+	var sb strings.Builder
+	if err := db.writeNTriples(&sb, exportAll); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sb.String(), helperNamespace) {
+		t.Error("helper relations exported")
+	}
+
+	del := loadTestTurtle(t, `:bob :parentOf :cid .`)
+	dRed(&db, &del, &prog)
+
+	// reloading would relabel the blank nodes
+	expected := db.deepCopy()
+	expected.clearIdb()
+	prog.evalSeminaive(&expected)
+
+	if strings.Join(allFacts(&db), "\n") != strings.Join(allFacts(&expected), "\n") ||
+		db.knows(newAtom(":ann", ":ancestorOf", ":cid")) || db.knows(newAtom(":cid", ":childOf", ":bob")) {
+		t.Error("delete was not maintained")
+	}
+}
+
+func TestOwlRlInconsistency(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		@prefix owl: <http://www.w3.org/2002/07/owl#> .
+		@prefix rdfs: <http://www.w3.org/2000/01/rdf-schema#> .
+
+		:Square rdfs:subClassOf owl:Nothing .
+		:Cat owl:disjointWith :Dog .
+
+		:a a :Square .
+		:b a :Cat , :Dog .
+		:c owl:sameAs :d .
+		:d owl:differentFrom :c .
+	`)
+	prog := owlRlProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+
+	if len(db.rel(":violates")) > 0 {
+		t.Error("violations materialized")
+	}
+
+	err := owlRlViolations(&db)
+	ie, ok := err.(*inconsistencyError)
+	if !ok {
+		t.Fatal("inconsistencies were not reported", err)
+	}
+
+	expected := []owlViolation{
+		{"cax-dw", Constant(":b")},
+		{"cls-nothing2", Constant(":a")},
+		{"eq-diff1", Constant(":d")},
+	}
+
+	if len(ie.violations) != len(expected) {
+		t.Fatal("wrong violations", ie)
+	}
+	for i, v := range expected {
+		if ie.violations[i] != v {
+			t.Error("wrong violation", ie.violations[i], v)
+		}
+	}
+}
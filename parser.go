@@ -0,0 +1,832 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// parser reads programs in a small textual datalog syntax over triple
+// atoms:
+//
+//	@prefix ex: <http://example.org/> .
+//	:a :link :b.
+//	?x :reachable ?y :- ?x :link ?z, ?z :reachable ?y.
+//	?x :indirect ?y :- ?x :reachable ?y, not ?x :link ?y.
+//	?x :adult :yes :- ?x :age ?a, ?a >= 18.
+//	?x :sum ?z :- ?x :a ?a, ?x :b ?b, ?z = ?a + ?b.
+//	?x :label ?l :- ?x :name ?n, prefix(?n, "A"), ?l = concat("the ", ?n).
+//
+// Terms are variables (?x), constants in the default namespace (:a),
+// prefixed names (ex:a), full iris (<http://...>) and blank nodes
+// (_:b). Objects can also be literals: numbers (42, 1.5), strings
+// ("x") and arrays ([1, "x"]). Everything after a '#' up to the end
+// of the line is a comment.
+
+type parseError struct {
+	line, col int
+	msg       string
+}
+
+func (e *parseError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.line, e.col, e.msg)
+}
+
+const (
+	tokEOF = iota
+	tokVar
+	tokConst
+	tokPName
+	tokDot
+	tokComma
+	tokImplies
+	tokNot
+	tokPrefix
+	tokString
+	tokNumber
+	tokLBracket
+	tokRBracket
+	tokLParen
+	tokRParen
+	tokOp
+	tokName
+)
+
+type token struct {
+	kind      int
+	text      string
+	line, col int
+}
+
+type lexer struct {
+	r         *bufio.Reader
+	line, col int
+	peeked    []rune
+}
+
+func newLexer(r io.Reader) *lexer {
+	return &lexer{r: bufio.NewReader(r), line: 1, col: 0}
+}
+
+func (l *lexer) read() (rune, bool) {
+	var c rune
+	if len(l.peeked) > 0 {
+		c = l.peeked[len(l.peeked)-1]
+		l.peeked = l.peeked[:len(l.peeked)-1]
+	} else {
+		var err error
+		c, _, err = l.r.ReadRune()
+		if err != nil {
+			return 0, false
+		}
+	}
+	if c == '\n' {
+		l.line++
+		l.col = 0
+	} else {
+		l.col++
+	}
+	return c, true
+}
+
+func (l *lexer) peek() (rune, bool) {
+	if len(l.peeked) > 0 {
+		return l.peeked[len(l.peeked)-1], true
+	}
+	c, _, err := l.r.ReadRune()
+	if err != nil {
+		return 0, false
+	}
+	l.peeked = append(l.peeked, c)
+	return c, true
+}
+
+func (l *lexer) unread(c rune) {
+	l.peeked = append(l.peeked, c)
+	l.col--
+}
+
+func (l *lexer) errorf(line, col int, format string, args ...interface{}) error {
+	return &parseError{line: line, col: col, msg: fmt.Sprintf(format, args...)}
+}
+
+// peekOperator tells a '<' comparison apart from the start of an iri,
+// which can neither contain whitespace nor start with '=', '?' or '"'
+func (l *lexer) peekOperator() bool {
+	c, ok := l.peek()
+	return !ok || unicode.IsSpace(c) || strings.ContainsRune("=?\"-+", c) || unicode.IsDigit(c)
+}
+
+func (l *lexer) peekDigit() bool {
+	c, ok := l.peek()
+	return ok && unicode.IsDigit(c)
+}
+
+func isNameRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '-'
+}
+
+// readName reads name runes, allowing inner dots and colons but not
+// a trailing dot, which terminates the statement instead.
+func (l *lexer) readName(sb *strings.Builder) {
+	for {
+		c, ok := l.peek()
+		if !ok {
+			return
+		}
+		if isNameRune(c) || c == ':' {
+			l.read()
+			sb.WriteRune(c)
+			continue
+		}
+		if c == '.' {
+			l.read()
+			c_, ok := l.peek()
+			if ok && isNameRune(c_) {
+				sb.WriteRune('.')
+				continue
+			}
+			// give the dot back
+			l.unread('.')
+		}
+		return
+	}
+}
+
+func (l *lexer) next() (token, error) {
+
+	// skip whitespace and comments
+	for {
+		c, ok := l.peek()
+		if !ok {
+			return token{kind: tokEOF, line: l.line, col: l.col + 1}, nil
+		}
+		if unicode.IsSpace(c) {
+			l.read()
+			continue
+		}
+		if c == '#' {
+			for {
+				c, ok := l.read()
+				if !ok || c == '\n' {
+					break
+				}
+			}
+			continue
+		}
+		break
+	}
+
+	c, _ := l.read()
+	line, col := l.line, l.col
+	tok := token{line: line, col: col}
+
+	switch {
+	case c == '.':
+		tok.kind = tokDot
+		tok.text = "."
+	case c == ',':
+		tok.kind = tokComma
+		tok.text = ","
+	case c == '[':
+		tok.kind = tokLBracket
+		tok.text = "["
+	case c == ']':
+		tok.kind = tokRBracket
+		tok.text = "]"
+	case c == '"':
+		s, err := l.readString('"', line, col)
+		if err != nil {
+			return tok, err
+		}
+		tok.kind = tokString
+		tok.text = s
+	case unicode.IsDigit(c) || (c == '-' || c == '+') && l.peekDigit():
+		_, tok.text = l.readNumber(c)
+		tok.kind = tokNumber
+	case c == '?':
+		var sb strings.Builder
+		sb.WriteRune('?')
+		l.readName(&sb)
+		if sb.Len() == 1 || strings.ContainsRune(sb.String(), ':') {
+			return tok, l.errorf(line, col, "malformed variable %q", sb.String())
+		}
+		tok.kind = tokVar
+		tok.text = sb.String()
+	case c == '(':
+		tok.kind = tokLParen
+		tok.text = "("
+	case c == ')':
+		tok.kind = tokRParen
+		tok.text = ")"
+	case c == '<' && l.peekOperator():
+		tok.kind = tokOp
+		tok.text = "<"
+		if c_, _ := l.peek(); c_ == '=' {
+			l.read()
+			tok.text = "<="
+		}
+	case c == '>':
+		tok.kind = tokOp
+		tok.text = ">"
+		if c_, ok := l.peek(); ok && c_ == '=' {
+			l.read()
+			tok.text = ">="
+		}
+	case c == '!':
+		if c_, ok := l.read(); !ok || c_ != '=' {
+			return tok, l.errorf(line, col, "expected '!='")
+		}
+		tok.kind = tokOp
+		tok.text = "!="
+	case c == '=' || c == '*' || c == '/' || c == '+' || c == '-':
+		tok.kind = tokOp
+		tok.text = string(c)
+	case c == '<':
+		var sb strings.Builder
+		sb.WriteRune('<')
+		for {
+			c, ok := l.read()
+			if !ok || c == '\n' {
+				return tok, l.errorf(line, col, "unterminated iri")
+			}
+			sb.WriteRune(c)
+			if c == '>' {
+				break
+			}
+			if unicode.IsSpace(c) {
+				return tok, l.errorf(l.line, l.col, "whitespace in iri")
+			}
+		}
+		tok.kind = tokConst
+		tok.text = sb.String()
+	case c == ':':
+		c_, ok := l.peek()
+		if ok && c_ == '-' {
+			l.read()
+			tok.kind = tokImplies
+			tok.text = ":-"
+			break
+		}
+		var sb strings.Builder
+		sb.WriteRune(':')
+		l.readName(&sb)
+		tok.kind = tokPName
+		tok.text = sb.String()
+	case c == '@':
+		var sb strings.Builder
+		l.readName(&sb)
+		if sb.String() != "prefix" {
+			return tok, l.errorf(line, col, "unknown directive @%s", sb.String())
+		}
+		tok.kind = tokPrefix
+		tok.text = "@prefix"
+	case isNameRune(c):
+		var sb strings.Builder
+		sb.WriteRune(c)
+		l.readName(&sb)
+		s := sb.String()
+		if s == "not" {
+			tok.kind = tokNot
+		} else if strings.ContainsRune(s, ':') {
+			tok.kind = tokPName
+		} else {
+			tok.kind = tokName
+		}
+		tok.text = s
+	default:
+		return tok, l.errorf(line, col, "unexpected character %q", c)
+	}
+
+	return tok, nil
+}
+
+type parser struct {
+	lex      *lexer
+	tok      token
+	prefixes map[string]string
+}
+
+func (p *parser) advance() error {
+	t, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = t
+	return nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &parseError{line: t.line, col: t.col, msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) expect(kind int, what string) (token, error) {
+	t := p.tok
+	if t.kind != kind {
+		return t, p.errorf(t, "expected %s, found %s", what, describeToken(t))
+	}
+	return t, p.advance()
+}
+
+func describeToken(t token) string {
+	if t.kind == tokEOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// expandPName resolves a prefixed name against the declared
+// prefixes. Names in the empty prefix stay in the default namespace
+// (:a) unless ':' was declared explicitly.
+func (p *parser) expandPName(t token) (Term, error) {
+	i := strings.IndexRune(t.text, ':')
+	prefix, local := t.text[:i], t.text[i+1:]
+
+	if prefix == "_" {
+		return Constant(t.text), nil
+	}
+
+	iri, ok := p.prefixes[prefix]
+	if !ok {
+		if prefix == "" {
+			return Constant(t.text), nil
+		}
+		return nil, p.errorf(t, "undeclared prefix %q", prefix)
+	}
+
+	return Constant("<" + iri + local + ">"), nil
+}
+
+func (p *parser) parseTerm() (Term, token, error) {
+	t := p.tok
+	switch t.kind {
+	case tokVar:
+		return Variable(t.text), t, p.advance()
+	case tokConst:
+		return Constant(t.text), t, p.advance()
+	case tokPName:
+		c, err := p.expandPName(t)
+		if err != nil {
+			return nil, t, err
+		}
+		return c, t, p.advance()
+	case tokName:
+		return nil, t, p.errorf(t, "unexpected name %q, missing prefix?", t.text)
+	case tokString, tokNumber, tokLBracket:
+		l, err := p.parseLiteral()
+		return l, t, err
+	}
+	return nil, t, p.errorf(t, "expected term, found %s", describeToken(t))
+}
+
+func (p *parser) parseLiteral() (Literal, error) {
+	t := p.tok
+	switch t.kind {
+	case tokString:
+		return String(t.text), p.advance()
+	case tokNumber:
+		l, ok := parseNumber(t.text)
+		if !ok {
+			return nil, p.errorf(t, "malformed number %q", t.text)
+		}
+		return l, p.advance()
+	case tokLBracket:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		a := make(Array, 0)
+		for p.tok.kind != tokRBracket {
+			if len(a) > 0 {
+				if _, err := p.expect(tokComma, "',' or ']'"); err != nil {
+					return nil, err
+				}
+			}
+			l, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, l)
+		}
+		return a, p.advance()
+	}
+	return nil, p.errorf(t, "expected literal, found %s", describeToken(t))
+}
+
+// parsedAtom remembers where an atom started for error reporting
+type parsedAtom struct {
+	atom Atom
+	tok  token
+}
+
+func (p *parser) parseAtom() (parsedAtom, error) {
+	pa := parsedAtom{tok: p.tok}
+
+	if p.tok.kind == tokNot {
+		pa.atom.neg = true
+		if err := p.advance(); err != nil {
+			return pa, err
+		}
+	}
+
+	s, sTok, err := p.parseTerm()
+	if err != nil {
+		return pa, err
+	}
+	return p.parseAtomFrom(pa, s, sTok)
+}
+
+// parseAtomFrom parses predicate and object of an atom whose subject
+// s was already read
+func (p *parser) parseAtomFrom(pa parsedAtom, s Term, sTok token) (parsedAtom, error) {
+	pa, err := p.parsePredicate(pa, s, sTok)
+	if err != nil {
+		return pa, err
+	}
+	o, _, err := p.parseTerm()
+	if err != nil {
+		return pa, err
+	}
+
+	pa.atom.o = o
+	return pa, nil
+}
+
+func (p *parser) parsePredicate(pa parsedAtom, s Term, sTok token) (parsedAtom, error) {
+	if isLiteral(s) {
+		return pa, p.errorf(sTok, "literals are only allowed in o position")
+	}
+	pr, prTok, err := p.parseTerm()
+	if err != nil {
+		return pa, err
+	}
+	if isLiteral(pr) {
+		return pa, p.errorf(prTok, "only constant or variable allowed in p position")
+	}
+
+	pa.atom.s, pa.atom.p = s, pr
+	return pa, nil
+}
+
+// parseHead parses an atom whose object may be an aggregate over a
+// variable, e.g. count(?y)
+func (p *parser) parseHead() (parsedAtom, *Aggregate, error) {
+	if p.tok.kind == tokNot {
+		pa, err := p.parseAtom()
+		return pa, nil, err
+	}
+
+	pa := parsedAtom{tok: p.tok}
+	s, sTok, err := p.parseTerm()
+	if err != nil {
+		return pa, nil, err
+	}
+	pa, err = p.parsePredicate(pa, s, sTok)
+	if err != nil {
+		return pa, nil, err
+	}
+
+	if p.tok.kind != tokName {
+		o, _, err := p.parseTerm()
+		pa.atom.o = o
+		return pa, nil, err
+	}
+
+	t := p.tok
+	if err := p.advance(); err != nil {
+		return pa, nil, err
+	}
+	if _, err := p.expect(tokLParen, "'('"); err != nil {
+		return pa, nil, err
+	}
+	v, err := p.expect(tokVar, "variable")
+	if err != nil {
+		return pa, nil, err
+	}
+	if _, err := p.expect(tokRParen, "')'"); err != nil {
+		return pa, nil, err
+	}
+
+	agg, err := newAggregate(t.text, Variable(v.text))
+	if err != nil {
+		return pa, nil, p.errorf(t, "%s", err)
+	}
+	pa.atom.o = agg.v
+	return pa, &agg, nil
+}
+
+// parsedBuiltin remembers where a builtin started for error
+// reporting
+type parsedBuiltin struct {
+	builtin Builtin
+	tok     token
+}
+
+func (p *parser) parseCall(out Variable) (Builtin, error) {
+	t := p.tok
+	if err := p.advance(); err != nil {
+		return Builtin{}, err
+	}
+	if _, err := p.expect(tokLParen, "'('"); err != nil {
+		return Builtin{}, err
+	}
+	args := make([]Term, 0)
+	for p.tok.kind != tokRParen {
+		if len(args) > 0 {
+			if _, err := p.expect(tokComma, "',' or ')'"); err != nil {
+				return Builtin{}, err
+			}
+		}
+		a, _, err := p.parseTerm()
+		if err != nil {
+			return Builtin{}, err
+		}
+		args = append(args, a)
+	}
+	if err := p.advance(); err != nil {
+		return Builtin{}, err
+	}
+	b, err := newBuiltin(t.text, out, args...)
+	if err != nil {
+		return b, p.errorf(t, "%s", err)
+	}
+	return b, nil
+}
+
+// parseBodyElem parses either an atom or a builtin
+func (p *parser) parseBodyElem() (*parsedAtom, *parsedBuiltin, error) {
+	start := p.tok
+
+	switch start.kind {
+	case tokNot:
+		pa, err := p.parseAtom()
+		return &pa, nil, err
+	case tokName:
+		b, err := p.parseCall("")
+		return nil, &parsedBuiltin{b, start}, err
+	}
+
+	t1, t1Tok, err := p.parseTerm()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.tok.kind != tokOp {
+		pa, err := p.parseAtomFrom(parsedAtom{tok: start}, t1, t1Tok)
+		return &pa, nil, err
+	}
+
+	op := p.tok
+	if err := p.advance(); err != nil {
+		return nil, nil, err
+	}
+
+	var b Builtin
+	var t2, t3 Term
+
+	switch op.text {
+	case "<", "<=", ">", ">=", "!=":
+		if t2, _, err = p.parseTerm(); err != nil {
+			return nil, nil, err
+		}
+		b, err = newBuiltin(op.text, "", t1, t2)
+	case "=":
+		out, _ := t1.(Variable)
+		if p.tok.kind == tokName {
+			if out == "" {
+				return nil, nil, p.errorf(start, "result of %s must be bound to a variable", p.tok.text)
+			}
+			b, err = p.parseCall(out)
+			if err != nil {
+				return nil, nil, err
+			}
+			break
+		}
+		if t2, _, err = p.parseTerm(); err != nil {
+			return nil, nil, err
+		}
+		if p.tok.kind == tokOp && strings.Contains("+-*/", p.tok.text) {
+			arith := p.tok
+			if err = p.advance(); err != nil {
+				return nil, nil, err
+			}
+			if t3, _, err = p.parseTerm(); err != nil {
+				return nil, nil, err
+			}
+			if out == "" {
+				return nil, nil, p.errorf(start, "result of %s must be bound to a variable", arith.text)
+			}
+			b, err = newBuiltin(arith.text, out, t2, t3)
+		} else if out != "" {
+			b, err = newBuiltin("=", out, t2)
+		} else {
+			b, err = newBuiltin("=", "", t1, t2)
+		}
+	default:
+		return nil, nil, p.errorf(op, "unexpected operator %s", op.text)
+	}
+
+	if err != nil {
+		return nil, nil, p.errorf(start, "%s", err)
+	}
+
+	return nil, &parsedBuiltin{b, start}, nil
+}
+
+func (p *parser) parsePrefix() error {
+	if err := p.advance(); err != nil {
+		return err
+	}
+	t, err := p.expect(tokPName, "prefix name")
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(t.text, ":") || strings.Count(t.text, ":") != 1 {
+		return p.errorf(t, "malformed prefix name %q", t.text)
+	}
+	iri, err := p.expect(tokConst, "iri")
+	if err != nil {
+		return err
+	}
+	p.prefixes[strings.TrimSuffix(t.text, ":")] = iri.text[1 : len(iri.text)-1]
+	_, err = p.expect(tokDot, "'.'")
+	return err
+}
+
+type parsedRule struct {
+	head     parsedAtom
+	agg      *Aggregate
+	body     []parsedAtom
+	builtins []parsedBuiltin
+}
+
+// checkRules validates rules against each other before they get
+// registered, so that mistakes are reported with a position instead of
+// a panic.
+func checkRules(rules []parsedRule) error {
+
+	prog := make(Program, 0, len(rules))
+
+	for _, r := range rules {
+		h := r.head
+		if h.atom.neg {
+			return &parseError{h.tok.line, h.tok.col, "negation is not allowed in head atoms"}
+		}
+
+		atoms := make([]Atom, 0, len(r.body))
+		for _, b := range r.body {
+			atoms = append(atoms, b.atom)
+		}
+		builtins := make([]Builtin, 0, len(r.builtins))
+		for _, b := range r.builtins {
+			builtins = append(builtins, b.builtin)
+		}
+
+		bound, err := boundVariables(atoms, builtins)
+		if err != nil {
+			for _, b := range r.builtins {
+				for _, v := range b.builtin.inputs() {
+					if !bound[v] {
+						return &parseError{b.tok.line, b.tok.col, err.Error()}
+					}
+				}
+			}
+		}
+
+		for _, b := range r.body {
+			for _, t := range []Term{b.atom.s, b.atom.p, b.atom.o} {
+				if b.atom.neg && isVariable(t) && !bound[t.(Variable)] {
+					return &parseError{b.tok.line, b.tok.col,
+						fmt.Sprintf("variable %s in negated atom is not bound by a positive atom", t)}
+				}
+			}
+		}
+
+		for _, t := range []Term{h.atom.s, h.atom.p, h.atom.o} {
+			if isVariable(t) && !bound[t.(Variable)] {
+				return &parseError{h.tok.line, h.tok.col,
+					fmt.Sprintf("head variable %s is not bound by a positive body atom", t)}
+			}
+		}
+
+		if r.agg != nil && (h.atom.s == r.agg.v || h.atom.p == r.agg.v) {
+			return &parseError{h.tok.line, h.tok.col,
+				fmt.Sprintf("aggregated variable %s can not be grouped by", r.agg.v)}
+		}
+
+		prog = append(prog, Rule{head: h.atom, body: atoms, agg: r.agg})
+	}
+
+	if _, err := prog.stratify(); err != nil {
+		se := err.(*stratificationError)
+		b := rules[se.rule].body[se.atom]
+		return &parseError{b.tok.line, b.tok.col, se.msg}
+	}
+
+	return nil
+}
+
+// parseProgram parses rules and facts from r. Rules are registered in
+// db and returned as a Program, ground facts are added to db.
+func parseProgram(r io.Reader, db *Database) (Program, error) {
+
+	p := &parser{lex: newLexer(r), prefixes: make(map[string]string)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	rules := make([]parsedRule, 0)
+	facts := make([]parsedAtom, 0)
+
+	for p.tok.kind != tokEOF {
+
+		if p.tok.kind == tokPrefix {
+			if err := p.parsePrefix(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		head, agg, err := p.parseHead()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.tok.kind == tokDot {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if head.atom.neg {
+				return nil, p.errorf(head.tok, "facts can not be negated")
+			}
+			if agg != nil {
+				return nil, p.errorf(head.tok, "aggregates are only allowed in rule heads")
+			}
+			if !head.atom.isGround() {
+				return nil, p.errorf(head.tok, "facts must be ground")
+			}
+			facts = append(facts, head)
+			continue
+		}
+
+		if _, err := p.expect(tokImplies, "'.' or ':-'"); err != nil {
+			return nil, err
+		}
+
+		r := parsedRule{head: head, agg: agg, body: make([]parsedAtom, 0), builtins: make([]parsedBuiltin, 0)}
+		for {
+			a, b, err := p.parseBodyElem()
+			if err != nil {
+				return nil, err
+			}
+			if a != nil {
+				r.body = append(r.body, *a)
+			} else {
+				r.builtins = append(r.builtins, *b)
+			}
+			if p.tok.kind != tokComma {
+				break
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+
+		if _, err := p.expect(tokDot, "',' or '.'"); err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, r)
+	}
+
+	if err := checkRules(rules); err != nil {
+		return nil, err
+	}
+
+	prog := make(Program, 0, len(rules))
+	for _, r := range rules {
+		rule := Rule{head: r.head.atom, body: make([]Atom, 0, len(r.body)), agg: r.agg}
+		for _, b := range r.body {
+			rule.body = append(rule.body, b.atom)
+		}
+		for _, b := range r.builtins {
+			rule.builtins = append(rule.builtins, b.builtin)
+		}
+		prog = append(prog, rule)
+	}
+
+	if err := prog.register(db); err != nil {
+		return nil, err
+	}
+
+	for _, f := range facts {
+		db.addAtom(f.atom)
+	}
+
+	return prog, nil
+}
+
+func parseProgramString(s string, db *Database) (Program, error) {
+	return parseProgram(strings.NewReader(s), db)
+}
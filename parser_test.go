@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseProgram(t *testing.T) {
+
+	db := newDatabase()
+
+	prog, err := parseProgramString(`
+		# links
+		:a :link :b.
+		:b :link :c.
+
+		?x :reachable ?y :- ?x :link ?y.
+		?x :reachable ?y :- ?x :link ?z, ?z :reachable ?y.
+	`, &db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := mkProgram()
+
+	if len(prog) != len(expected) {
+		t.Fatal("wrong number of rules", prog)
+	}
+
+	for i, r := range prog {
+		if r.head != expected[i].head {
+			t.Error("wrong head", r.head, expected[i].head)
+		}
+		if len(r.body) != len(expected[i].body) {
+			t.Fatal("wrong body", r.body, expected[i].body)
+		}
+		for j, b := range r.body {
+			if b != expected[i].body[j] {
+				t.Error("wrong body atom", b, expected[i].body[j])
+			}
+		}
+	}
+
+	if !db.isIdbRelation(":reachable") || !db.isEdbRelation(":link") {
+		t.Error("relations were not registered")
+	}
+
+	if !db.knows(newAtom(":a", ":link", ":b")) || !db.knows(newAtom(":b", ":link", ":c")) {
+		t.Error("facts were not added")
+	}
+
+	prog.evalSeminaive(&db)
+
+	if !db.knows(newAtom(":a", ":reachable", ":c")) {
+		t.Error("':a :reachable :c' should have been derived")
+	}
+}
+
+func TestParseNegationAndPrefixes(t *testing.T) {
+
+	db := newDatabase()
+
+	prog, err := parseProgramString(`
+		@prefix ex: <http://example.org/> .
+		?x ex:indirect ?y :- ?x ex:reach ?y, not ?x ex:link ?y.
+		ex:a ex:link _:b1.
+	`, &db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !prog[0].body[1].neg || prog[0].body[0].neg {
+		t.Error("negation was not parsed", prog[0].body)
+	}
+
+	if prog[0].head.p != Constant("<http://example.org/indirect>") {
+		t.Error("prefix was not expanded", prog[0].head.p)
+	}
+
+	if !db.knows(Atom{Constant("<http://example.org/a>"), Constant("<http://example.org/link>"), Constant("_:b1"), false}) {
+		t.Error("fact with blank node was not added")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+
+	cases := []struct {
+		src       string
+		line, col int
+	}{
+		{":a :link :b", 1, 12},
+		{":a :link :b.\n?x :r ?y :- ?x :link.", 2, 21},
+		{"?x ?p ?y :- ?x :link ?y.", 1, 1},
+		{"?x 1 ?y :- ?x :link ?y.", 1, 4},
+		{"?x :r ?y :- ?x :link ?z.", 1, 1},
+		{"?x :r ?y :- ?x :link ?y, not ?x :s ?y.\n?x :s ?y :- ?x :r ?y.", 1, 26},
+		{"ex:a :link :b.", 1, 1},
+		{":a :link :b.\n  $", 2, 3},
+	}
+
+	for _, c := range cases {
+		db := newDatabase()
+		_, err := parseProgramString(c.src, &db)
+		if err == nil {
+			t.Error("expected error for", c.src)
+			continue
+		}
+		pe, ok := err.(*parseError)
+		if !ok {
+			t.Error("expected parseError, got", err)
+			continue
+		}
+		if pe.line != c.line || pe.col != c.col {
+			t.Error("wrong position", c.src, pe, c.line, c.col)
+		}
+	}
+}
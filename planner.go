@@ -0,0 +1,268 @@
+package main
+
+import "fmt"
+
+// planStep evaluates a single body atom, either against the database
+// or against the delta database of a DeltaRule, or a builtin
+type planStep struct {
+	atom    Atom
+	builtin *Builtin
+	delta   bool
+	// bound steps are evaluated as index lookups per mapping of the
+	// previous steps (sideways information passing) instead of a
+	// full scan followed by a hash join
+	bound bool
+}
+
+// rulePlan is the order in which the body atoms of a rule are
+// joined. Builtins are applied as soon as their inputs are bound,
+// negated atoms as filters after all positive atoms and builtins.
+type rulePlan struct {
+	steps []planStep
+	neg   []planStep
+	ready bool
+	// sizes are the estimated sizes of the positive body atoms the
+	// plan was computed for, see outdated
+	sizes []float64
+}
+
+func atomVariables(a *Atom) []Variable {
+	vars := make([]Variable, 0, 3)
+	for _, t := range []Term{a.s, a.p, a.o} {
+		if isVariable(t) {
+			vars = append(vars, t.(Variable))
+		}
+	}
+	return vars
+}
+
+func isBoundTerm(t Term, bound map[Variable]bool) bool {
+	return !isVariable(t) || bound[t.(Variable)]
+}
+
+// estimate guesses the number of mappings a produces once the
+// variables in bound are known, based on the relation size and the
+// number of distinct subjects and objects in its indexes. Atoms with a
+// variable predicate are estimated over all relations.
+func estimate(db *Database, a *Atom, bound map[Variable]bool) float64 {
+	if isBoundTerm(a.p, bound) && !isVariable(a.p) {
+		if !isConstant(a.p) {
+			return 0
+		}
+		c := a.p.(Constant)
+		return estimateRel(db.edbIndexes[c], len(db.edb[c]), a, bound) +
+			estimateRel(db.idbIndexes[c], len(db.idb[c]), a, bound)
+	}
+
+	n := 0.0
+	for relName, rel := range db.edb {
+		n += estimateRel(db.edbIndexes[relName], len(rel), a, bound)
+	}
+	for relName, rel := range db.idb {
+		n += estimateRel(db.idbIndexes[relName], len(rel), a, bound)
+	}
+	// a bound predicate selects a single relation
+	if isBoundTerm(a.p, bound) {
+		n /= float64(max(1, len(db.edb)+len(db.idb)))
+	}
+	return n
+}
+
+func estimateRel(idx *relIndex, l int, a *Atom, bound map[Variable]bool) float64 {
+	n := float64(l)
+	if idx == nil || n == 0 {
+		return 0
+	}
+
+	sBound, oBound := isBoundTerm(a.s, bound), isBoundTerm(a.o, bound)
+
+	switch {
+	case sBound && oBound:
+		return 1
+	case sBound:
+		return n / float64(max(1, len(idx.s)))
+	case oBound:
+		return n / float64(max(1, len(idx.o)))
+	}
+	return n
+}
+
+// bodySizes estimates the number of mappings of each positive atom of
+// body on its own
+func bodySizes(db *Database, body []Atom) []float64 {
+	sizes := make([]float64, 0, len(body))
+	for i := range body {
+		if !body[i].neg {
+			sizes = append(sizes, estimate(db, &body[i], nil))
+		}
+	}
+	return sizes
+}
+
+// outdated tests if a cached plan should be recomputed because the
+// sizes of the relations it joins changed materially since, i.e. by
+// more than a factor of two. During seminaive evaluation the derived
+// relations start out empty and grow with each iteration.
+func (plan *rulePlan) outdated(sizes []float64) bool {
+	if !plan.ready || len(sizes) != len(plan.sizes) {
+		return true
+	}
+	for i, n := range sizes {
+		o := plan.sizes[i]
+		if n > 2*o+planSlack || o > 2*n+planSlack {
+			return true
+		}
+	}
+	return false
+}
+
+// changes in the size of small relations do not lead to replanning
+const planSlack = 16
+
+func sharesVariable(a *Atom, bound map[Variable]bool) bool {
+	for _, v := range atomVariables(a) {
+		if bound[v] {
+			return true
+		}
+	}
+	return false
+}
+
+// planBody orders the positive atoms of body greedily by their
+// estimated number of mappings w.r.t. the variables bound by the
+// atoms chosen before. If first is given, it is evaluated first
+// against the delta database.
+func planBody(db *Database, first *Atom, body []Atom, builtins []Builtin) rulePlan {
+
+	plan := rulePlan{
+		steps: make([]planStep, 0, len(body)+1),
+		neg:   make([]planStep, 0),
+		ready: true,
+	}
+
+	bound := make(map[Variable]bool)
+
+	if first != nil {
+		if first.neg {
+			plan.neg = append(plan.neg, planStep{atom: *first, delta: true})
+		} else {
+			plan.steps = append(plan.steps, planStep{atom: *first, delta: true})
+			for _, v := range atomVariables(first) {
+				bound[v] = true
+			}
+		}
+	}
+
+	todo := make([]Atom, 0, len(body))
+	for _, a := range body {
+		if a.neg {
+			plan.neg = append(plan.neg, planStep{atom: a})
+		} else {
+			todo = append(todo, a)
+		}
+	}
+
+	pending := make([]Builtin, len(builtins))
+	copy(pending, builtins)
+
+	addReadyBuiltins := func() {
+		for i := 0; i < len(pending); i++ {
+			if pending[i].ready(bound) {
+				b := pending[i]
+				plan.steps = append(plan.steps, planStep{builtin: &b})
+				if b.out != "" {
+					bound[b.out] = true
+				}
+				pending = append(pending[:i], pending[i+1:]...)
+				i = -1
+			}
+		}
+	}
+
+	addReadyBuiltins()
+
+	for len(todo) > 0 {
+		best := 0
+		bestCost := estimate(db, &todo[0], bound)
+		for i := 1; i < len(todo); i++ {
+			cost := estimate(db, &todo[i], bound)
+			if cost < bestCost {
+				best, bestCost = i, cost
+			}
+		}
+
+		a := todo[best]
+		plan.steps = append(plan.steps, planStep{atom: a, bound: sharesVariable(&a, bound)})
+		for _, v := range atomVariables(&a) {
+			bound[v] = true
+		}
+
+		todo = append(todo[:best], todo[best+1:]...)
+
+		addReadyBuiltins()
+	}
+
+	if len(pending) > 0 {
+		panic(fmt.Sprintf("builtin %s has unbound inputs", &pending[0]))
+	}
+
+	return plan
+}
+
+// eval evaluates the plan w.r.t. a database instance and a delta
+// database instance, which is only used by delta steps
+func (plan *rulePlan) eval(db, delta *Database) Omega {
+
+	omega := Omega{make(Mu)}
+
+	for _, step := range plan.steps {
+		src := db
+		if step.delta {
+			src = delta
+		}
+
+		if step.builtin != nil {
+			omega = step.builtin.filter(omega)
+		} else if step.bound {
+			omega_ := make(Omega, 0, len(omega))
+			for _, mu := range omega {
+				for _, mu_ := range src.findMappingsBound(&step.atom, &mu) {
+					omega_ = append(omega_, mu.join(&mu_))
+				}
+			}
+			omega = omega_
+		} else {
+			o := src.findMappingsFor(&step.atom)
+			omega = omega.join(&o)
+		}
+
+		if len(omega) == 0 {
+			return omega
+		}
+	}
+
+	for _, step := range plan.neg {
+		src := db
+		if step.delta {
+			src = delta
+		}
+		omega = src.filterNeg(omega, &step.atom)
+	}
+
+	return omega
+}
+
+// filterNeg removes all mappings from omega for which the negated
+// atom a is known in db. As with joinNeg, a mapping that leaves
+// variables of a unbound is kept.
+func (db *Database) filterNeg(omega Omega, a *Atom) Omega {
+	omega_ := make(Omega, 0, len(omega))
+	for _, mu := range omega {
+		ga := a.bind(&mu)
+		ga.neg = false
+		if !ga.isGround() || !db.knows(ga) {
+			omega_ = append(omega_, mu)
+		}
+	}
+	return omega_
+}
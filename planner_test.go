@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPlanBodyOrder(t *testing.T) {
+
+	db := newDatabase()
+	for i := 0; i < 50; i++ {
+		db.addAtom(newAtom(":n"+strconv.Itoa(i), ":link", ":n"+strconv.Itoa(i+1)))
+		db.addAtom(newAtom(":n"+strconv.Itoa(i), ":color", ":red"))
+	}
+	db.addAtom(newAtom(":n7", ":start", ":yes"))
+
+	body := []Atom{
+		newAtom("?x", ":link", "?y"),
+		newAtom("?y", ":color", "?c"),
+		newAtom("?x", ":start", ":yes"),
+	}
+
+	plan := planBody(&db, nil, body, nil)
+
+	if plan.steps[0].atom != body[2] || plan.steps[0].bound {
+		t.Error("the selective atom should be evaluated first", plan.steps)
+	}
+
+	if plan.steps[1].atom != body[0] || !plan.steps[1].bound {
+		t.Error("?x :link ?y should be looked up with ?x bound", plan.steps)
+	}
+
+	omega := plan.eval(&db, &db)
+	if len(omega) != 1 || omega[0]["?y"] != Constant(":n8") || omega[0]["?c"] != Constant(":red") {
+		t.Error("wrong result", omega)
+	}
+}
+
+func TestPlanCachedAcrossIterations(t *testing.T) {
+
+	_, db := mkDatabase()
+	prog := mkProgram()
+	prog.register(&db)
+
+	dprog := prog.toDeltaProgram(&db, true)
+	dr := &dprog.drules[0]
+
+	if dr.plan.ready {
+		t.Error("plan should not be computed before the first evaluation")
+	}
+
+	delta := dprog.evalSeminaive_(&db, &db)
+	if !dr.plan.ready || dr.plan.steps[0].atom != dr.delta || !dr.plan.steps[0].delta {
+		t.Error("plan should be cached and start with the delta atom", dr.plan)
+	}
+
+	steps := dr.plan.steps
+	db.append(&delta, false)
+	dprog.evalSeminaive_(&db, &delta)
+
+	if &dr.plan.steps[0] != &steps[0] {
+		t.Error("plan was not reused")
+	}
+
+	// once :link grows materially the plan is recomputed
+	for i := 0; i < 100; i++ {
+		db.addAtom(newAtom(":x"+strconv.Itoa(i), ":link", ":x"+strconv.Itoa(i+1)))
+	}
+	dprog.evalSeminaive_(&db, &delta)
+
+	if &dr.plan.steps[0] == &steps[0] {
+		t.Error("plan was not recomputed for the grown relations")
+	}
+}
+
+func TestPlanOutdated(t *testing.T) {
+	plan := rulePlan{ready: true, sizes: []float64{0, 100}}
+	for _, c := range []struct {
+		sizes    []float64
+		outdated bool
+	}{
+		{[]float64{0, 100}, false},
+		{[]float64{10, 150}, false},
+		{[]float64{100, 100}, true},
+		{[]float64{0, 20}, true},
+		{[]float64{0}, true},
+	} {
+		if plan.outdated(c.sizes) != c.outdated {
+			t.Error("wrong outdated for", c.sizes)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Why-provenance. If enabled, evaluation records every rule instance
+// deriving a fact, the rule and its ground body atoms, whether the fact
+// was new or not. Deletions leave the records in place, a derivation
+// counts as long as its body holds in the database, so proofs are
+// always checked against the current facts.
+
+// provenance holds the recorded derivations per derived fact
+type provenance struct {
+	derivations map[triple][]derivation
+}
+
+// derivation is a rule instance, body holds the ground body atoms in
+// rule order, negated ones included. Aggregate rules have no body,
+// their instances depend on whole groups.
+type derivation struct {
+	rule *Rule
+	body []Atom
+}
+
+// Proof is a proof tree of fact. Base facts and negated atoms are
+// leaves without rule.
+type Proof struct {
+	fact     Atom
+	rule     *Rule
+	children []*Proof
+}
+
+func newProvenance() *provenance {
+	return &provenance{derivations: make(map[triple][]derivation)}
+}
+
+func (p *provenance) clone() *provenance {
+	p_ := newProvenance()
+	for t, ds := range p.derivations {
+		p_.derivations[t] = append([]derivation(nil), ds...)
+	}
+	return p_
+}
+
+// enableProvenance starts recording derivations, facts derived before
+// have no proofs
+func (d *Database) enableProvenance() {
+	if d.why == nil {
+		d.why = newProvenance()
+	}
+}
+
+// atomEqual compares atoms by their terms, array literals can not be
+// compared by ==
+func atomEqual(a, a_ *Atom) bool {
+	return a.neg == a_.neg && termEqual(a.s, a_.s) && termEqual(a.p, a_.p) && termEqual(a.o, a_.o)
+}
+
+func sameRule(r, r_ *Rule) bool {
+	if r == r_ {
+		return true
+	}
+	if !atomEqual(&r.head, &r_.head) || len(r.body) != len(r_.body) || (r.agg == nil) != (r_.agg == nil) {
+		return false
+	}
+	for i := range r.body {
+		if !atomEqual(&r.body[i], &r_.body[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d derivation) equalTo(d_ derivation) bool {
+	if len(d.body) != len(d_.body) || !sameRule(d.rule, d_.rule) {
+		return false
+	}
+	for i := range d.body {
+		if !atomEqual(&d.body[i], &d_.body[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// recordDerivation records the instance of r given by mu if provenance
+// is enabled
+func (d *Database) recordDerivation(r *Rule, mu *Mu) {
+	if d.why == nil {
+		return
+	}
+	h := r.head.bind(mu)
+	if !h.isStorable() {
+		return
+	}
+	if d.sameAs != nil {
+		h = d.canonicalAtom(&h)
+	}
+
+	der := derivation{rule: r}
+	if r.agg == nil {
+		der.body = make([]Atom, 0, len(r.body))
+		for _, a := range r.body {
+			a_ := a.bind(mu)
+			if d.sameAs != nil {
+				a_ = d.canonicalAtom(&a_)
+			}
+			der.body = append(der.body, a_)
+		}
+	}
+
+	t := encodeAtom(&h)
+	for _, der_ := range d.why.derivations[t] {
+		if der_.equalTo(der) {
+			return
+		}
+	}
+	d.why.derivations[t] = append(d.why.derivations[t], der)
+}
+
+// provenanceKey returns the fact a is recorded as, ok is false if d
+// does not know a
+func (d *Database) provenanceKey(a Atom) (Atom, bool) {
+	if !a.isGround() || !isConstant(a.p) || !d.knows(a) {
+		return a, false
+	}
+	if d.sameAs != nil {
+		a = d.canonicalAtom(&a)
+	}
+	return a, true
+}
+
+// proofRanks ranks the facts a proof of a may use by the height of
+// their lowest proof, base facts have rank 0. Facts without proof are
+// left out.
+func (d *Database) proofRanks(a Atom) map[triple]int {
+	ranks := make(map[triple]int)
+
+	// collect the derived facts below a
+	facts := make([]Atom, 0)
+	seen := map[triple]bool{encodeAtom(&a): true}
+	for todo := []Atom{a}; len(todo) > 0; {
+		f := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if d.knowsBase(f) {
+			ranks[encodeAtom(&f)] = 0
+			continue
+		}
+		facts = append(facts, f)
+		for _, der := range d.why.derivations[encodeAtom(&f)] {
+			for _, b := range der.body {
+				if b.neg || !d.knows(b) {
+					continue
+				}
+				if t := encodeAtom(&b); !seen[t] {
+					seen[t] = true
+					todo = append(todo, b)
+				}
+			}
+		}
+	}
+
+	// rank r is given to the facts with a derivation over facts of
+	// lower rank
+	for r := 1; ; r++ {
+		ranked := make([]triple, 0)
+		for _, f := range facts {
+			t := encodeAtom(&f)
+			if _, ok := ranks[t]; ok {
+				continue
+			}
+			for _, der := range d.why.derivations[t] {
+				if d.derivationRank(der, ranks) < r {
+					ranked = append(ranked, t)
+					break
+				}
+			}
+		}
+		if len(ranked) == 0 {
+			return ranks
+		}
+		for _, t := range ranked {
+			ranks[t] = r
+		}
+	}
+}
+
+// derivationRank returns the highest rank of the body facts of der, or
+// -1 if its body does not hold. Unranked facts have no proof yet.
+func (d *Database) derivationRank(der derivation, ranks map[triple]int) int {
+	rank := 0
+	for _, b := range der.body {
+		if b.neg {
+			b.neg = false
+			if d.knows(b) {
+				return -1
+			}
+			continue
+		}
+		r, ok := ranks[encodeAtom(&b)]
+		if !ok {
+			return int(^uint(0) >> 1)
+		}
+		rank = max(rank, r)
+	}
+	return rank
+}
+
+// proof returns a proof tree of a of least height, ok is false if a
+// is unknown or none of its proofs were recorded
+func (d *Database) proof(a Atom) (*Proof, bool) {
+	a, ok := d.provenanceKey(a)
+	if !ok {
+		return nil, false
+	}
+	if d.knowsBase(a) {
+		return &Proof{fact: a}, true
+	}
+	if d.why == nil {
+		return nil, false
+	}
+
+	ranks := d.proofRanks(a)
+	if _, ok := ranks[encodeAtom(&a)]; !ok {
+		return nil, false
+	}
+
+	var build func(f Atom) *Proof
+	build = func(f Atom) *Proof {
+		t := encodeAtom(&f)
+		if ranks[t] == 0 {
+			return &Proof{fact: f}
+		}
+		for _, der := range d.why.derivations[t] {
+			if r := d.derivationRank(der, ranks); r < 0 || r >= ranks[t] {
+				continue
+			}
+			p := &Proof{fact: f, rule: der.rule}
+			for _, b := range der.body {
+				if b.neg {
+					p.children = append(p.children, &Proof{fact: b})
+				} else {
+					p.children = append(p.children, build(b))
+				}
+			}
+			return p
+		}
+		panic("ranked fact without derivation")
+	}
+	return build(a), true
+}
+
+// proofs returns the proof trees of a in which no fact depends on
+// itself, at most n of them unless n <= 0. Their number may grow
+// exponentially with the height of the trees.
+func (d *Database) proofs(a Atom, n int) []*Proof {
+	a, ok := d.provenanceKey(a)
+	if !ok {
+		return nil
+	}
+
+	onPath := make(map[triple]bool)
+	var trees func(f Atom) []*Proof
+	trees = func(f Atom) []*Proof {
+		if d.knowsBase(f) {
+			return []*Proof{{fact: f}}
+		}
+		if d.why == nil {
+			return nil
+		}
+		t := encodeAtom(&f)
+		onPath[t] = true
+		defer delete(onPath, t)
+
+		ps := make([]*Proof, 0)
+		for _, der := range d.why.derivations[t] {
+			// the proofs of the body atoms combined
+			partial := [][]*Proof{{}}
+			for _, b := range der.body {
+				var bs []*Proof
+				if b.neg {
+					b_ := b
+					b_.neg = false
+					if !d.knows(b_) {
+						bs = []*Proof{{fact: b}}
+					}
+				} else if !onPath[encodeAtom(&b)] && d.knows(b) {
+					bs = trees(b)
+				}
+				partial_ := make([][]*Proof, 0)
+				for _, cs := range partial {
+					for _, b := range bs {
+						partial_ = append(partial_, append(cs[:len(cs):len(cs)], b))
+					}
+				}
+				partial = partial_
+				if n > 0 && len(partial) > n {
+					partial = partial[:n]
+				}
+			}
+			for _, cs := range partial {
+				if n > 0 && len(ps) == n {
+					break
+				}
+				ps = append(ps, &Proof{fact: f, rule: der.rule, children: cs})
+			}
+		}
+		return ps
+	}
+	return trees(a)
+}
+
+func atomString(a *Atom) string {
+	s := fmt.Sprintf("%v %v %v", a.s, a.p, a.o)
+	if a.neg {
+		return "not " + s
+	}
+	return s
+}
+
+func (r *Rule) String() string {
+	body := make([]string, 0, len(r.body)+len(r.builtins))
+	for i := range r.body {
+		body = append(body, atomString(&r.body[i]))
+	}
+	for i := range r.builtins {
+		body = append(body, r.builtins[i].String())
+	}
+	head := atomString(&r.head)
+	if r.agg != nil {
+		head = fmt.Sprintf("%v %v %s", r.head.s, r.head.p, r.agg)
+	}
+	return head + " :- " + strings.Join(body, ", ") + "."
+}
+
+// String prints the tree indented, each derived fact followed by its
+// rule
+func (p *Proof) String() string {
+	var b strings.Builder
+	var write func(p *Proof, indent string)
+	write = func(p *Proof, indent string) {
+		b.WriteString(indent + atomString(&p.fact))
+		if p.rule != nil {
+			b.WriteString("    [" + p.rule.String() + "]")
+		}
+		b.WriteString("\n")
+		for _, c := range p.children {
+			write(c, indent+"  ")
+		}
+	}
+	write(p, "")
+	return b.String()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"testing"
+)
+
+// checkProof checks that each node of p is a known fact derived by its
+// rule from its children, down to base facts
+func checkProof(t *testing.T, db *Database, p *Proof) {
+	t.Helper()
+	if p.fact.neg {
+		a := p.fact
+		a.neg = false
+		if db.knows(a) {
+			t.Errorf("negated leaf %v holds", a)
+		}
+		return
+	}
+	if !db.knows(p.fact) {
+		t.Errorf("unknown fact %v in proof", p.fact)
+	}
+	if p.rule == nil {
+		if !db.knowsBase(p.fact) {
+			t.Errorf("leaf %v is no base fact", p.fact)
+		}
+		return
+	}
+	if len(p.children) != len(p.rule.body) {
+		t.Fatalf("%d children for rule %s", len(p.children), p.rule)
+	}
+	mu := make(Mu)
+	for i, c := range p.children {
+		b := p.rule.body[i]
+		if !b.matches(&c.fact) || b.neg != c.fact.neg {
+			t.Errorf("%v does not match %v", c.fact, b)
+			continue
+		}
+		if !b.isGround() {
+			mu_ := b.toMu(&c.fact)
+			if !mu.compatible(&mu_) {
+				t.Errorf("%v does not match %v", c.fact, b)
+			}
+			for v, term := range mu_ {
+				mu[v] = term
+			}
+		}
+		checkProof(t, db, c)
+	}
+	if h := p.rule.head.bind(&mu); !atomEqual(&h, &p.fact) {
+		t.Errorf("rule derives %v, not %v", h, p.fact)
+	}
+}
+
+func TestProvenance(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		:a :link :b , :c .
+		:b :link :d .
+		:c :link :d .
+		:d :link :e .
+		:e :link :d .
+	`)
+	prog := mkProgram()
+	prog.register(&db)
+	db.enableProvenance()
+	prog.evalSeminaive(&db)
+
+	goal := newAtom(":a", ":reachable", ":d")
+	p, ok := db.proof(goal)
+	if !ok {
+		t.Fatal("no proof of", goal)
+	}
+	checkProof(t, &db, p)
+
+	expected := `:a :reachable :d    [?x :reachable ?y :- ?x :link ?z, ?z :reachable ?y.]
+  :a :link :b
+  :b :reachable :d    [?x :reachable ?y :- ?x :link ?y.]
+    :b :link :d
+`
+	if p.String() != expected {
+		t.Errorf("wrong proof:\n%s\nexpected:\n%s", p, expected)
+	}
+
+	// over :b or :c, each directly or around the cycle :d :e
+	ps := db.proofs(goal, 0)
+	if len(ps) != 4 {
+		t.Errorf("%d proofs of %v, expected 4", len(ps), goal)
+	}
+	for _, p := range ps {
+		checkProof(t, &db, p)
+	}
+	if ps := db.proofs(newAtom(":d", ":reachable", ":d"), 0); len(ps) != 1 {
+		t.Errorf("%d proofs of :d :reachable :d, expected 1", len(ps))
+	}
+	if ps := db.proofs(goal, 1); len(ps) != 1 {
+		t.Error("proofs not limited")
+	}
+
+	// deleted derivations are no longer used
+	del := loadTestTurtle(t, ":b :link :d .")
+	dRed(&db, &del, &prog)
+	ps = db.proofs(goal, 0)
+	if len(ps) != 2 {
+		t.Errorf("%d proofs of %v, expected 2", len(ps), goal)
+	}
+	for _, p := range ps {
+		if p.children[0].fact != newAtom(":a", ":link", ":c") {
+			t.Error("proof over deleted fact")
+		}
+	}
+
+	ins := loadTestTurtle(t, ":x :link :a .")
+	prog.evalSeminaiveAppend(&db, &ins)
+	if p, ok := db.proof(newAtom(":x", ":reachable", ":e")); !ok {
+		t.Error("no proof of appended consequence")
+	} else {
+		checkProof(t, &db, p)
+	}
+
+	if _, ok := db.proof(newAtom(":e", ":reachable", ":a")); ok {
+		t.Error("proof of unknown fact")
+	}
+	if p, ok := db.proof(newAtom(":a", ":link", ":c")); !ok || p.rule != nil {
+		t.Error("base fact is no leaf")
+	}
+}
+
+func TestProvenanceArray(t *testing.T) {
+
+	db := newDatabase()
+	db.addAtom(Atom{Constant(":a"), Constant(":tags"), Array{String("x"), Long(1)}, false})
+	prog := Program{
+		{head: newAtom("?x", ":labels", "?t"), body: []Atom{newAtom("?x", ":tags", "?t")}},
+		{head: newAtom("?x", ":tagged", "?t"), body: []Atom{newAtom("?x", ":labels", "?t")}},
+	}
+	prog.register(&db)
+	db.enableProvenance()
+	prog.evalSeminaive(&db)
+	// deriving again records the same derivations, which are compared
+	// by their terms, arrays can not be compared by ==
+	prog.evalNaive(&db)
+
+	goal := Atom{Constant(":a"), Constant(":tagged"), Array{String("x"), Long(1)}, false}
+	ps := db.proofs(goal, 0)
+	if len(ps) != 1 {
+		t.Fatalf("%d proofs of %v, expected 1", len(ps), goal)
+	}
+	checkProof(t, &db, ps[0])
+}
+
+func TestProvenanceNegationCounting(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		:tweety :a :Bird .
+		:pingu :a :Bird ; :a :Penguin .
+	`)
+	db.enableProvenance()
+	prog := parseTestProgram(t, `
+		?x :can :fly :- ?x :has :Wings.
+		?x :can :fly :- ?x :a :Bird, not ?x :a :Penguin.
+	`, &db)
+	prog.evalSeminaive(&db)
+
+	p, ok := db.proof(newAtom(":tweety", ":can", ":fly"))
+	if !ok {
+		t.Fatal("no proof with negation")
+	}
+	checkProof(t, &db, p)
+	if !p.children[1].fact.neg {
+		t.Error("negated body atom missing in proof")
+	}
+
+	// the counted component records its derivations as well
+	db = loadTestTurtle(t, ":pingu :a :Penguin .")
+	db.enableProvenance()
+	prog = parseTestProgram(t, countingRules, &db)
+	prog.evalSeminaive(&db)
+	ins := loadTestTurtle(t, ":pingu :has :Wings .")
+	prog.evalSeminaiveAppend(&db, &ins)
+	if p, ok := db.proof(newAtom(":pingu", ":can", ":fly")); !ok {
+		t.Error("no proof from counted component")
+	} else {
+		checkProof(t, &db, p)
+	}
+}
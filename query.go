@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Query is a conjunctive query over a database. Its body is evaluated
+// like the body of a rule, see Rule.eval, the mappings are then
+// ordered, projected to vars, made distinct and sliced, in this order.
+type Query struct {
+	body     []Atom
+	builtins []Builtin
+	// vars are the projected variables, all variables bound by the body
+	// in order of appearance if empty
+	vars     []Variable
+	distinct bool
+	orderBy  []orderKey
+	// limit is the maximal number of rows if hasLimit, offset the
+	// number of rows skipped
+	limit, offset int
+	hasLimit      bool
+}
+
+// orderKey orders the rows by a variable, ascending unless desc
+type orderKey struct {
+	v    Variable
+	desc bool
+}
+
+// Rows holds the rows of a query result, a row has a term per
+// variable, nil for variables left unbound
+type Rows struct {
+	vars []Variable
+	rows [][]Term
+}
+
+// check tests if q is safe: each variable it projects or orders by
+// and each variable of a negated atom is bound by the body
+func (q *Query) check() error {
+	bound, err := boundVariables(q.body, q.builtins)
+	if err != nil {
+		return err
+	}
+	for _, a := range q.body {
+		if isLiteral(a.p) {
+			return fmt.Errorf("literal %v in p position", a.p)
+		}
+		if a.neg {
+			for _, v := range atomVariables(&a) {
+				if !bound[v] {
+					return fmt.Errorf("variable %s of negated atom is not bound by a positive atom", v)
+				}
+			}
+		}
+	}
+	for _, v := range q.vars {
+		if !bound[v] {
+			return fmt.Errorf("projected variable %s is not bound by the body", v)
+		}
+	}
+	for _, k := range q.orderBy {
+		if !bound[k.v] {
+			return fmt.Errorf("variable %s to order by is not bound by the body", k.v)
+		}
+	}
+	if q.limit < 0 || q.offset < 0 {
+		return fmt.Errorf("negative limit or offset")
+	}
+	return nil
+}
+
+// variables returns the projected variables of q
+func (q *Query) variables() []Variable {
+	if len(q.vars) > 0 {
+		return q.vars
+	}
+	bound, _ := boundVariables(q.body, q.builtins)
+	vars := make([]Variable, 0, len(bound))
+	seen := make(map[Variable]bool)
+	add := func(v Variable) {
+		if bound[v] && !seen[v] {
+			seen[v] = true
+			vars = append(vars, v)
+		}
+	}
+	for _, a := range q.body {
+		for _, v := range atomVariables(&a) {
+			add(v)
+		}
+	}
+	for _, b := range q.builtins {
+		if b.out != "" {
+			add(b.out)
+		}
+	}
+	return vars
+}
+
+// orderTerms orders unbound before constants before literals,
+// comparable terms by compareTerms and others by their keys
+func orderTerms(t1, t2 Term) int {
+	rank := func(t Term) int {
+		switch {
+		case t == nil:
+			return 0
+		case isConstant(t):
+			return 1
+		}
+		return 2
+	}
+	if r1, r2 := rank(t1), rank(t2); r1 != r2 || r1 == 0 {
+		return r1 - r2
+	}
+	if c, ok := compareTerms(t1, t2); ok {
+		return c
+	}
+	return strings.Compare(termKey(t1), termKey(t2))
+}
+
+// query evaluates q over db. With sameAs enabled, the solutions are
+// expanded to all aliases, see evalExpanded.
+func (db *Database) query(q *Query) (Rows, error) {
+	if err := q.check(); err != nil {
+		return Rows{}, err
+	}
+
+	var omega Omega
+	if db.sameAs != nil {
+		omega = db.evalExpanded(q)
+	} else {
+		r := Rule{body: q.body, builtins: q.builtins}
+		omega = r.eval(db)
+	}
+	sortOmega(omega, q.orderBy)
+	limit := -1
+	if q.hasLimit {
+		limit = q.limit
+	}
+	return project(omega, q.variables(), q.distinct, limit, q.offset), nil
+}
+
+// evalExpanded evaluates the body of q with sameAs enabled. The
+// positive atoms are joined on representatives and their solutions
+// are expanded to all aliases, see expandMappings, before the builtins
+// and negated atoms are applied, so that these see the aliases.
+func (db *Database) evalExpanded(q *Query) Omega {
+	pos, neg := make([]Atom, 0, len(q.body)), make([]Atom, 0)
+	for _, a := range q.body {
+		if a.neg {
+			neg = append(neg, a)
+		} else {
+			pos = append(pos, a)
+		}
+	}
+
+	r := Rule{body: pos}
+	omega := db.expandMappings(r.eval(db), pos)
+
+	bound, _ := boundVariables(pos, nil)
+	todo := make([]Builtin, len(q.builtins))
+	copy(todo, q.builtins)
+	for progress := true; progress && len(todo) > 0; {
+		progress = false
+		for i := 0; i < len(todo); i++ {
+			if b := todo[i]; b.ready(bound) {
+				omega = b.filter(omega)
+				if b.out != "" {
+					bound[b.out] = true
+				}
+				todo = append(todo[:i], todo[i+1:]...)
+				i--
+				progress = true
+			}
+		}
+	}
+
+	for _, a := range neg {
+		omega = db.filterNeg(omega, &a)
+	}
+	return omega
+}
+
+// sortOmega sorts omega stably by keys
+func sortOmega(omega Omega, keys []orderKey) {
+	if len(keys) == 0 {
+		return
+	}
+	sort.SliceStable(omega, func(i, j int) bool {
+		for _, k := range keys {
+			c := orderTerms(omega[i][k.v], omega[j][k.v])
+			if k.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// project turns omega into rows of the terms bound to vars, without
+// repetitions if distinct, skipping offset rows and at most limit
+// rows unless limit is negative
+func project(omega Omega, vars []Variable, distinct bool, limit, offset int) Rows {
+	rows := Rows{vars: vars, rows: make([][]Term, 0, len(omega))}
+	seen := make(map[string]bool)
+	skip := offset
+	for _, mu := range omega {
+		if distinct {
+			k := muKey(&mu, vars)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if limit >= 0 && len(rows.rows) == limit {
+			break
+		}
+		row := make([]Term, len(vars))
+		for i, v := range vars {
+			row[i] = mu[v]
+		}
+		rows.rows = append(rows.rows, row)
+	}
+	return rows
+}
+
+// String prints the rows as a table separated by tabs, the variables
+// in the first line
+func (rows *Rows) String() string {
+	var b strings.Builder
+	for i, v := range rows.vars {
+		if i > 0 {
+			b.WriteByte('\t')
+		}
+		b.WriteString(string(v))
+	}
+	b.WriteByte('\n')
+	for _, row := range rows.rows {
+		for i, t := range row {
+			if i > 0 {
+				b.WriteByte('\t')
+			}
+			if t != nil {
+				b.WriteString(fmt.Sprint(t))
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
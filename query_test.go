@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+
+	_, db := mkDatabase()
+	prog := mkStratifiedProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+	db.addAtom(Atom{Constant(":b"), Constant(":weight"), Long(3), false})
+	db.addAtom(Atom{Constant(":c"), Constant(":weight"), Double(1.5), false})
+	db.addAtom(Atom{Constant(":d"), Constant(":weight"), Long(2), false})
+
+	// the body of the rule for :indirect, as a query
+	q := Query{
+		body:    prog[2].body,
+		vars:    []Variable{"?x", "?y"},
+		orderBy: []orderKey{{"?x", false}, {"?y", true}},
+	}
+	rows, err := db.query(&q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `?x	?y
+:a	:d
+:a	:c
+`
+	if rows.String() != expected {
+		t.Errorf("wrong rows:\n%s\nexpected:\n%s", &rows, expected)
+	}
+	if len(rows.rows) != len(db.rel(":indirect")) {
+		t.Error("query and rule differ")
+	}
+
+	// nodes reachable from :a by weight, heaviest first
+	q = Query{
+		body: []Atom{
+			newAtom(":a", ":reachable", "?y"),
+			newAtom("?y", ":weight", "?w")},
+		builtins: []Builtin{mustBuiltin(">", "", Variable("?w"), Long(1))},
+		orderBy:  []orderKey{{"?w", true}},
+	}
+	rows, err = db.query(&q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `?y	?w
+:b	3
+:d	2
+:c	1.5
+`
+	if rows.String() != expected {
+		t.Errorf("wrong rows:\n%s\nexpected:\n%s", &rows, expected)
+	}
+
+	// the sources of links, without repetitions, paged
+	q = Query{
+		body:     []Atom{newAtom("?x", ":link", "?y")},
+		vars:     []Variable{"?x"},
+		distinct: true,
+		orderBy:  []orderKey{{"?x", false}},
+		limit:    2,
+		offset:   1,
+		hasLimit: true,
+	}
+	rows, err = db.query(&q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected = "?x\n:b\n:c\n"; rows.String() != expected {
+		t.Errorf("wrong rows:\n%s\nexpected:\n%s", &rows, expected)
+	}
+	q.distinct = false
+	if rows, _ = db.query(&q); len(rows.rows) != 2 || rows.rows[0][0] != Constant(":b") || rows.rows[1][0] != Constant(":b") {
+		t.Errorf("repetitions dropped:\n%s", &rows)
+	}
+	q.limit = 0
+	if rows, _ = db.query(&q); len(rows.rows) != 0 {
+		t.Errorf("rows beyond limit 0:\n%s", &rows)
+	}
+
+	for _, q := range []Query{
+		{body: []Atom{newAtom("?x", ":link", "?y")}, vars: []Variable{"?z"}},
+		{body: []Atom{newAtom("?x", ":link", "?y"), newNegAtom("?x", ":reachable", "?z")}},
+		{body: []Atom{newAtom("?x", ":link", "?y")}, orderBy: []orderKey{{"?z", false}}},
+		{body: []Atom{newAtom("?x", ":link", "?y")}, builtins: []Builtin{mustBuiltin(">", "", Variable("?z"), Long(1))}},
+		{body: []Atom{newAtom("?x", ":link", "?y")}, limit: -1, hasLimit: true},
+	} {
+		if _, err := db.query(&q); err == nil {
+			t.Error("unsafe query accepted", q)
+		}
+	}
+}
+
+func TestQuerySameAs(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		@prefix owl: <http://www.w3.org/2002/07/owl#> .
+		:a :link :b .
+		:b :link :c .
+		:a owl:sameAs :a2 .
+		:c owl:sameAs :c2 .
+	`)
+	db.enableSameAs()
+
+	// the join is on representatives, the solutions are expanded to all
+	// aliases
+	q := Query{
+		body:    []Atom{newAtom("?x", ":link", "?y"), newAtom("?y", ":link", "?z")},
+		vars:    []Variable{"?x", "?z"},
+		orderBy: []orderKey{{"?x", false}, {"?z", false}},
+	}
+	rows, err := db.query(&q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "?x\t?z\n:a\t:c\n:a\t:c2\n:a2\t:c\n:a2\t:c2\n"; rows.String() != expected {
+		t.Errorf("wrong rows:\n%s\nexpected:\n%s", &rows, expected)
+	}
+
+	// builtins see the aliases, not only the representatives
+	q.builtins = []Builtin{mustBuiltin("=", "", Variable("?z"), Constant(":c2")), mustBuiltin("!=", "", Variable("?x"), Constant(":a"))}
+	if rows, err = db.query(&q); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "?x\t?z\n:a2\t:c2\n"; rows.String() != expected {
+		t.Errorf("wrong rows:\n%s\nexpected:\n%s", &rows, expected)
+	}
+}
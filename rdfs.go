@@ -0,0 +1,36 @@
+package main
+
+// rdfsRules are the rdfs entailment rules rdfs2, 3, 5, 7, 9 and 11
+// (https://www.w3.org/TR/rdf11-mt/#patterns-of-rdfs-entailment-informative).
+// The axiomatic triples and the rules on containers and datatypes are
+// left out, they only add facts about the rdf and rdfs vocabulary.
+const rdfsRules = `
+@prefix rdf: <` + rdfNs + `> .
+@prefix rdfs: <` + rdfsNs + `> .
+
+# rdfs2
+?x rdf:type ?c :- ?p rdfs:domain ?c, ?x ?p ?y.
+# rdfs3
+?y rdf:type ?c :- ?p rdfs:range ?c, ?x ?p ?y.
+# rdfs5
+?p rdfs:subPropertyOf ?r :- ?p rdfs:subPropertyOf ?q, ?q rdfs:subPropertyOf ?r.
+# rdfs7
+?x ?q ?y :- ?p rdfs:subPropertyOf ?q, ?x ?p ?y.
+# rdfs9
+?x rdf:type ?c :- ?b rdfs:subClassOf ?c, ?x rdf:type ?b.
+# rdfs11
+?a rdfs:subClassOf ?c :- ?a rdfs:subClassOf ?b, ?b rdfs:subClassOf ?c.
+`
+
+// rdfsProgram returns the rdfs entailment rules as Program. Register
+// it in a database holding the (e.g. loaded) ontology and data, the
+// entailed triples are added as derived facts of the relations they
+// belong to.
+func rdfsProgram() Program {
+	db := newDatabase()
+	prog, err := parseProgramString(rdfsRules, &db)
+	if err != nil {
+		panic(err.Error())
+	}
+	return prog
+}
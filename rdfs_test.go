@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+const rdfsOntology = `
+@prefix rdf: <http://www.w3.org/1999/02/22-rdf-syntax-ns#> .
+@prefix rdfs: <http://www.w3.org/2000/01/rdf-schema#> .
+
+:Student rdfs:subClassOf :Person .
+:Person rdfs:subClassOf :Agent .
+:teaches rdfs:domain :Teacher ; rdfs:range :Course .
+:headOf rdfs:subPropertyOf :worksFor .
+:worksFor rdfs:subPropertyOf :memberOf .
+:memberOf rdfs:range :Organization .
+
+:ann a :Student .
+:bob :teaches :db101 .
+:bob :headOf :cs .
+:bob :age 42 .
+`
+
+// rdfsClosure is the closure of rdfsOntology without the ontology
+// itself
+const rdfsClosure = `
+@prefix rdf: <http://www.w3.org/1999/02/22-rdf-syntax-ns#> .
+@prefix rdfs: <http://www.w3.org/2000/01/rdf-schema#> .
+
+:Student rdfs:subClassOf :Agent .
+:headOf rdfs:subPropertyOf :memberOf .
+
+:ann a :Person , :Agent .
+:bob a :Teacher .
+:db101 a :Course .
+:bob :worksFor :cs ; :memberOf :cs .
+:cs a :Organization .
+`
+
+// allFacts returns the facts of db in N-Triples syntax, sorted
+func allFacts(db *Database) []string {
+	facts := make([]string, 0)
+	for _, mu := range db.findMappingsFor(&Atom{Variable("?s"), Variable("?p"), Variable("?o"), false}) {
+		facts = append(facts, termToNT(mu["?s"])+" "+termToNT(mu["?p"])+" "+termToNT(mu["?o"]))
+	}
+	sort.Strings(facts)
+	return facts
+}
+
+func loadTestTurtle(t *testing.T, srcs ...string) Database {
+	db := newDatabase()
+	for _, src := range srcs {
+		if _, err := loadTurtle(strings.NewReader(src), &db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestRdfsClosure(t *testing.T) {
+
+	db := loadTestTurtle(t, rdfsOntology)
+	expected := loadTestTurtle(t, rdfsOntology, rdfsClosure)
+
+	prog := rdfsProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+
+	facts, expectedFacts := allFacts(&db), allFacts(&expected)
+	if strings.Join(facts, "\n") != strings.Join(expectedFacts, "\n") {
+		t.Errorf("wrong closure:\n%s\nexpected:\n%s", strings.Join(facts, "\n"), strings.Join(expectedFacts, "\n"))
+	}
+}
+
+func TestRdfsDRed(t *testing.T) {
+
+	db := loadTestTurtle(t, rdfsOntology)
+	prog := rdfsProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+
+	// without :worksFor below :memberOf, :cs is no :Organization
+	// anymore. :Student rdfs:subClassOf :Agent was derived, deleting
+	// it as base fact must not keep :ann an :Agent.
+	del := loadTestTurtle(t, `
+		@prefix rdfs: <http://www.w3.org/2000/01/rdf-schema#> .
+		:worksFor rdfs:subPropertyOf :memberOf .
+		:Person rdfs:subClassOf :Agent .
+		:Student rdfs:subClassOf :Agent .
+	`)
+	dRed(&db, &del, &prog)
+
+	expected := loadTestTurtle(t, rdfsOntology)
+	expected.remove(&del)
+	prog.register(&expected)
+	prog.evalSeminaive(&expected)
+
+	if strings.Join(allFacts(&db), "\n") != strings.Join(allFacts(&expected), "\n") {
+		t.Errorf("wrong closure after delete:\n%s\nexpected:\n%s",
+			strings.Join(allFacts(&db), "\n"), strings.Join(allFacts(&expected), "\n"))
+	}
+
+	rdfType := iriToConstant(rdfNs + "type")
+	if db.knows(Atom{Constant(":cs"), rdfType, Constant(":Organization"), false}) ||
+		db.knows(Atom{Constant(":ann"), rdfType, Constant(":Agent"), false}) {
+		t.Error("types were not deleted")
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Provenance semirings (Green, Karvounarakis, Tannen 2007). Annotated
+// evaluation computes the facts of a program and annotates each with a
+// value of a semiring: base facts carry given annotations, a rule
+// instance multiplies the annotations of its body facts, the join, and
+// the annotation of a fact is the sum over its base annotation and its
+// rule instances. Negated atoms, builtins and aggregates contribute
+// one, they only decide which instances exist.
+
+// Semiring defines the annotations of annotated evaluation
+type Semiring struct {
+	name        string
+	zero, one   interface{}
+	plus, times func(x, y interface{}) interface{}
+	equal       func(x, y interface{}) bool
+	// fact returns the annotation of a base fact if none is given
+	fact func(t triple) interface{}
+	// stable semirings reach a fixpoint over cyclic derivations, e.g.
+	// idempotent ones, others would sum infinitely many derivations
+	stable bool
+}
+
+func (sr *Semiring) String() string {
+	return sr.name
+}
+
+func equalValues(x, y interface{}) bool {
+	return x == y
+}
+
+var booleanSemiring = &Semiring{
+	name:   "boolean",
+	zero:   false,
+	one:    true,
+	plus:   func(x, y interface{}) interface{} { return x.(bool) || y.(bool) },
+	times:  func(x, y interface{}) interface{} { return x.(bool) && y.(bool) },
+	equal:  equalValues,
+	fact:   func(t triple) interface{} { return true },
+	stable: true,
+}
+
+// countingSemiring counts the derivations of each fact
+var countingSemiring = &Semiring{
+	name:  "counting",
+	zero:  0,
+	one:   1,
+	plus:  func(x, y interface{}) interface{} { return x.(int) + y.(int) },
+	times: func(x, y interface{}) interface{} { return x.(int) * y.(int) },
+	equal: equalValues,
+	fact:  func(t triple) interface{} { return 1 },
+}
+
+// tropicalSemiring computes the cost of the cheapest derivation, base
+// facts must not have negative costs on cycles
+var tropicalSemiring = &Semiring{
+	name:   "tropical",
+	zero:   math.Inf(1),
+	one:    0.0,
+	plus:   func(x, y interface{}) interface{} { return math.Min(x.(float64), y.(float64)) },
+	times:  func(x, y interface{}) interface{} { return x.(float64) + y.(float64) },
+	equal:  equalValues,
+	fact:   func(t triple) interface{} { return 0.0 },
+	stable: true,
+}
+
+// probabilitySemiring computes the probability of the most likely
+// derivation of a fact, given probabilities of its base facts
+var probabilitySemiring = &Semiring{
+	name:   "probability",
+	zero:   0.0,
+	one:    1.0,
+	plus:   func(x, y interface{}) interface{} { return math.Max(x.(float64), y.(float64)) },
+	times:  func(x, y interface{}) interface{} { return x.(float64) * y.(float64) },
+	equal:  equalValues,
+	fact:   func(t triple) interface{} { return 1.0 },
+	stable: true,
+}
+
+// lineage is the set of base facts used by some derivation, nil
+// stands for no derivation
+type lineage map[triple]bool
+
+func (l lineage) union(l_ lineage) lineage {
+	u := make(lineage, len(l)+len(l_))
+	for t := range l {
+		u[t] = true
+	}
+	for t := range l_ {
+		u[t] = true
+	}
+	return u
+}
+
+func (l lineage) String() string {
+	as := make([]string, 0, len(l))
+	for t := range l {
+		a := t.toAtom()
+		as = append(as, atomString(&a))
+	}
+	sort.Strings(as)
+	return "{" + strings.Join(as, ", ") + "}"
+}
+
+var lineageSemiring = &Semiring{
+	name: "lineage",
+	zero: lineage(nil),
+	one:  lineage{},
+	plus: func(x, y interface{}) interface{} {
+		if x.(lineage) == nil {
+			return y
+		}
+		if y.(lineage) == nil {
+			return x
+		}
+		return x.(lineage).union(y.(lineage))
+	},
+	times: func(x, y interface{}) interface{} {
+		if x.(lineage) == nil || y.(lineage) == nil {
+			return lineage(nil)
+		}
+		return x.(lineage).union(y.(lineage))
+	},
+	equal: func(x, y interface{}) bool {
+		l, l_ := x.(lineage), y.(lineage)
+		if (l == nil) != (l_ == nil) || len(l) != len(l_) {
+			return false
+		}
+		for t := range l {
+			if !l_[t] {
+				return false
+			}
+		}
+		return true
+	},
+	fact:   func(t triple) interface{} { return lineage{t: true} },
+	stable: true,
+}
+
+// polynomial is a provenance polynomial over the base facts, mapping
+// monomials to their coefficients. A monomial lists the keys of its
+// facts sorted, with repetitions, see monomialKey.
+type polynomial map[string]int
+
+func monomialKey(t triple) string {
+	return fmt.Sprintf("%d.%d.%d", t[0], t[1], t[2])
+}
+
+func monomialTriple(key string) triple {
+	var t triple
+	for i, s := range strings.Split(key, ".") {
+		n, _ := strconv.ParseUint(s, 10, 32)
+		t[i] = termID(n)
+	}
+	return t
+}
+
+func multiplyMonomials(m, m_ string) string {
+	ks := make([]string, 0)
+	for _, m := range []string{m, m_} {
+		if m != "" {
+			ks = append(ks, strings.Split(m, "*")...)
+		}
+	}
+	sort.Strings(ks)
+	return strings.Join(ks, "*")
+}
+
+func (p polynomial) String() string {
+	if len(p) == 0 {
+		return "0"
+	}
+	terms := make([]string, 0, len(p))
+	for m, c := range p {
+		factors := make([]string, 0)
+		if c != 1 || m == "" {
+			factors = append(factors, strconv.Itoa(c))
+		}
+		if m != "" {
+			for _, k := range strings.Split(m, "*") {
+				a := monomialTriple(k).toAtom()
+				factors = append(factors, "["+atomString(&a)+"]")
+			}
+		}
+		terms = append(terms, strings.Join(factors, "*"))
+	}
+	sort.Strings(terms)
+	return strings.Join(terms, " + ")
+}
+
+var polynomialSemiring = &Semiring{
+	name: "polynomial",
+	zero: polynomial{},
+	one:  polynomial{"": 1},
+	plus: func(x, y interface{}) interface{} {
+		p := make(polynomial)
+		for _, q := range []polynomial{x.(polynomial), y.(polynomial)} {
+			for m, c := range q {
+				p[m] += c
+			}
+		}
+		return p
+	},
+	times: func(x, y interface{}) interface{} {
+		p := make(polynomial)
+		for m, c := range x.(polynomial) {
+			for m_, c_ := range y.(polynomial) {
+				p[multiplyMonomials(m, m_)] += c * c_
+			}
+		}
+		return p
+	},
+	equal: func(x, y interface{}) bool {
+		p, p_ := x.(polynomial), y.(polynomial)
+		if len(p) != len(p_) {
+			return false
+		}
+		for m, c := range p {
+			if p_[m] != c {
+				return false
+			}
+		}
+		return true
+	},
+	fact: func(t triple) interface{} { return polynomial{monomialKey(t): 1} },
+}
+
+// annotations holds the annotations of the facts of a database
+type annotations struct {
+	db     *Database
+	sr     *Semiring
+	values map[triple]interface{}
+}
+
+// get returns the annotation of a, zero if a is unknown
+func (ann *annotations) get(a Atom) interface{} {
+	if !a.isGround() || !isConstant(a.p) {
+		return ann.sr.zero
+	}
+	t, ok := lookupAtom(&a)
+	if !ok {
+		return ann.sr.zero
+	}
+	return ann.value(t)
+}
+
+func (ann *annotations) value(t triple) interface{} {
+	if v, ok := ann.values[t]; ok {
+		return v
+	}
+	return ann.sr.zero
+}
+
+// annotationDelta holds the facts whose annotations changed in a
+// seminaive iteration, what was added to them and what they were
+// before
+type annotationDelta struct {
+	db         Database
+	added, old map[triple]interface{}
+}
+
+// instance returns the annotation of the instance of r given by mu,
+// the product of the annotations of its positive body facts. Given a
+// delta, the body atom at pos takes the annotation added by delta and
+// the atoms before it the annotations before delta: summed over all
+// positions, these are exactly the annotations delta adds to the
+// instances.
+func (ann *annotations) instance(r *Rule, mu *Mu, delta *annotationDelta, pos int) interface{} {
+	p := ann.sr.one
+	if r.agg != nil {
+		return p
+	}
+	for j, a := range r.body {
+		if a.neg {
+			continue
+		}
+		a_ := a.bind(mu)
+		t := encodeAtom(&a_)
+		v := ann.value(t)
+		if delta != nil {
+			if j == pos {
+				v = delta.added[t]
+			} else if old, ok := delta.old[t]; ok && j < pos {
+				v = old
+			}
+		}
+		p = ann.sr.times(p, v)
+	}
+	return p
+}
+
+// add adds the annotations of the instances of r in omega to sum
+func (ann *annotations) add(sum map[triple]interface{}, r *Rule, omega Omega, delta *annotationDelta, pos int) {
+	for _, mu := range omega.distinct() {
+		ann.db.recordDerivation(r, &mu)
+		h := r.head.applyMapping(&mu)
+		if !h.isStorable() {
+			continue
+		}
+		t := encodeAtom(&h)
+		v, ok := sum[t]
+		if !ok {
+			v = ann.sr.zero
+		}
+		sum[t] = ann.sr.plus(v, ann.instance(r, &mu, delta, pos))
+	}
+}
+
+// update adds the annotations of sum to the facts of db and returns
+// the facts whose annotations changed. For stable semirings, which
+// are idempotent, an annotation that does not change the annotation
+// of its fact does not change the annotations derived from it either.
+func (ann *annotations) update(sum map[triple]interface{}) annotationDelta {
+	delta := annotationDelta{db: ann.db.shallowCopy(), added: make(map[triple]interface{}), old: make(map[triple]interface{})}
+	for t, v := range sum {
+		a := t.toAtom()
+		if !ann.db.knows(a) {
+			ann.db.addDerived(a)
+		}
+		old := ann.value(t)
+		new := ann.sr.plus(old, v)
+		if ann.sr.stable && ann.sr.equal(old, new) || !ann.sr.stable && ann.sr.equal(v, ann.sr.zero) {
+			continue
+		}
+		ann.values[t] = new
+		delta.added[t] = v
+		delta.old[t] = old
+		delta.db.addDerived(a)
+	}
+	return delta
+}
+
+// evalAnnotated evaluates prog on db and annotates all facts of db by
+// sr. Base facts are annotated by base, or by sr if base is nil or
+// returns nil. The facts prog derives are derived again, seminaive
+// stratum by stratum, each iteration adding the annotations of the
+// instances that use facts whose annotations changed in the previous
+// one. Over cyclic derivations only stable semirings converge. sameAs
+// is not supported.
+func (prog *Program) evalAnnotated(db *Database, sr *Semiring, base func(a Atom) interface{}) (annotations, error) {
+	ann := annotations{db: db, sr: sr, values: make(map[triple]interface{})}
+	if db.sameAs != nil {
+		return ann, fmt.Errorf("%s annotations are not supported with sameAs", sr)
+	}
+	strata, err := prog.stratify()
+	if err != nil {
+		return ann, err
+	}
+
+	db.dropCounts(prog)
+	for _, r := range *prog {
+		if isVariable(r.head.p) {
+			db.clearIdb()
+			break
+		}
+		db.clearIdbRel(r.head.p.(Constant))
+	}
+
+	for _, rels := range []map[Constant][]triple{db.edb, db.idb} {
+		for _, rel := range rels {
+			for _, t := range rel {
+				if _, ok := ann.values[t]; ok {
+					continue
+				}
+				var v interface{}
+				if base != nil {
+					v = base(t.toAtom())
+				}
+				if v == nil {
+					v = sr.fact(t)
+				}
+				ann.values[t] = v
+			}
+		}
+	}
+
+	for _, s := range strata {
+		dprog := s.toDeltaProgram(db, false)
+
+		sum := make(map[triple]interface{})
+		for i, r := range s {
+			ann.add(sum, &s[i], r.eval(db), nil, 0)
+		}
+		delta := ann.update(sum)
+
+		for iterations := 0; !delta.db.empty(); iterations++ {
+			// without cycles, an annotation changes in at most as many
+			// iterations as there are facts
+			n := db.size() + 1
+			if !sr.stable && iterations > n {
+				for t := range delta.added {
+					a := t.toAtom()
+					return ann, fmt.Errorf("%s has infinitely many derivations, %s annotations do not converge", atomString(&a), sr)
+				}
+			}
+			if iterations > n*n {
+				return ann, fmt.Errorf("%s annotations do not converge", sr)
+			}
+
+			sum = make(map[triple]interface{})
+			for _, dr := range dprog.drules {
+				ann.add(sum, dr.rule, dr.eval(db, &delta.db), &delta, dr.pos)
+			}
+			delta = ann.update(sum)
+		}
+	}
+	return ann, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSemiringCheapestPath(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		:a :link :b , :c .
+		:b :link :d .
+		:c :link :d .
+		:d :link :a .
+	`)
+	cost := map[string]float64{
+		":a :b": 1, ":b :d": 5,
+		":a :c": 2, ":c :d": 1,
+		":d :a": 1,
+	}
+	prog := mkProgram()
+	prog.register(&db)
+
+	ann, err := prog.evalAnnotated(&db, tropicalSemiring, func(a Atom) interface{} {
+		return cost[fmt.Sprintf("%v %v", a.s, a.o)]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for goal, expected := range map[string]float64{
+		":a :d": 3, ":a :a": 4, ":b :a": 6, ":d :d": 4, ":c :b": 3,
+	} {
+		var s, o string
+		fmt.Sscan(goal, &s, &o)
+		if c := ann.get(newAtom(s, ":reachable", o)); c != expected {
+			t.Errorf("cost of %s: %v, expected %v", goal, c, expected)
+		}
+	}
+	if c := ann.get(newAtom(":a", ":reachable", ":x")); c != tropicalSemiring.zero {
+		t.Error("unknown fact has cost", c)
+	}
+}
+
+func TestSemirings(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		:a :link :b , :c .
+		:b :link :d .
+		:c :link :d .
+	`)
+	prog := mkProgram()
+	prog.register(&db)
+	goal := newAtom(":a", ":reachable", ":d")
+
+	prob := map[string]float64{":a :b": 0.5, ":b :d": 0.5, ":a :c": 0.9, ":c :d": 0.5}
+	probability := func(a Atom) interface{} {
+		return prob[fmt.Sprintf("%v %v", a.s, a.o)]
+	}
+
+	for _, c := range []struct {
+		sr       *Semiring
+		base     func(a Atom) interface{}
+		expected string
+	}{
+		{booleanSemiring, nil, "true"},
+		{countingSemiring, nil, "2"},
+		{probabilitySemiring, probability, "0.45"},
+		{lineageSemiring, nil, "{:a :link :b, :a :link :c, :b :link :d, :c :link :d}"},
+		{polynomialSemiring, nil, "[:a :link :b]*[:b :link :d] + [:a :link :c]*[:c :link :d]"},
+	} {
+		db_ := db.deepCopy()
+		ann, err := prog.evalAnnotated(&db_, c.sr, c.base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := fmt.Sprint(ann.get(goal)); v != c.expected {
+			t.Errorf("%s annotation %s, expected %s", c.sr, v, c.expected)
+		}
+	}
+
+	// a cycle gives infinitely many derivations
+	db.addAtom(newAtom(":d", ":link", ":a"))
+	for _, sr := range []*Semiring{countingSemiring, polynomialSemiring} {
+		db_ := db.deepCopy()
+		if _, err := prog.evalAnnotated(&db_, sr, nil); err == nil {
+			t.Errorf("%s annotations over a cycle converged", sr)
+		}
+	}
+	ann, err := prog.evalAnnotated(&db, lineageSemiring, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := fmt.Sprint(ann.get(newAtom(":b", ":reachable", ":b"))); v != "{:a :link :b, :a :link :c, :b :link :d, :c :link :d, :d :link :a}" {
+		t.Error("wrong lineage over cycle", v)
+	}
+}
+
+func TestSemiringSeminaive(t *testing.T) {
+
+	db := newDatabase()
+	prog, err := parseProgramString(`
+		:a :link :b.
+		:b :link :c.
+		:c :link :d.
+		:d :link :e.
+		:b :blocked :yes.
+
+		?x :path ?y :- ?x :link ?y.
+		?x :path ?y :- ?x :path ?z, ?z :path ?y.
+		?x :open ?y :- ?x :path ?y, not ?x :blocked :yes.
+	`, &db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the nonlinear rule derives a path of n links in as many ways as
+	// it can be split into a binary tree
+	for i := 0; i < 2; i++ {
+		ann, err := prog.evalAnnotated(&db, countingSemiring, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range []struct {
+			a        Atom
+			expected int
+		}{
+			{newAtom(":a", ":path", ":b"), 1},
+			{newAtom(":a", ":path", ":c"), 1},
+			{newAtom(":a", ":path", ":d"), 2},
+			{newAtom(":a", ":path", ":e"), 5},
+			{newAtom(":a", ":open", ":e"), 5},
+			{newAtom(":b", ":open", ":e"), 0},
+			{newAtom(":b", ":link", ":c"), 1},
+		} {
+			if n := ann.get(c.a); n != c.expected {
+				t.Errorf("evaluation %d: %s has %v derivations, expected %d", i, atomString(&c.a), n, c.expected)
+			}
+		}
+		if !db.knows(newAtom(":a", ":open", ":e")) || db.knows(newAtom(":b", ":open", ":e")) {
+			t.Error("annotated evaluation derived the wrong facts", decodeRel(db.rel(":open")))
+		}
+	}
+
+	db.enableSameAs()
+	if _, err := prog.evalAnnotated(&db, countingSemiring, nil); err == nil {
+		t.Error("annotations with sameAs should be rejected")
+	}
+}
@@ -0,0 +1,1341 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A subset of SPARQL 1.1 queries over the base and derived facts of a
+// database:
+//
+//	PREFIX ex: <http://example.org/>
+//	SELECT DISTINCT ?x ?n WHERE {
+//		?x a ex:Person ; ex:age ?a .
+//		OPTIONAL { ?x ex:name ?n }
+//		FILTER (?a >= 18 && !bound(?n))
+//	} ORDER BY DESC(?a) LIMIT 10
+//
+// SELECT, ASK and CONSTRUCT queries are supported, their patterns are
+// built from basic graph patterns, groups, OPTIONAL, UNION, MINUS and
+// FILTER, including EXISTS and NOT EXISTS. ORDER BY takes variables
+// only. Blank nodes in patterns act as variables that are never
+// projected. Property paths, expressions in SELECT, aggregates, GRAPH
+// and subqueries are not supported.
+
+const (
+	sparqlSelect = iota
+	sparqlAsk
+	sparqlConstruct
+)
+
+// SparqlQuery is a parsed SPARQL query
+type SparqlQuery struct {
+	form int
+	// vars are the projected variables of SELECT, all variables in
+	// scope of where if empty
+	vars     []Variable
+	distinct bool
+	// template holds the triple patterns of CONSTRUCT
+	template []Atom
+	where    *groupPattern
+	orderBy  []orderKey
+	// limit is the maximal number of solutions, unlimited if negative
+	limit, offset int
+}
+
+// groupPattern is a group graph pattern { ... }. Its elements are
+// joined in order, the filters apply to the result of the group.
+type groupPattern struct {
+	elems   []patternElem
+	filters []*sparqlExpr
+}
+
+const (
+	elemTriples = iota
+	// a nested group, or the alternatives of a UNION
+	elemGroup
+	elemOptional
+	elemMinus
+)
+
+type patternElem struct {
+	kind    int
+	triples []Atom
+	groups  []*groupPattern
+}
+
+// sparqlExpr is a FILTER expression, a variable or constant term if
+// op is empty, otherwise an operator or function applied to args.
+// EXISTS and NOT EXISTS test group instead.
+type sparqlExpr struct {
+	op    string
+	term  Term
+	args  []*sparqlExpr
+	group *groupPattern
+}
+
+// sparqlFunctions maps the supported functions to their arity, regex
+// takes optional flags
+var sparqlFunctions = map[string]int{
+	"bound": 1, "isiri": 1, "isuri": 1, "isblank": 1, "isliteral": 1,
+	"isnumeric": 1, "str": 1, "strlen": 1, "ucase": 1, "lcase": 1,
+	"contains": 2, "strstarts": 2, "strends": 2, "sameterm": 2, "regex": 3,
+}
+
+// blank nodes of patterns become variables with this prefix, which
+// can not be written in a query
+const blankVarPrefix = "?_:"
+
+func isBlankVar(v Variable) bool {
+	return strings.HasPrefix(string(v), blankVarPrefix)
+}
+
+// SPARQL lexer {{{
+
+const (
+	sparqlVar = ttlSparqlBase + 1 + iota
+	sparqlKeyword
+	sparqlOp
+)
+
+// sparqlLexer extends the turtle lexer by variables, braces, operators
+// and keywords
+type sparqlLexer struct {
+	ttlLexer
+}
+
+func (l *sparqlLexer) next() (token, error) {
+
+	l.skipSpace()
+	c, ok := l.peek()
+	if !ok {
+		return l.ttlLexer.next()
+	}
+	tok := token{line: l.line, col: l.col + 1}
+
+	switch {
+	case c == '?' || c == '$':
+		l.read()
+		var sb strings.Builder
+		sb.WriteRune('?')
+		for {
+			c, ok := l.peek()
+			if !ok || !(unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_') {
+				break
+			}
+			l.read()
+			sb.WriteRune(c)
+		}
+		if sb.Len() == 1 {
+			return tok, l.errorf(tok.line, tok.col, "empty variable name")
+		}
+		tok.kind = sparqlVar
+		tok.text = sb.String()
+	case c == '{' || c == '}':
+		l.read()
+		tok.kind = ttlPunct
+		tok.text = string(c)
+	case strings.ContainsRune("*/=!<>&|", c):
+		l.read()
+		if c == '<' && !l.peekOperator() {
+			l.unread(c)
+			return l.ttlLexer.next()
+		}
+		op := string(c)
+		c_, ok := l.peek()
+		switch {
+		case ok && c_ == '=' && strings.ContainsRune("!<>", c):
+			l.read()
+			op += "="
+		case ok && c_ == c && (c == '&' || c == '|'):
+			l.read()
+			op += op
+		case c == '&' || c == '|':
+			return tok, l.errorf(tok.line, tok.col, "unexpected character %q", c)
+		}
+		tok.kind = sparqlOp
+		tok.text = op
+	case c == '+' || c == '-':
+		l.read()
+		if l.peekDigit() {
+			l.unread(c)
+			return l.ttlLexer.next()
+		}
+		tok.kind = sparqlOp
+		tok.text = string(c)
+	case unicode.IsLetter(c):
+		l.read()
+		var sb strings.Builder
+		sb.WriteRune(c)
+		if err := l.readPName(&sb); err != nil {
+			return tok, err
+		}
+		s := sb.String()
+		tok.text = s
+		switch {
+		case strings.ContainsRune(s, ':'):
+			tok.kind = ttlPName
+		case s == "a":
+			tok.kind = ttlA
+		case s == "true" || s == "false":
+			tok.kind = ttlBoolean
+		default:
+			tok.kind = sparqlKeyword
+		}
+	default:
+		return l.ttlLexer.next()
+	}
+
+	return tok, nil
+}
+
+// }}}
+
+// SPARQL parser {{{
+
+// sparqlParser reuses the turtle parser for iris and literals
+type sparqlParser struct {
+	ttlParser
+	// blank node labels map onto variables, per pattern
+	blankVars map[string]Variable
+	nBlanks   int
+}
+
+// parseSparql parses the SPARQL query in r
+func parseSparql(r io.Reader) (*SparqlQuery, error) {
+	p := &sparqlParser{
+		ttlParser: ttlParser{
+			lex:      &sparqlLexer{ttlLexer{*newLexer(r)}},
+			prefixes: map[string]string{"": defaultNamespace},
+		},
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return p.parse()
+}
+
+func (p *sparqlParser) isKeyword(k string) bool {
+	return p.tok.kind == sparqlKeyword && strings.EqualFold(p.tok.text, k)
+}
+
+func (p *sparqlParser) expectKeyword(k string) error {
+	if !p.isKeyword(k) {
+		return p.errorf(p.tok, "expected %s, found %s", k, describeToken(p.tok))
+	}
+	return p.advance()
+}
+
+func (p *sparqlParser) isOp(op string) bool {
+	return p.tok.kind == sparqlOp && p.tok.text == op
+}
+
+func (p *sparqlParser) expectVar() (Variable, error) {
+	t := p.tok
+	if t.kind != sparqlVar {
+		return "", p.errorf(t, "expected variable, found %s", describeToken(t))
+	}
+	return Variable(t.text), p.advance()
+}
+
+// blankVar returns the variable of a blank node, a new one for each
+// anonymous blank node []
+func (p *sparqlParser) blankVar(label string) Variable {
+	if v, ok := p.blankVars[label]; ok && label != "" {
+		return v
+	}
+	p.nBlanks++
+	v := Variable(blankVarPrefix + "b" + strconv.Itoa(p.nBlanks))
+	if label != "" {
+		p.blankVars[label] = v
+	}
+	return v
+}
+
+func (p *sparqlParser) parse() (*SparqlQuery, error) {
+
+	for p.isKeyword("PREFIX") || p.isKeyword("BASE") {
+		prefix := p.isKeyword("PREFIX")
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name := ""
+		if prefix {
+			if p.tok.kind != ttlPName || !strings.HasSuffix(p.tok.text, ":") {
+				return nil, p.errorf(p.tok, "expected prefix name, found %s", describeToken(p.tok))
+			}
+			name = strings.TrimSuffix(p.tok.text, ":")
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+		if p.tok.kind != ttlIRI {
+			return nil, p.errorf(p.tok, "expected iri, found %s", describeToken(p.tok))
+		}
+		if prefix {
+			p.prefixes[name] = p.resolve(p.tok.text)
+		} else {
+			p.base = p.tok.text
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	q := &SparqlQuery{limit: -1}
+	form := p.tok
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case form.kind == sparqlKeyword && strings.EqualFold(form.text, "SELECT"):
+		q.form = sparqlSelect
+		if p.isKeyword("DISTINCT") {
+			q.distinct = true
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+		if p.isOp("*") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			break
+		}
+		for p.tok.kind == sparqlVar {
+			q.vars = append(q.vars, Variable(p.tok.text))
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+		if len(q.vars) == 0 {
+			return nil, p.errorf(p.tok, "expected variables or '*', found %s", describeToken(p.tok))
+		}
+	case form.kind == sparqlKeyword && strings.EqualFold(form.text, "ASK"):
+		q.form = sparqlAsk
+	case form.kind == sparqlKeyword && strings.EqualFold(form.text, "CONSTRUCT"):
+		q.form = sparqlConstruct
+		p.blankVars = make(map[string]Variable)
+		if err := p.expectPunct("{"); err != nil {
+			return nil, err
+		}
+		for !p.isPunct("}") {
+			if len(q.template) > 0 {
+				if err := p.expectPunct("."); err != nil {
+					return nil, err
+				}
+				if p.isPunct("}") {
+					break
+				}
+			}
+			triples, err := p.parseTriples()
+			if err != nil {
+				return nil, err
+			}
+			q.template = append(q.template, triples...)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, p.errorf(form, "expected SELECT, ASK or CONSTRUCT, found %s", describeToken(form))
+	}
+
+	if p.isKeyword("WHERE") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	p.blankVars = make(map[string]Variable)
+	where, err := p.parseGroup()
+	if err != nil {
+		return nil, err
+	}
+	q.where = where
+
+	if err := p.parseModifiers(q); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != ttlEOF {
+		return nil, p.errorf(p.tok, "unexpected %s after query", describeToken(p.tok))
+	}
+	return q, nil
+}
+
+// parseModifiers parses ORDER BY, LIMIT and OFFSET
+func (p *sparqlParser) parseModifiers(q *SparqlQuery) error {
+
+	if p.isKeyword("ORDER") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if err := p.expectKeyword("BY"); err != nil {
+			return err
+		}
+		for {
+			var k orderKey
+			var err error
+			if p.isKeyword("ASC") || p.isKeyword("DESC") {
+				k.desc = p.isKeyword("DESC")
+				if err = p.advance(); err != nil {
+					return err
+				}
+				if err = p.expectPunct("("); err != nil {
+					return err
+				}
+				if k.v, err = p.expectVar(); err != nil {
+					return err
+				}
+				if err = p.expectPunct(")"); err != nil {
+					return err
+				}
+			} else if p.tok.kind == sparqlVar {
+				if k.v, err = p.expectVar(); err != nil {
+					return err
+				}
+			} else {
+				break
+			}
+			q.orderBy = append(q.orderBy, k)
+		}
+		if len(q.orderBy) == 0 {
+			return p.errorf(p.tok, "expected variable to order by, found %s", describeToken(p.tok))
+		}
+	}
+
+	for p.isKeyword("LIMIT") || p.isKeyword("OFFSET") {
+		limit := p.isKeyword("LIMIT")
+		if err := p.advance(); err != nil {
+			return err
+		}
+		t := p.tok
+		n, err := strconv.Atoi(t.text)
+		if t.kind != ttlInteger || err != nil || n < 0 {
+			return p.errorf(t, "expected non-negative integer, found %s", describeToken(t))
+		}
+		if limit {
+			q.limit = n
+		} else {
+			q.offset = n
+		}
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseGroup parses a group graph pattern
+func (p *sparqlParser) parseGroup() (*groupPattern, error) {
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+
+	g := &groupPattern{}
+	// triples need a '.' before further triples
+	needDot := false
+
+	for !p.isPunct("}") {
+		var err error
+		switch {
+		case p.tok.kind == ttlEOF:
+			return nil, p.errorf(p.tok, "unterminated group")
+		case p.isPunct("."):
+			needDot = false
+			err = p.advance()
+		case p.isPunct("{"):
+			needDot = false
+			el := patternElem{kind: elemGroup}
+			for {
+				g_, err := p.parseGroup()
+				if err != nil {
+					return nil, err
+				}
+				el.groups = append(el.groups, g_)
+				if !p.isKeyword("UNION") {
+					break
+				}
+				if err := p.advance(); err != nil {
+					return nil, err
+				}
+			}
+			g.elems = append(g.elems, el)
+		case p.isKeyword("OPTIONAL") || p.isKeyword("MINUS"):
+			needDot = false
+			el := patternElem{kind: elemMinus}
+			if p.isKeyword("OPTIONAL") {
+				el.kind = elemOptional
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			g_, err := p.parseGroup()
+			if err != nil {
+				return nil, err
+			}
+			el.groups = []*groupPattern{g_}
+			g.elems = append(g.elems, el)
+		case p.isKeyword("FILTER"):
+			needDot = false
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			e, err := p.parseConstraint()
+			if err != nil {
+				return nil, err
+			}
+			g.filters = append(g.filters, e)
+		default:
+			if needDot {
+				return nil, p.errorf(p.tok, "expected '.', found %s", describeToken(p.tok))
+			}
+			triples, err := p.parseTriples()
+			if err != nil {
+				return nil, err
+			}
+			// adjacent triples form one basic graph pattern
+			if n := len(g.elems); n > 0 && g.elems[n-1].kind == elemTriples {
+				g.elems[n-1].triples = append(g.elems[n-1].triples, triples...)
+			} else {
+				g.elems = append(g.elems, patternElem{kind: elemTriples, triples: triples})
+			}
+			needDot = true
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return g, p.advance()
+}
+
+// parseTriples parses a subject with its predicate object list
+func (p *sparqlParser) parseTriples() ([]Atom, error) {
+	s, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	triples := make([]Atom, 0)
+	for {
+		var pr Term
+		if p.tok.kind == sparqlVar {
+			pr, err = p.expectVar()
+		} else {
+			pr, err = p.parseIRI()
+		}
+		if err != nil {
+			return nil, err
+		}
+		for {
+			o, err := p.parseTerm()
+			if err != nil {
+				return nil, err
+			}
+			triples = append(triples, Atom{s: s, p: pr, o: o})
+			if !p.isPunct(",") {
+				break
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+		if !p.isPunct(";") {
+			return triples, nil
+		}
+		for p.isPunct(";") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+		if p.isPunct(".") || p.isPunct("}") {
+			return triples, nil
+		}
+	}
+}
+
+// parseTerm parses a subject or object of a triple pattern
+func (p *sparqlParser) parseTerm() (Term, error) {
+	t := p.tok
+	switch t.kind {
+	case sparqlVar:
+		return p.expectVar()
+	case ttlIRI, ttlPName:
+		return p.parseIRI()
+	case ttlBlank:
+		return p.blankVar(t.text), p.advance()
+	case ttlString, ttlInteger, ttlDecimal, ttlDouble, ttlBoolean:
+		return p.parseLiteral()
+	case ttlPunct:
+		if t.text == "[" {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct("]"); err != nil {
+				return nil, err
+			}
+			return p.blankVar(""), nil
+		}
+	}
+	return nil, p.errorf(t, "expected term, found %s", describeToken(t))
+}
+
+// parseConstraint parses the expression of a FILTER, which is either
+// bracketed or a function call
+func (p *sparqlParser) parseConstraint() (*sparqlExpr, error) {
+	switch {
+	case p.isPunct("("):
+		return p.parsePrimary()
+	case p.tok.kind == sparqlKeyword:
+		return p.parseCall()
+	}
+	return nil, p.errorf(p.tok, "expected constraint, found %s", describeToken(p.tok))
+}
+
+func (p *sparqlParser) parseExpr() (*sparqlExpr, error) {
+	return p.parseBinary(0)
+}
+
+// sparqlPrecedence lists the binary operators from the loosest to the
+// tightest binding
+var sparqlPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"=", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/"},
+}
+
+// parseBinary parses the binary operators of level and above. Signed
+// numbers after an operand are additions and subtractions, e.g. ?x -1.
+func (p *sparqlParser) parseBinary(level int) (*sparqlExpr, error) {
+	if level == len(sparqlPrecedence) {
+		return p.parseUnary()
+	}
+
+	e, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := ""
+		for _, op_ := range sparqlPrecedence[level] {
+			if p.isOp(op_) {
+				op = op_
+			}
+		}
+		signed := level == 3 && isSignedNumber(p.tok)
+		if op == "" && !signed {
+			return e, nil
+		}
+		if signed {
+			op = p.tok.text[:1]
+			p.tok.text = p.tok.text[1:]
+		} else if err := p.advance(); err != nil {
+			return nil, err
+		}
+		e_, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		e = &sparqlExpr{op: op, args: []*sparqlExpr{e, e_}}
+		// comparisons do not chain
+		if level == 2 {
+			return e, nil
+		}
+	}
+}
+
+func isSignedNumber(t token) bool {
+	switch t.kind {
+	case ttlInteger, ttlDecimal, ttlDouble:
+		return t.text[0] == '+' || t.text[0] == '-'
+	}
+	return false
+}
+
+func (p *sparqlParser) parseUnary() (*sparqlExpr, error) {
+	op := ""
+	switch {
+	case p.isOp("!"):
+		op = "!"
+	case p.isOp("-"):
+		op = "neg"
+	case p.isOp("+"):
+		op = "pos"
+	default:
+		return p.parsePrimary()
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	e, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &sparqlExpr{op: op, args: []*sparqlExpr{e}}, nil
+}
+
+func (p *sparqlParser) parsePrimary() (*sparqlExpr, error) {
+	t := p.tok
+	switch t.kind {
+	case sparqlVar:
+		return &sparqlExpr{term: Variable(t.text)}, p.advance()
+	case sparqlKeyword:
+		return p.parseCall()
+	case ttlIRI, ttlPName:
+		c, err := p.parseIRI()
+		return &sparqlExpr{term: c}, err
+	case ttlString, ttlInteger, ttlDecimal, ttlDouble, ttlBoolean:
+		l, err := p.parseLiteral()
+		return &sparqlExpr{term: l}, err
+	case ttlPunct:
+		if t.text != "(" {
+			break
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expectPunct(")")
+	}
+	return nil, p.errorf(t, "expected expression, found %s", describeToken(t))
+}
+
+// parseCall parses a function call or (NOT) EXISTS
+func (p *sparqlParser) parseCall() (*sparqlExpr, error) {
+	t := p.tok
+	name := strings.ToLower(t.text)
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if name == "not" || name == "exists" {
+		if name == "not" {
+			if err := p.expectKeyword("EXISTS"); err != nil {
+				return nil, err
+			}
+			name = "not exists"
+		}
+		g, err := p.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		return &sparqlExpr{op: name, group: g}, nil
+	}
+
+	arity, ok := sparqlFunctions[name]
+	if !ok {
+		return nil, p.errorf(t, "unknown function %s", t.text)
+	}
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	e := &sparqlExpr{op: name}
+	for !p.isPunct(")") {
+		if len(e.args) > 0 {
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		e.args = append(e.args, arg)
+	}
+	if n := len(e.args); n != arity && !(name == "regex" && n == 2) {
+		return nil, p.errorf(t, "%s expects %d arguments", t.text, arity)
+	}
+	if name == "bound" && (e.args[0].op != "" || !isVariable(e.args[0].term)) {
+		return nil, p.errorf(t, "bound expects a variable")
+	}
+	return e, p.advance()
+}
+
+// variables returns the variables in scope of g in order of their
+// appearance, without those of blank nodes
+func (g *groupPattern) variables() []Variable {
+	vars := make([]Variable, 0)
+	seen := make(map[Variable]bool)
+	var walk func(g *groupPattern)
+	walk = func(g *groupPattern) {
+		for _, el := range g.elems {
+			switch el.kind {
+			case elemTriples:
+				for _, a := range el.triples {
+					for _, v := range atomVariables(&a) {
+						if !seen[v] && !isBlankVar(v) {
+							seen[v] = true
+							vars = append(vars, v)
+						}
+					}
+				}
+			case elemGroup, elemOptional:
+				for _, g_ := range el.groups {
+					walk(g_)
+				}
+			}
+		}
+	}
+	walk(g)
+	return vars
+}
+
+// }}}
+
+// SPARQL evaluation {{{
+
+var (
+	sparqlTrue  = Constant(ntLiteral("true", "", xsdNs+"boolean"))
+	sparqlFalse = Constant(ntLiteral("false", "", xsdNs+"boolean"))
+)
+
+func sparqlBool(b bool) Term {
+	if b {
+		return sparqlTrue
+	}
+	return sparqlFalse
+}
+
+// SparqlResult is the result of a SPARQL query: rows for SELECT, a
+// boolean for ASK and a graph of base facts for CONSTRUCT
+type SparqlResult struct {
+	form    int
+	rows    Rows
+	boolean bool
+	graph   Database
+}
+
+// sparql evaluates q over db
+func (db *Database) sparql(q *SparqlQuery) SparqlResult {
+	omega := q.where.eval(db, Omega{Mu{}})
+	res := SparqlResult{form: q.form}
+
+	if q.form == sparqlAsk {
+		res.boolean = len(omega) > 0
+		return res
+	}
+
+	sortOmega(omega, q.orderBy)
+
+	if q.form == sparqlSelect {
+		vars := q.vars
+		if len(vars) == 0 {
+			vars = q.where.variables()
+		}
+		res.rows = project(omega, vars, q.distinct, q.limit, q.offset)
+		return res
+	}
+
+	if q.offset < len(omega) {
+		omega = omega[q.offset:]
+	} else {
+		omega = nil
+	}
+	if q.limit >= 0 && q.limit < len(omega) {
+		omega = omega[:q.limit]
+	}
+
+	res.graph = newDatabase()
+	for _, mu := range omega {
+		// template blank nodes are fresh for each solution
+		blanks := make(Mu)
+		for _, a := range q.template {
+			a_ := a.bind(&mu)
+			for _, v := range atomVariables(&a_) {
+				if isBlankVar(v) {
+					if _, ok := blanks[v]; !ok {
+						blanks[v] = freshBlank()
+					}
+				}
+			}
+			a_ = a_.bind(&blanks)
+			if a_.isGround() && a_.isStorable() && !res.graph.knows(a_) {
+				res.graph.addAtom(a_)
+			}
+		}
+	}
+	return res
+}
+
+// eval evaluates g for the mappings of seed, i.e. joins seed with the
+// solutions of g before the filters of g are applied
+func (g *groupPattern) eval(db *Database, seed Omega) Omega {
+	omega := g.evalElems(db, seed)
+	for _, f := range g.filters {
+		omega = f.filter(db, omega)
+	}
+	return omega
+}
+
+// evalElems evaluates the elements of g, but not its filters. Nested
+// groups are evaluated on their own and joined.
+func (g *groupPattern) evalElems(db *Database, seed Omega) Omega {
+	omega := seed
+	for _, el := range g.elems {
+		switch el.kind {
+		case elemTriples:
+			omega = db.evalBGP(el.triples, omega)
+		case elemGroup:
+			union := make(Omega, 0)
+			for _, g_ := range el.groups {
+				union = append(union, g_.eval(db, Omega{Mu{}})...)
+			}
+			omega = omega.join(&union)
+		case elemOptional:
+			g_ := el.groups[0]
+			right := g_.evalElems(db, Omega{Mu{}})
+			omega = omega.leftJoin(&right, func(mu Mu) bool {
+				for _, f := range g_.filters {
+					if b, ok := f.test(db, mu); !ok || !b {
+						return false
+					}
+				}
+				return true
+			})
+		case elemMinus:
+			right := el.groups[0].eval(db, Omega{Mu{}})
+			omega = omega.minus(&right)
+		}
+	}
+	return omega
+}
+
+// evalBGP joins omega with the mappings of the triple patterns of bgp.
+// With sameAs enabled, the patterns are joined on representatives and
+// their solutions are expanded to all aliases before they are joined
+// with omega, see expandMappings.
+func (db *Database) evalBGP(bgp []Atom, omega Omega) Omega {
+	if db.sameAs == nil {
+		return db.joinBGP(bgp, omega)
+	}
+	o := db.expandMappings(db.joinBGP(bgp, Omega{Mu{}}), bgp)
+	return omega.join(&o)
+}
+
+// joinBGP joins omega with the mappings of the triple patterns of bgp
+// in turn. A single mapping, e.g. the one EXISTS is tested for, is
+// substituted into the patterns so that the indexes apply.
+func (db *Database) joinBGP(bgp []Atom, omega Omega) Omega {
+	for _, a := range bgp {
+		if len(omega) == 0 {
+			break
+		}
+		var o Omega
+		if len(omega) == 1 {
+			o = db.findMappingsBound(&a, &omega[0])
+		} else {
+			o = db.findMappingsFor(&a)
+		}
+		omega = omega.join(&o)
+	}
+	return omega
+}
+
+// leftJoin extends the mappings of o1 by the compatible mappings of o2
+// for which cond holds, keeping those without such mappings as they
+// are
+func (o1 *Omega) leftJoin(o2 *Omega, cond func(mu Mu) bool) Omega {
+	o3 := make(Omega, 0, len(*o1))
+	for _, mu1 := range *o1 {
+		extended := false
+		for _, mu2 := range *o2 {
+			if !mu1.compatible(&mu2) {
+				continue
+			}
+			if mu := mu1.join(&mu2); cond(mu) {
+				o3 = append(o3, mu)
+				extended = true
+			}
+		}
+		if !extended {
+			o3 = append(o3, mu1)
+		}
+	}
+	return o3
+}
+
+// minus removes the mappings of o1 that are compatible with a mapping
+// of o2 sharing a variable with it. If all mappings of o1 bind the
+// variables of o2 this is joinNeg.
+func (o1 *Omega) minus(o2 *Omega) Omega {
+	o2_ := make(Omega, 0, len(*o2))
+	for _, mu := range *o2 {
+		if len(mu) > 0 {
+			o2_ = append(o2_, mu)
+		}
+	}
+
+	bound := make(map[Variable]bool)
+	for _, v := range o1.domain() {
+		bound[v] = true
+	}
+	covered := len(*o1) > 0
+	for _, mu := range o2_ {
+		for v := range mu {
+			covered = covered && bound[v]
+		}
+	}
+	if covered {
+		return o1.joinNeg(&o2_)
+	}
+
+	o3 := make(Omega, 0, len(*o1))
+	for _, mu1 := range *o1 {
+		keep := true
+		for _, mu2 := range o2_ {
+			if mu1.compatible(&mu2) && mu1.overlaps(&mu2) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			o3 = append(o3, mu1)
+		}
+	}
+	return o3
+}
+
+// overlaps tests if m1 and m2 bind a common variable
+func (m1 *Mu) overlaps(m2 *Mu) bool {
+	for v := range *m2 {
+		if _, ok := (*m1)[v]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filter keeps the mappings of omega for which e is true
+func (e *sparqlExpr) filter(db *Database, omega Omega) Omega {
+	omega_ := make(Omega, 0, len(omega))
+	for _, mu := range omega {
+		if b, ok := e.test(db, mu); ok && b {
+			omega_ = append(omega_, mu)
+		}
+	}
+	return omega_
+}
+
+// test returns the effective boolean value of e on mu, ok is false if
+// it is an error
+func (e *sparqlExpr) test(db *Database, mu Mu) (bool, bool) {
+	t, ok := e.eval(db, mu)
+	if !ok {
+		return false, false
+	}
+	switch l := t.(type) {
+	case Long:
+		return l != 0, true
+	case Double:
+		return l != 0 && !math.IsNaN(float64(l)), true
+	case Decimal:
+		return l != "0.0", true
+	case String:
+		return l != "", true
+	case Constant:
+		switch l {
+		case sparqlTrue:
+			return true, true
+		case sparqlFalse:
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// eval evaluates e on mu. ok is false on errors, e.g. for unbound
+// variables or arguments of the wrong type.
+func (e *sparqlExpr) eval(db *Database, mu Mu) (Term, bool) {
+
+	switch e.op {
+	case "":
+		if isVariable(e.term) {
+			t, ok := mu[e.term.(Variable)]
+			return t, ok
+		}
+		return e.term, true
+	case "||", "&&":
+		// an error on one side is absorbed if the other side decides
+		b1, ok1 := e.args[0].test(db, mu)
+		b2, ok2 := e.args[1].test(db, mu)
+		decides := e.op == "||"
+		if ok1 && b1 == decides || ok2 && b2 == decides {
+			return sparqlBool(decides), true
+		}
+		return sparqlBool(!decides), ok1 && ok2
+	case "!":
+		b, ok := e.args[0].test(db, mu)
+		return sparqlBool(!b), ok
+	case "bound":
+		_, ok := mu[e.args[0].term.(Variable)]
+		return sparqlBool(ok), true
+	case "exists", "not exists":
+		omega := e.group.eval(db, Omega{mu})
+		return sparqlBool((len(omega) > 0) == (e.op == "exists")), true
+	}
+
+	args := make([]Term, len(e.args))
+	for i, a := range e.args {
+		t, ok := a.eval(db, mu)
+		if !ok {
+			return nil, false
+		}
+		args[i] = t
+	}
+
+	switch e.op {
+	case "=":
+		return sparqlBool(valueEqual(args[0], args[1])), true
+	case "!=":
+		return sparqlBool(!valueEqual(args[0], args[1])), true
+	case "<", "<=", ">", ">=":
+		c, ok := compareTerms(args[0], args[1])
+		switch e.op {
+		case "<":
+			return sparqlBool(c < 0), ok
+		case "<=":
+			return sparqlBool(c <= 0), ok
+		case ">":
+			return sparqlBool(c > 0), ok
+		}
+		return sparqlBool(c >= 0), ok
+	case "+", "-", "*", "/":
+		return arith(e.op, args[0], args[1])
+	case "neg":
+		return arith("-", Long(0), args[0])
+	case "pos":
+		_, ok := toFloat(args[0])
+		return args[0], ok
+	case "sameterm":
+		return sparqlBool(termEqual(args[0], args[1])), true
+	case "isiri", "isuri":
+		c, ok := args[0].(Constant)
+		return sparqlBool(ok && !isBlankConstant(c) && !isLiteralConstant(c)), true
+	case "isblank":
+		c, ok := args[0].(Constant)
+		return sparqlBool(ok && isBlankConstant(c)), true
+	case "isliteral":
+		c, ok := args[0].(Constant)
+		return sparqlBool(isLiteral(args[0]) || ok && isLiteralConstant(c)), true
+	case "isnumeric":
+		_, ok := toFloat(args[0])
+		return sparqlBool(ok), true
+	case "str":
+		s, ok := strValue(args[0])
+		return String(s), ok
+	}
+
+	// string functions
+	strs := make([]string, len(args))
+	for i, a := range args {
+		s, ok := a.(String)
+		if !ok {
+			return nil, false
+		}
+		strs[i] = string(s)
+	}
+
+	switch e.op {
+	case "strlen":
+		return Long(utf8.RuneCountInString(strs[0])), true
+	case "ucase":
+		return String(strings.ToUpper(strs[0])), true
+	case "lcase":
+		return String(strings.ToLower(strs[0])), true
+	case "contains":
+		return sparqlBool(strings.Contains(strs[0], strs[1])), true
+	case "strstarts":
+		return sparqlBool(strings.HasPrefix(strs[0], strs[1])), true
+	case "strends":
+		return sparqlBool(strings.HasSuffix(strs[0], strs[1])), true
+	case "regex":
+		pat := strs[1]
+		if len(strs) == 3 && strs[2] != "" {
+			if strings.Trim(strs[2], "ims") != "" {
+				return nil, false
+			}
+			pat = "(?" + strs[2] + ")" + pat
+		}
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return nil, false
+		}
+		return sparqlBool(re.MatchString(strs[0])), true
+	}
+
+	panic("unknown sparql operator " + e.op)
+}
+
+func isBlankConstant(c Constant) bool {
+	return strings.HasPrefix(string(c), "_:")
+}
+
+// isLiteralConstant tests for literals kept as constants, see
+// newLiteral
+func isLiteralConstant(c Constant) bool {
+	return strings.HasPrefix(string(c), "\"")
+}
+
+// splitNTLiteral splits a literal in N-Triples syntax into its lexical
+// form and language tag or datatype iri
+func splitNTLiteral(s string) (lex, lang, datatype string) {
+	i := strings.LastIndexByte(s, '"')
+	// the escapes of ntLiteral are valid go escapes
+	lex, _ = strconv.Unquote(s[:i+1])
+	rest := s[i+1:]
+	switch {
+	case strings.HasPrefix(rest, "@"):
+		lang = rest[1:]
+	case strings.HasPrefix(rest, "^^<"):
+		datatype = rest[3 : len(rest)-1]
+	}
+	return lex, lang, datatype
+}
+
+// strValue returns the lexical form of a literal or the iri of a
+// constant, blank nodes have none
+func strValue(t Term) (string, bool) {
+	if isLiteral(t) {
+		lex, _ := literalLexical(t.(Literal))
+		return lex, true
+	}
+	c := t.(Constant)
+	switch {
+	case isBlankConstant(c):
+		return "", false
+	case isLiteralConstant(c):
+		lex, _, _ := splitNTLiteral(string(c))
+		return lex, true
+	}
+	iri := termToNT(c)
+	return strings.TrimSuffix(strings.TrimPrefix(iri, "<"), ">"), true
+}
+
+// }}}
+
+// SPARQL results {{{
+
+// sparqlJSON is the SPARQL 1.1 query results json format, with
+// results for SELECT and boolean for ASK
+type sparqlJSON struct {
+	Head struct {
+		Vars []string `json:"vars,omitempty"`
+	} `json:"head"`
+	Results *sparqlJSONResults `json:"results,omitempty"`
+	Boolean *bool              `json:"boolean,omitempty"`
+}
+
+type sparqlJSONResults struct {
+	Bindings []map[string]sparqlJSONTerm `json:"bindings"`
+}
+
+type sparqlJSONTerm struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Lang     string `json:"xml:lang,omitempty"`
+	Datatype string `json:"datatype,omitempty"`
+}
+
+func termToSparqlJSON(t Term) sparqlJSONTerm {
+	if isLiteral(t) {
+		lex, datatype := literalLexical(t.(Literal))
+		if datatype == xsdNs+"string" {
+			datatype = ""
+		}
+		return sparqlJSONTerm{Type: "literal", Value: lex, Datatype: datatype}
+	}
+	c := t.(Constant)
+	switch {
+	case isBlankConstant(c):
+		return sparqlJSONTerm{Type: "bnode", Value: string(c[2:])}
+	case isLiteralConstant(c):
+		lex, lang, datatype := splitNTLiteral(string(c))
+		return sparqlJSONTerm{Type: "literal", Value: lex, Lang: lang, Datatype: datatype}
+	}
+	iri, _ := strValue(c)
+	return sparqlJSONTerm{Type: "uri", Value: iri}
+}
+
+// writeJSON writes the result of a SELECT or ASK query to w in the
+// SPARQL json format, unbound variables are left out of the bindings
+func (res *SparqlResult) writeJSON(w io.Writer) error {
+	var out sparqlJSON
+
+	switch res.form {
+	case sparqlAsk:
+		out.Boolean = &res.boolean
+	case sparqlSelect:
+		out.Head.Vars = make([]string, len(res.rows.vars))
+		for i, v := range res.rows.vars {
+			out.Head.Vars[i] = string(v[1:])
+		}
+		out.Results = &sparqlJSONResults{Bindings: make([]map[string]sparqlJSONTerm, 0, len(res.rows.rows))}
+		for _, row := range res.rows.rows {
+			b := make(map[string]sparqlJSONTerm)
+			for i, t := range row {
+				if t != nil {
+					b[out.Head.Vars[i]] = termToSparqlJSON(t)
+				}
+			}
+			out.Results.Bindings = append(out.Results.Bindings, b)
+		}
+	default:
+		return fmt.Errorf("the result of a CONSTRUCT query is a graph, not a result set")
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// writeTSV writes the result of a SELECT query to w in the SPARQL tsv
+// format: the variables in the first line, then a line per row with
+// the terms in N-Triples syntax, integers and decimals abbreviated, and empty
+// fields for unbound variables
+func (res *SparqlResult) writeTSV(w io.Writer) error {
+	if res.form != sparqlSelect {
+		return fmt.Errorf("only the results of SELECT queries can be written as tsv")
+	}
+
+	bw := bufio.NewWriter(w)
+	for i, v := range res.rows.vars {
+		if i > 0 {
+			bw.WriteByte('\t')
+		}
+		bw.WriteString(string(v))
+	}
+	bw.WriteByte('\n')
+	for _, row := range res.rows.rows {
+		for i, t := range row {
+			if i > 0 {
+				bw.WriteByte('\t')
+			}
+			switch t_ := t.(type) {
+			case nil:
+			case Long, Decimal:
+				bw.WriteString(literalShort(t_.(Literal)))
+			default:
+				bw.WriteString(termToNT(t))
+			}
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// }}}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func runTestSparql(t *testing.T, db *Database, src string) SparqlResult {
+	t.Helper()
+	q, err := parseSparql(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db.sparql(q)
+}
+
+func sparqlTSV(t *testing.T, res *SparqlResult) string {
+	t.Helper()
+	var sb strings.Builder
+	if err := res.writeTSV(&sb); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestSparql(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		:a :link :b , :c ; :name "Alice" .
+		:b :link :d ; :name "Bob"@en .
+		:c :link :d ; :age 30 .
+		:d :age 12 .
+	`)
+	prog := mkProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+
+	for _, c := range []struct {
+		query, expected string
+	}{
+		// derived facts
+		{`SELECT ?y WHERE { :a :reachable ?y } ORDER BY DESC(?y)`,
+			"?y\n<urn:contki:d>\n<urn:contki:c>\n<urn:contki:b>\n"},
+		{`SELECT DISTINCT ?x ?n WHERE { ?x :reachable ?y OPTIONAL { ?x :name ?n } } ORDER BY ?x`,
+			"?x\t?n\n<urn:contki:a>\t\"Alice\"\n<urn:contki:b>\t\"Bob\"@en\n<urn:contki:c>\t\n"},
+		// the filter of an optional group may use the outer variables
+		{`SELECT ?x ?a WHERE { ?x :link ?y OPTIONAL { ?y :age ?a FILTER(?a > 18 && ?x = :a) } } ORDER BY ?x ?y`,
+			"?x\t?a\n<urn:contki:a>\t\n<urn:contki:a>\t30\n<urn:contki:b>\t\n<urn:contki:c>\t\n"},
+		{`PREFIX ex: <urn:contki:>
+		  SELECT ?x WHERE { { ?x ex:name ?n } UNION { ?x ex:age ?a FILTER(?a >= 18) } } ORDER BY ?x`,
+			"?x\n<urn:contki:a>\n<urn:contki:b>\n<urn:contki:c>\n"},
+		{`SELECT ?x WHERE { ?x :reachable :d MINUS { ?x :name ?n } }`,
+			"?x\n<urn:contki:c>\n"},
+		{`SELECT DISTINCT ?x WHERE { ?x :reachable ?y MINUS { ?x :link :d } }`,
+			"?x\n<urn:contki:a>\n"},
+		// without shared variables nothing is removed
+		{`SELECT ?x WHERE { ?x :age ?a MINUS { ?y :link ?z } } ORDER BY ?x`,
+			"?x\n<urn:contki:c>\n<urn:contki:d>\n"},
+		{`SELECT DISTINCT ?x WHERE { ?x :reachable ?y . FILTER NOT EXISTS { ?y :link ?z } } ORDER BY ?x`,
+			"?x\n<urn:contki:a>\n<urn:contki:b>\n<urn:contki:c>\n"},
+		{`SELECT ?x WHERE { ?x :link ?y FILTER EXISTS { ?y :age ?a } FILTER(?y = :c) }`,
+			"?x\n<urn:contki:a>\n"},
+		{`SELECT ?x WHERE { ?x :name ?n FILTER(regex(str(?n), "^b", "i")) }`,
+			"?x\n<urn:contki:b>\n"},
+		{`SELECT ?x ?a WHERE { ?x :age ?a FILTER(?a * 2 -1 = 59 || !isNumeric(?a)) }`,
+			"?x\t?a\n<urn:contki:c>\t30\n"},
+		{`SELECT ?x WHERE { ?x :link ?y FILTER(!bound(?z) && isIRI(?y) && strlen(?n) > 0) }`,
+			"?x\n"},
+		// blank nodes are not projected
+		{`SELECT * WHERE { :a :link _:m . _:m :link ?z }`,
+			"?z\n<urn:contki:d>\n<urn:contki:d>\n"},
+		{`SELECT * WHERE { ?x :link ?y } ORDER BY ?x ?y LIMIT 2 OFFSET 1`,
+			"?x\t?y\n<urn:contki:a>\t<urn:contki:c>\n<urn:contki:b>\t<urn:contki:d>\n"},
+		{`SELECT * WHERE { ?x :link ?y } LIMIT 0`,
+			"?x\t?y\n"},
+	} {
+		res := runTestSparql(t, &db, c.query)
+		if tsv := sparqlTSV(t, &res); tsv != c.expected {
+			t.Errorf("%s:\n%s\nexpected:\n%s", c.query, tsv, c.expected)
+		}
+	}
+
+	if res := runTestSparql(t, &db, `ASK { :a :reachable :d }`); !res.boolean {
+		t.Error(":a :reachable :d not found")
+	}
+	if res := runTestSparql(t, &db, `ASK WHERE { :d :reachable ?x }`); res.boolean {
+		t.Error(":d reaches something")
+	}
+
+	res := runTestSparql(t, &db, `CONSTRUCT { ?y :reachedBy ?x . ?y :via [] } WHERE { ?x :link ?y FILTER(?x = :a) }`)
+	if n := len(res.graph.rel(":reachedBy")); n != 2 || !res.graph.knows(newAtom(":c", ":reachedBy", ":a")) {
+		t.Errorf("constructed %d :reachedBy facts", n)
+	}
+	if via := res.graph.rel(":via"); len(via) != 2 || via[0][2] == via[1][2] {
+		t.Error("blank nodes not fresh per solution")
+	}
+	if err := res.writeTSV(&strings.Builder{}); err == nil {
+		t.Error("graph written as tsv")
+	}
+
+	res = runTestSparql(t, &db, `CONSTRUCT { ?y :reachedBy ?x } WHERE { ?x :link ?y } LIMIT 0`)
+	if !res.graph.empty() {
+		t.Error("constructed facts beyond limit 0")
+	}
+}
+
+func TestSparqlSameAs(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		@prefix owl: <http://www.w3.org/2002/07/owl#> .
+		:a :link :b .
+		:b :link :c .
+		:c :age 30 .
+		:a owl:sameAs :a2 .
+		:c owl:sameAs :c2 .
+	`)
+	db.enableSameAs()
+
+	for _, c := range []struct {
+		query, expected string
+	}{
+		// the join is on representatives, the solutions are expanded to
+		// all aliases
+		{`SELECT ?x ?z WHERE { ?x :link ?y . ?y :link ?z } ORDER BY ?x ?z`,
+			"?x\t?z\n<urn:contki:a>\t<urn:contki:c>\n<urn:contki:a>\t<urn:contki:c2>\n" +
+				"<urn:contki:a2>\t<urn:contki:c>\n<urn:contki:a2>\t<urn:contki:c2>\n"},
+		{`SELECT ?z ?a WHERE { :a2 :link ?y . ?y :link ?z OPTIONAL { ?z :age ?a } FILTER(?z = :c2) }`,
+			"?z\t?a\n<urn:contki:c2>\t30\n"},
+	} {
+		res := runTestSparql(t, &db, c.query)
+		if tsv := sparqlTSV(t, &res); tsv != c.expected {
+			t.Errorf("%s:\n%s\nexpected:\n%s", c.query, tsv, c.expected)
+		}
+	}
+}
+
+func TestSparqlJSON(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		:a :name "Alice" ; :age 30 .
+		:b :name "Bob"@en ; :score 1.5 .
+		_:c :name "C"^^<http://example.org/dt> .
+	`)
+
+	res := runTestSparql(t, &db, `SELECT ?x ?n ?v WHERE { ?x :name ?n OPTIONAL { ?x ?p ?v FILTER(isNumeric(?v)) } } ORDER BY ?x`)
+	var sb strings.Builder
+	if err := res.writeJSON(&sb); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "head": {
+    "vars": [
+      "x",
+      "n",
+      "v"
+    ]
+  },
+  "results": {
+    "bindings": [
+      {
+        "n": {
+          "type": "literal",
+          "value": "Alice"
+        },
+        "v": {
+          "type": "literal",
+          "value": "30",
+          "datatype": "http://www.w3.org/2001/XMLSchema#integer"
+        },
+        "x": {
+          "type": "uri",
+          "value": "urn:contki:a"
+        }
+      },
+      {
+        "n": {
+          "type": "literal",
+          "value": "Bob",
+          "xml:lang": "en"
+        },
+        "v": {
+          "type": "literal",
+          "value": "1.5",
+          "datatype": "http://www.w3.org/2001/XMLSchema#decimal"
+        },
+        "x": {
+          "type": "uri",
+          "value": "urn:contki:b"
+        }
+      },
+      {
+        "n": {
+          "type": "literal",
+          "value": "C",
+          "datatype": "http://example.org/dt"
+        },
+        "x": {
+          "type": "bnode",
+          "value": "` + strings.TrimPrefix(string(res.rows.rows[2][0].(Constant)), "_:") + `"
+        }
+      }
+    ]
+  }
+}
+`
+	if sb.String() != expected {
+		t.Errorf("wrong json:\n%s\nexpected:\n%s", sb.String(), expected)
+	}
+
+	res = runTestSparql(t, &db, `ASK { ?x :age ?a FILTER(?a < 18) }`)
+	sb.Reset()
+	if err := res.writeJSON(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if expected = "{\n  \"head\": {},\n  \"boolean\": false\n}\n"; sb.String() != expected {
+		t.Errorf("wrong json:\n%s\nexpected:\n%s", sb.String(), expected)
+	}
+}
+
+func TestSparqlErrors(t *testing.T) {
+	for _, src := range []string{
+		`SELECT WHERE { ?x :p ?y }`,
+		`SELECT ?x { ?x :p ?y ?x :q ?z }`,
+		`SELECT ?x { ?x :p ?y FILTER(foo(?x)) }`,
+		`SELECT ?x { ?x :p ?y FILTER(bound(:a)) }`,
+		`SELECT ?x { ?x :p ?y`,
+		`SELECT ?x { ?x :p ?y } LIMIT -1`,
+		`SELECT ?x { ?x ex:p ?y }`,
+		`DESCRIBE ?x { ?x :p ?y }`,
+		`ASK { ?x :p ?y } ?x`,
+	} {
+		if _, err := parseSparql(strings.NewReader(src)); err == nil {
+			t.Error("accepted", src)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import "fmt"
+
+// stratificationError reports a program that can not be stratified,
+// rule and atom locate the negated body atom closing the cycle
+type stratificationError struct {
+	rule, atom int
+	msg        string
+}
+
+func (e *stratificationError) Error() string {
+	return e.msg
+}
+
+// anyRelation stands for the relation of atoms with a variable
+// predicate, which may be any relation
+const anyRelation = Constant("")
+
+func relationOf(a *Atom) Constant {
+	if isVariable(a.p) {
+		return anyRelation
+	}
+	return a.p.(Constant)
+}
+
+// defines tests if rules with head relation h may derive facts of
+// relation c
+func defines(h, c Constant) bool {
+	return h == c || h == anyRelation || c == anyRelation
+}
+
+// dependsOn returns the head relations of prog the relation c depends
+// on, directly or transitively, including c itself
+func (prog *Program) dependsOn(c Constant) map[Constant]bool {
+	deps := map[Constant]bool{c: true}
+	todo := []Constant{c}
+	for len(todo) > 0 {
+		c_ := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		for _, r := range *prog {
+			if !defines(relationOf(&r.head), c_) {
+				continue
+			}
+			for _, a := range r.body {
+				p := relationOf(&a)
+				if !deps[p] {
+					deps[p] = true
+					todo = append(todo, p)
+				}
+			}
+		}
+	}
+	return deps
+}
+
+// stratify partitions prog into strata. A rule depends positively on
+// the relations of its own or lower strata and negatively only on
+// relations of lower strata, as do aggregate rules on all their body
+// relations. Evaluating the strata in order thus completes every
+// negated or aggregated relation before it is used. Programs with
+// negation or aggregation through recursion are rejected.
+func (prog *Program) stratify() ([]Program, error) {
+
+	stratum := make(map[Constant]int)
+	for _, r := range *prog {
+		stratum[relationOf(&r.head)] = 0
+	}
+
+	// the stratum of the relations atom a may refer to
+	stratumOf := func(a *Atom) (int, bool) {
+		s, found := 0, false
+		for h, s_ := range stratum {
+			if defines(h, relationOf(a)) {
+				s, found = max(s, s_), true
+			}
+		}
+		return s, found
+	}
+
+	// a stratum can only exceed the number of relations if a
+	// relation depends negatively on itself
+	n := len(stratum)
+	top := 0
+
+	for changed := true; changed; {
+		changed = false
+		for i, r := range *prog {
+			h := relationOf(&r.head)
+			for j, a := range r.body {
+				s, ok := stratumOf(&a)
+				if !ok {
+					continue
+				}
+				if a.neg || r.agg != nil {
+					s++
+				}
+				if s <= stratum[h] {
+					continue
+				}
+				if s > n {
+					i, j = prog.negationCycle(i, j)
+					r = (*prog)[i]
+					if r.agg != nil {
+						return nil, &stratificationError{i, j, fmt.Sprintf(
+							"aggregation through recursion: %s aggregates over %s, which depends on %s",
+							r.head.p, r.body[j].p, r.head.p)}
+					}
+					return nil, &stratificationError{i, j, fmt.Sprintf(
+						"negation through recursion: %s depends on not %s, which depends on %s",
+						r.head.p, r.body[j].p, r.head.p)}
+				}
+				stratum[h] = s
+				top = max(top, s)
+				changed = true
+			}
+		}
+	}
+
+	strata := make([]Program, top+1)
+	for _, r := range *prog {
+		s := stratum[relationOf(&r.head)]
+		strata[s] = append(strata[s], r)
+	}
+
+	// drop strata left empty by gaps
+	strata_ := make([]Program, 0, len(strata))
+	for _, s := range strata {
+		if len(s) > 0 {
+			strata_ = append(strata_, s)
+		}
+	}
+
+	return strata_, nil
+}
+
+// negationCycle finds a negated body atom or a body atom of an
+// aggregate rule whose relation depends on the head of its rule. Rule i, atom j is part of the cycle that made
+// stratify fail and serves as fallback.
+func (prog *Program) negationCycle(i, j int) (int, int) {
+	for i_, r := range *prog {
+		for j_, a := range r.body {
+			if (a.neg || r.agg != nil) && prog.dependsOn(relationOf(&a))[relationOf(&r.head)] {
+				return i_, j_
+			}
+		}
+	}
+	return i, j
+}
+
+// isMonotonic tests if prog is free of aggregation and of negation
+// over relations derived by prog or changed by changes. Negation over
+// other relations filters by facts that stay fixed, so semi-positive
+// programs are maintained incrementally.
+func (prog *Program) isMonotonic(changes ...*Database) bool {
+	return prog.monotonicOver(prog.heads(), changes)
+}
+
+// monotonicOver tests if prog is monotonic if the relations heads are
+// derived, see isMonotonic
+func (prog *Program) monotonicOver(heads []Constant, changes []*Database) bool {
+	for _, r := range *prog {
+		if r.agg != nil {
+			return false
+		}
+		for _, a := range r.body {
+			if !a.neg {
+				continue
+			}
+			c := relationOf(&a)
+			for _, h := range heads {
+				if defines(h, c) {
+					return false
+				}
+			}
+			for _, d := range changes {
+				if c == anyRelation && !d.empty() || len(d.rel(c)) > 0 {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// heads returns the head relations of prog
+func (prog *Program) heads() []Constant {
+	heads := make([]Constant, 0, len(*prog))
+	for _, r := range *prog {
+		heads = append(heads, relationOf(&r.head))
+	}
+	return heads
+}
+
+// splitMonotonic splits prog into a monotonic lower part and an upper
+// part holding the rules with aggregation or negation over derived or
+// changed relations, see isMonotonic, together with all rules depending
+// on them or sharing a head relation with them. New or deleted facts
+// may invalidate facts derived by the upper part, so only the lower
+// part is maintained incrementally by evalSeminaiveAppend and dRed,
+// the upper part is reevaluated.
+func (prog *Program) splitMonotonic(changes ...*Database) (Program, Program) {
+	strata, err := prog.stratify()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	heads := prog.heads()
+	upper := make(map[Constant]bool)
+	dependsOnUpper := func(r *Rule) bool {
+		if !(&Program{*r}).monotonicOver(heads, changes) {
+			return true
+		}
+		for _, a := range r.body {
+			for h := range upper {
+				if defines(h, relationOf(&a)) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := range *prog {
+			r := &(*prog)[i]
+			if h := relationOf(&r.head); !upper[h] && dependsOnUpper(r) {
+				upper[h], changed = true, true
+			}
+		}
+	}
+
+	low, high := make(Program, 0), make(Program, 0)
+	for _, s := range strata {
+		for _, r := range s {
+			if upper[relationOf(&r.head)] {
+				high = append(high, r)
+			} else {
+				low = append(low, r)
+			}
+		}
+	}
+
+	// heads with a variable predicate may derive facts of the lower
+	// part, which would be lost by reevaluating the upper part
+	for _, r := range high {
+		if isVariable(r.head.p) {
+			return make(Program, 0), *prog
+		}
+	}
+
+	return low, high
+}
+
+// reevaluate recomputes the head relations of prog from scratch
+func (prog *Program) reevaluate(db *Database) {
+	for _, r := range *prog {
+		if isVariable(r.head.p) {
+			db.clearIdb()
+			break
+		}
+		db.clearIdbRel(r.head.p.(Constant))
+	}
+	prog.evalSeminaive(db)
+}
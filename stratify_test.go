@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestStratify(t *testing.T) {
+
+	prog := mkStratifiedProgram()
+
+	strata, err := prog.stratify()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(strata) != 2 || len(strata[0]) != 3 || len(strata[1]) != 1 {
+		t.Fatal("wrong strata", strata)
+	}
+
+	if strata[1][0].head.p != Constant(":unreachable") {
+		t.Error("rule negating :reachable should be in the upper stratum", strata[1])
+	}
+
+	cyclic := Program{
+		Rule{head: newAtom("?x", ":win", "?y"), body: []Atom{
+			newAtom("?x", ":move", "?y"),
+			newNegAtom("?y", ":lose", "?x")}},
+		Rule{head: newAtom("?x", ":lose", "?y"), body: []Atom{
+			newAtom("?y", ":win", "?x")}},
+	}
+
+	_, err = cyclic.stratify()
+	se, ok := err.(*stratificationError)
+	if !ok || se.rule != 0 || se.atom != 1 {
+		t.Error("negation through recursion should be rejected", err)
+	}
+}
+
+func TestEvalStratified(t *testing.T) {
+
+	_, db := mkDatabase()
+	prog := mkStratifiedProgram()
+	prog.register(&db)
+
+	prog.evalSeminaive(&db)
+
+	if !db.knows(newAtom(":a", ":indirect", ":c")) || db.knows(newAtom(":a", ":indirect", ":b")) {
+		t.Error("wrong :indirect", decodeRel(db.rel(":indirect")))
+	}
+
+	// :d has no outgoing links, :a no incoming ones
+	if !db.knows(newAtom(":c", ":unreachable", ":b")) || db.knows(newAtom(":a", ":unreachable", ":d")) {
+		t.Error("wrong :unreachable", decodeRel(db.rel(":unreachable")))
+	}
+
+	naive := db.deepCopy()
+	naive.clearIdb()
+	prog.evalNaive(&naive)
+
+	if !naive.equalTo(&db) {
+		t.Error("naive and seminaive evaluation differ")
+	}
+
+	// inserting a link invalidates :unreachable facts
+	ins := db.shallowCopy()
+	ins.addAtom(newAtom(":d", ":link", ":b"))
+	prog.evalSeminaiveAppend(&db, &ins)
+
+	if db.knows(newAtom(":c", ":unreachable", ":b")) || !db.knows(newAtom(":d", ":indirect", ":c")) {
+		t.Error("insert was not maintained", decodeRel(db.rel(":unreachable")))
+	}
+
+	del := db.shallowCopy()
+	del.addAtom(newAtom(":d", ":link", ":b"))
+	dRed(&db, &del, &prog)
+
+	if !db.equalTo(&naive) {
+		t.Error("delete was not maintained")
+	}
+}
@@ -6,3 +6,10 @@ func max(x, y int) int {
 	}
 	return y
 }
+
+func min(x, y int) int {
+	if x <= y {
+		return x
+	}
+	return y
+}
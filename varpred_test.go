@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+func TestFindMappingsVariablePredicate(t *testing.T) {
+
+	_, db := mkDatabase()
+	db.addAtom(newAtom(":a", ":name", "\"A\""))
+	db.addAtom(newAtom(":c", ":next", ":c"))
+
+	omega := db.findMappingsFor(&Atom{Constant(":a"), Variable("?p"), Variable("?o"), false})
+	if len(omega) != 2 {
+		t.Error("expected a mapping per relation", omega)
+	}
+
+	omega = db.findMappingsFor(&Atom{Variable("?x"), Variable("?p"), Variable("?x"), false})
+	if len(omega) != 2 {
+		t.Error("expected the self loops of :link and :next", omega)
+	}
+
+	omega = db.findMappingsFor(&Atom{Variable("?x"), Variable("?p"), Variable("?y"), false})
+	if len(omega) != db.size() {
+		t.Error("expected all atoms", len(omega), db.size())
+	}
+}
+
+func TestVariablePredicateRules(t *testing.T) {
+
+	db := newDatabase()
+
+	prog, err := parseProgramString(`
+		:knows :subPropertyOf :related.
+		:link :subPropertyOf :related.
+		:related :subPropertyOf :connected.
+
+		:a :knows :b.
+		:a :link :c.
+		:a :related :z.
+
+		?x ?q ?y :- ?p :subPropertyOf ?q, ?x ?p ?y.
+	`, &db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prog.evalSeminaive(&db)
+
+	for _, o := range []string{":b", ":c", ":z"} {
+		if !db.knows(newAtom(":a", ":related", o)) || !db.knows(newAtom(":a", ":connected", o)) {
+			t.Error("not derived", o)
+		}
+	}
+
+	if !db.isIdbRelation(":connected") || !db.isEdbRelation(":related") || !db.isIdbRelation(":related") {
+		t.Error("derived relations were not registered")
+	}
+
+	if len(db.findMappingsFor(&Atom{Constant(":a"), Constant(":related"), Variable("?y"), false})) != 3 {
+		t.Error("base and derived facts should be found once each")
+	}
+
+	del := db.shallowCopy()
+	del.addAtom(newAtom(":link", ":subPropertyOf", ":related"))
+	del.addAtom(newAtom(":a", ":related", ":z"))
+	dRed(&db, &del, &prog)
+
+	if db.knows(newAtom(":a", ":related", ":c")) || db.knows(newAtom(":a", ":connected", ":z")) ||
+		!db.knows(newAtom(":a", ":connected", ":b")) {
+		t.Error("delete was not maintained")
+	}
+
+	db.commit()
+
+	ins := db.shallowCopy()
+	ins.addAtom(newAtom(":connected", ":subPropertyOf", ":near"))
+	prog.evalSeminaiveAppend(&db, &ins)
+
+	if !db.knows(newAtom(":a", ":near", ":b")) {
+		t.Error("insert was not maintained")
+	}
+
+	db.revert()
+
+	if db.knows(newAtom(":a", ":near", ":b")) || len(db.rel(":near")) != 0 {
+		t.Error("relation created after commit was not reverted")
+	}
+}
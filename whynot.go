@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Why-not explanations. A goal that is not derived is unified with the
+// heads of the rules, the body of each matching rule is evaluated in
+// rule order under the unifier until no mapping is left, and the body
+// atom or builtin at which that happens is reported. Missing ground
+// instances of derived relations are explained in turn, down to a
+// bounded depth.
+
+// WhyNot explains why goal is not derived. If it holds there is
+// nothing to explain.
+type WhyNot struct {
+	goal  Atom
+	holds bool
+	// cyclic is set if goal is explained already further up
+	cyclic bool
+	// truncated is set if the depth was exhausted
+	truncated bool
+	rules     []ruleFailure
+}
+
+// ruleFailure explains why rule does not derive the goal
+type ruleFailure struct {
+	rule *Rule
+	// atom is the body atom no mapping survives, builtin the builtin
+	// rejecting all mappings. Both are nil if rule derives the goal,
+	// i.e. db is not saturated, or for aggregates, if the aggregated
+	// value differs.
+	atom    *Atom
+	builtin *Builtin
+	// instances are the missing instances of a positive atom or the
+	// facts blocking a negated one
+	instances []Atom
+	// below explains the missing ground instances that prog derives
+	below []*WhyNot
+}
+
+// whyNot explains why prog does not derive the ground goal in db,
+// recursing into missing body facts up to depth levels
+func (prog *Program) whyNot(db *Database, goal Atom, depth int) *WhyNot {
+	if !goal.isGround() || !isConstant(goal.p) {
+		panic("only ground goals with constant predicate can be explained")
+	}
+	return prog.explain(db, goal, depth, make(map[triple]bool))
+}
+
+func (prog *Program) explain(db *Database, goal Atom, depth int, onPath map[triple]bool) *WhyNot {
+	w := &WhyNot{goal: goal}
+	if db.knows(goal) {
+		w.holds = true
+		return w
+	}
+	t := encodeAtom(&goal)
+	if onPath[t] {
+		w.cyclic = true
+		return w
+	}
+	if depth < 0 {
+		w.truncated = true
+		return w
+	}
+	onPath[t] = true
+	defer delete(onPath, t)
+
+	for i := range *prog {
+		r := &(*prog)[i]
+		if !r.head.matches(&goal) {
+			continue
+		}
+		mu := make(Mu)
+		if !r.head.isGround() {
+			mu = r.head.toMu(&goal)
+		}
+		if r.agg != nil {
+			delete(mu, r.agg.v)
+		}
+
+		f := db.ruleFailure(r, mu)
+		for _, a := range f.instances {
+			if !a.neg && a.isGround() && prog.derives(&a) {
+				f.below = append(f.below, prog.explain(db, a, depth-1, onPath))
+			}
+		}
+		w.rules = append(w.rules, f)
+	}
+	return w
+}
+
+// derives tests if a rule of prog may derive facts of the relation of a
+func (prog *Program) derives(a *Atom) bool {
+	for _, r := range *prog {
+		if defines(relationOf(&r.head), relationOf(a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleFailure evaluates the body of r under mu in rule order, builtins
+// as soon as their inputs are bound and negated atoms last, and
+// reports the first step leaving no mapping
+func (db *Database) ruleFailure(r *Rule, mu Mu) ruleFailure {
+	f := ruleFailure{rule: r}
+
+	omega := Omega{mu}
+	bound := make(map[Variable]bool)
+	for v := range mu {
+		bound[v] = true
+	}
+
+	pending := make([]Builtin, len(r.builtins))
+	copy(pending, r.builtins)
+
+	applyReadyBuiltins := func() bool {
+		for i := 0; i < len(pending); i++ {
+			if !pending[i].ready(bound) {
+				continue
+			}
+			b := pending[i]
+			if omega = b.filter(omega); len(omega) == 0 {
+				f.builtin = &b
+				return false
+			}
+			if b.out != "" {
+				bound[b.out] = true
+			}
+			pending = append(pending[:i], pending[i+1:]...)
+			i = -1
+		}
+		return true
+	}
+
+	if !applyReadyBuiltins() {
+		return f
+	}
+
+	for i := range r.body {
+		a := &r.body[i]
+		if a.neg {
+			continue
+		}
+
+		omega_ := make(Omega, 0, len(omega))
+		missing := make([]Atom, 0)
+		for _, mu := range omega {
+			mus := db.findMappingsBound(a, &mu)
+			if len(mus) == 0 {
+				missing = appendAtom(missing, a.bind(&mu))
+			}
+			for _, mu_ := range mus {
+				omega_ = append(omega_, mu.join(&mu_))
+			}
+		}
+		if omega = omega_; len(omega) == 0 {
+			f.atom, f.instances = a, missing
+			return f
+		}
+
+		for _, v := range atomVariables(a) {
+			bound[v] = true
+		}
+		if !applyReadyBuiltins() {
+			return f
+		}
+	}
+
+	for i := range r.body {
+		a := &r.body[i]
+		if !a.neg {
+			continue
+		}
+		blockers := make([]Atom, 0)
+		for _, mu := range omega {
+			a_ := a.bind(&mu)
+			a_.neg = false
+			if a_.isGround() && db.knows(a_) {
+				blockers = appendAtom(blockers, a_)
+			}
+		}
+		if omega = db.filterNeg(omega, a); len(omega) == 0 {
+			f.atom, f.instances = a, blockers
+			return f
+		}
+	}
+
+	return f
+}
+
+// appendAtom appends a to as unless it is contained already
+func appendAtom(as []Atom, a Atom) []Atom {
+	for _, a_ := range as {
+		if atomEqual(&a_, &a) {
+			return as
+		}
+	}
+	return append(as, a)
+}
+
+// String prints the explanation indented, each rule followed by the
+// step it fails at
+func (w *WhyNot) String() string {
+	var b strings.Builder
+	var write func(w *WhyNot, indent string)
+	write = func(w *WhyNot, indent string) {
+		b.WriteString(indent + atomString(&w.goal))
+		switch {
+		case w.holds:
+			b.WriteString(" holds\n")
+			return
+		case w.cyclic:
+			b.WriteString(" is explained above\n")
+			return
+		case w.truncated:
+			b.WriteString(" is not explained further\n")
+			return
+		case len(w.rules) == 0:
+			b.WriteString(" matches no rule head\n")
+			return
+		}
+		b.WriteString(" is not derived\n")
+		for _, f := range w.rules {
+			b.WriteString(indent + "  by " + f.rule.String() + "\n")
+			switch {
+			case f.builtin != nil:
+				b.WriteString(fmt.Sprintf("%s    %s fails\n", indent, f.builtin))
+			case f.atom != nil && f.atom.neg:
+				for _, a := range f.instances {
+					b.WriteString(indent + "    blocked by " + atomString(&a) + "\n")
+				}
+			case f.atom != nil:
+				for _, a := range f.instances {
+					b.WriteString(indent + "    missing " + atomString(&a) + "\n")
+				}
+			case f.rule.agg != nil:
+				b.WriteString(indent + "    aggregate differs\n")
+			default:
+				b.WriteString(indent + "    derives it, db is not saturated\n")
+			}
+			for _, w_ := range f.below {
+				write(w_, indent+"      ")
+			}
+		}
+	}
+	write(w, "")
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestWhyNot(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		:a :link :b .
+		:c :link :d .
+	`)
+	prog := mkProgram()
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+
+	w := prog.whyNot(&db, newAtom(":a", ":reachable", ":d"), 1)
+	expected := `:a :reachable :d is not derived
+  by ?x :reachable ?y :- ?x :link ?y.
+    missing :a :link :d
+  by ?x :reachable ?y :- ?x :link ?z, ?z :reachable ?y.
+    missing :b :reachable :d
+      :b :reachable :d is not derived
+        by ?x :reachable ?y :- ?x :link ?y.
+          missing :b :link :d
+        by ?x :reachable ?y :- ?x :link ?z, ?z :reachable ?y.
+          missing :b :link ?z
+`
+	if w.String() != expected {
+		t.Errorf("wrong explanation:\n%s\nexpected:\n%s", w, expected)
+	}
+
+	w = prog.whyNot(&db, newAtom(":a", ":reachable", ":d"), 0)
+	if below := w.rules[1].below; len(below) != 1 || !below[0].truncated {
+		t.Error("depth not bounded")
+	}
+
+	if w := prog.whyNot(&db, newAtom(":a", ":reachable", ":b"), 3); !w.holds {
+		t.Error("explained a derived fact")
+	}
+	if w := prog.whyNot(&db, newAtom(":a", ":knows", ":b"), 3); len(w.rules) != 0 {
+		t.Error("goal matched a rule head of another relation")
+	}
+
+	// :b :reachable :a is explained already when it comes up again
+	// below :a :reachable :a
+	db = loadTestTurtle(t, ":a :link :b .\n:b :link :b .")
+	prog.register(&db)
+	prog.evalSeminaive(&db)
+	w = prog.whyNot(&db, newAtom(":b", ":reachable", ":a"), 5)
+	if below := w.rules[1].below; len(below) != 1 || !below[0].cyclic {
+		t.Errorf("cycle not detected:\n%s", w)
+	}
+}
+
+func TestWhyNotNegationBuiltins(t *testing.T) {
+
+	db := loadTestTurtle(t, `
+		:pingu :a :Bird , :Penguin ; :age 3 .
+		:tweety :a :Bird ; :age 20 .
+	`)
+	prog := parseTestProgram(t, `
+		?x :can :fly :- ?x :has :Wings.
+		?x :can :fly :- ?x :a :Bird, not ?x :a :Penguin.
+		?x :adult :yes :- ?x :age ?a, ?a >= 18.
+		?x :lifts :weights :- ?x :adult :yes, ?x :can :fly.
+	`, &db)
+	prog.evalSeminaive(&db)
+
+	w := prog.whyNot(&db, newAtom(":pingu", ":can", ":fly"), 1)
+	if f := w.rules[1]; f.atom == nil || !f.atom.neg || len(f.instances) != 1 || f.instances[0] != newAtom(":pingu", ":a", ":Penguin") {
+		t.Errorf("negated blocker not reported:\n%s", w)
+	}
+
+	w = prog.whyNot(&db, newAtom(":pingu", ":adult", ":yes"), 1)
+	if f := w.rules[0]; f.builtin == nil || f.builtin.op != ">=" {
+		t.Errorf("failing builtin not reported:\n%s", w)
+	}
+
+	// the explanation of :pingu :lifts :weights descends into the first
+	// missing atom
+	w = prog.whyNot(&db, newAtom(":pingu", ":lifts", ":weights"), 2)
+	expected := `:pingu :lifts :weights is not derived
+  by ?x :lifts :weights :- ?x :adult :yes, ?x :can :fly.
+    missing :pingu :adult :yes
+      :pingu :adult :yes is not derived
+        by ?x :adult :yes :- ?x :age ?a, ?a >= 18.
+          ?a >= 18 fails
+`
+	if w.String() != expected {
+		t.Errorf("wrong explanation:\n%s\nexpected:\n%s", w, expected)
+	}
+
+	// the rule would derive the goal if db were saturated
+	db.addAtom(Atom{Constant(":bob"), Constant(":age"), Long(30), false})
+	w = prog.whyNot(&db, newAtom(":bob", ":adult", ":yes"), 0)
+	if f := w.rules[0]; f.atom != nil || f.builtin != nil {
+		t.Errorf("derivable goal not reported:\n%s", w)
+	}
+}